@@ -1,9 +1,11 @@
 package config
 
 import (
-	"log"
+	"log/slog"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -15,6 +17,55 @@ type Config struct {
 	MongoDBDatabase    string
 	CorsAllowedOrigins []string
 	Env                string
+
+	// How long viewers stay connected waiting for a dropped broadcaster to reconnect (0 disables)
+	BroadcasterGracePeriod time.Duration
+
+	// Pub/sub backend used to fan out broadcasts between instances ("memory" or "mongodb")
+	PubSubBackend string
+
+	// How often viewer counts are republished to other instances; counts of instances
+	// that miss three heartbeats are dropped
+	ViewerCountHeartbeat time.Duration
+
+	// Storage backend for streams, logs and feature flags ("mongodb", "memory" or "bolt"),
+	// and the database file used by the "bolt" backend
+	StorageBackend string
+	StoragePath    string
+
+	// How far from the navigation polyline, and for how long, before a stream is reported off route
+	OffRouteDistanceMeters float64
+	OffRouteDuration       time.Duration
+
+	// Limits on messages received over each WebSocket connection: maximum size, rate and
+	// burst, and how many consecutive messages over the rate are dropped before disconnecting
+	WSMaxMessageBytes        int64
+	WSMessageRate            float64
+	WSMessageBurst           int
+	WSMaxRateLimitedMessages int
+
+	// Maximum number of latest data and track point writes per stream and second (0 writes every frame)
+	StreamDataWritesPerSecond float64
+
+	// Consecutive messages a viewer may miss because it reads too slowly before it is evicted (0 never evicts)
+	ViewerMaxConsecutiveDrops int
+
+	// Stream creation quotas per client IP and per X-Client-Token, as a number of
+	// requests per window (0 disables), and the cap on active streams per creator
+	StreamCreateIPQuota        int
+	StreamCreateTokenQuota     int
+	StreamCreateQuotaWindow    time.Duration
+	MaxActiveStreamsPerCreator int
+
+	// Proxies trusted to set X-Forwarded-For, as IPs or CIDRs (none when empty)
+	TrustedProxies []string
+
+	// Key signing share tokens, shared by every instance (random per process when empty)
+	ShareTokenSecret string
+
+	// Log output format ("text" or "json") and minimum level ("debug", "info", "warn" or "error")
+	LogFormat string
+	LogLevel  string
 }
 
 var AppConfig *Config
@@ -22,7 +73,7 @@ var AppConfig *Config
 func Load() {
 	// Load .env file if it exists
 	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using environment variables")
+		slog.Info("No .env file found, using environment variables")
 	}
 
 	AppConfig = &Config{
@@ -32,9 +83,34 @@ func Load() {
 		MongoDBDatabase:    getEnv("MONGODB_DATABASE", "velocity"),
 		CorsAllowedOrigins: strings.Split(getEnv("CORS_ALLOWED_ORIGINS", "http://localhost:3000,http://localhost:5173"), ","),
 		Env:                getEnv("ENV", "development"),
+
+		BroadcasterGracePeriod: getEnvDuration("BROADCASTER_GRACE_PERIOD", 30*time.Second),
+		PubSubBackend:          getEnv("PUBSUB_BACKEND", "memory"),
+		ViewerCountHeartbeat:   getEnvDuration("VIEWER_COUNT_HEARTBEAT", 15*time.Second),
+		StorageBackend:         getEnv("STORAGE_BACKEND", "mongodb"),
+		StoragePath:            getEnv("STORAGE_PATH", "velocity.db"),
+		OffRouteDistanceMeters: getEnvFloat("OFF_ROUTE_DISTANCE_METERS", 75),
+		OffRouteDuration:       getEnvDuration("OFF_ROUTE_DURATION", 20*time.Second),
+		ShareTokenSecret:       getEnv("SHARE_TOKEN_SECRET", ""),
+
+		WSMaxMessageBytes:         int64(getEnvInt("WS_MAX_MESSAGE_BYTES", 512*1024)),
+		WSMessageRate:             getEnvFloat("WS_MESSAGE_RATE", 10),
+		WSMessageBurst:            getEnvInt("WS_MESSAGE_BURST", 20),
+		WSMaxRateLimitedMessages:  getEnvInt("WS_MAX_RATE_LIMITED_MESSAGES", 50),
+		StreamDataWritesPerSecond: getEnvFloat("STREAM_DATA_WRITES_PER_SECOND", 1),
+		ViewerMaxConsecutiveDrops: getEnvInt("VIEWER_MAX_CONSECUTIVE_DROPS", 100),
+
+		StreamCreateIPQuota:        getEnvInt("STREAM_CREATE_IP_QUOTA", 20),
+		StreamCreateTokenQuota:     getEnvInt("STREAM_CREATE_TOKEN_QUOTA", 10),
+		StreamCreateQuotaWindow:    getEnvDuration("STREAM_CREATE_QUOTA_WINDOW", time.Hour),
+		MaxActiveStreamsPerCreator: getEnvInt("MAX_ACTIVE_STREAMS_PER_CREATOR", 3),
+		TrustedProxies:             getEnvList("TRUSTED_PROXIES"),
+
+		LogFormat: getEnv("LOG_FORMAT", "text"),
+		LogLevel:  getEnv("LOG_LEVEL", "info"),
 	}
 
-	log.Printf("Configuration loaded for environment: %s", AppConfig.Env)
+	slog.Info("Configuration loaded", "env", AppConfig.Env)
 }
 
 func getEnv(key, defaultValue string) string {
@@ -43,3 +119,54 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		slog.Warn("Invalid duration, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return duration
+}
+
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		slog.Warn("Invalid number, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return defaultValue
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		slog.Warn("Invalid integer, using default", "key", key, "value", value, "default", defaultValue)
+		return defaultValue
+	}
+	return number
+}
+
+// getEnvList returns the comma separated values of key, or nil if it is unset or empty
+func getEnvList(key string) []string {
+	value := getEnv(key, "")
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
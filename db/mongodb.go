@@ -2,11 +2,12 @@ package db
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"time"
 
 	"velocity-be/config"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -33,18 +34,60 @@ func Connect() error {
 	Client = client
 	Database = client.Database(config.AppConfig.MongoDBDatabase)
 
-	log.Println("Connected to MongoDB successfully")
+	if err := ensureIndexes(ctx); err != nil {
+		slog.Error("Error creating MongoDB indexes", "error", err)
+	}
+
+	slog.Info("Connected to MongoDB", "database", config.AppConfig.MongoDBDatabase)
 	return nil
 }
 
+// ensureIndexes creates the indexes needed by range queries
+func ensureIndexes(ctx context.Context) error {
+	_, err := TrackPointsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "streamId", Value: 1}, {Key: "timestamp", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Counting the active streams of a creator
+	_, err = StreamsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "creatorKey", Value: 1}, {Key: "isActive", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = StreamsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "creatorTokenKey", Value: 1}, {Key: "isActive", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Summarising the viewers of a stream when it ends
+	_, err = StreamJoinLogsCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "streamId", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Loading the latest chat messages of a stream
+	_, err = ChatMessagesCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "streamId", Value: 1}, {Key: "sentAt", Value: -1}},
+	})
+	return err
+}
+
 func Disconnect() {
 	if Client != nil {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 		if err := Client.Disconnect(ctx); err != nil {
-			log.Printf("Error disconnecting from MongoDB: %v", err)
+			slog.Error("Error disconnecting from MongoDB", "error", err)
 		}
-		log.Println("Disconnected from MongoDB")
+		slog.Info("Disconnected from MongoDB")
 	}
 }
 
@@ -60,3 +103,19 @@ func StreamJoinLogsCollection() *mongo.Collection {
 func FeatureFlagsCollection() *mongo.Collection {
 	return Database.Collection("feature_flags")
 }
+
+func TrackPointsCollection() *mongo.Collection {
+	return Database.Collection("track_points")
+}
+
+func HubEventsCollection() *mongo.Collection {
+	return Database.Collection("hub_events")
+}
+
+func StreamEventsCollection() *mongo.Collection {
+	return Database.Collection("stream_events")
+}
+
+func ChatMessagesCollection() *mongo.Collection {
+	return Database.Collection("chat_messages")
+}
@@ -0,0 +1,23 @@
+package geo
+
+import "math"
+
+// EarthRadiusMeters is the mean radius of the Earth
+const EarthRadiusMeters = 6371000.0
+
+// DistanceMeters returns the great-circle distance between two points using the haversine formula
+func DistanceMeters(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := toRadians(lat1)
+	phi2 := toRadians(lat2)
+	deltaPhi := toRadians(lat2 - lat1)
+	deltaLambda := toRadians(lon2 - lon1)
+
+	a := math.Sin(deltaPhi/2)*math.Sin(deltaPhi/2) +
+		math.Cos(phi1)*math.Cos(phi2)*math.Sin(deltaLambda/2)*math.Sin(deltaLambda/2)
+
+	return 2 * EarthRadiusMeters * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+func toRadians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
@@ -0,0 +1,71 @@
+package geo
+
+import "math"
+
+// Snap is the closest point of a polyline to a given location
+type Snap struct {
+	Latitude            float64 // Snapped position on the polyline
+	Longitude           float64
+	SegmentIndex        int     // Index of the segment's first point in the polyline
+	DistanceMeters      float64 // Distance from the location to the snapped position
+	DistanceAlongMeters float64 // Distance from the start of the polyline to the snapped position
+	LengthMeters        float64 // Total length of the polyline
+}
+
+// RemainingMeters returns the distance from the snapped position to the end of the polyline
+func (s Snap) RemainingMeters() float64 {
+	return math.Max(0, s.LengthMeters-s.DistanceAlongMeters)
+}
+
+// SnapToPolyline finds the point of a polyline of [lat, long] pairs closest to the
+// given location. It returns false if the polyline has fewer than two points.
+func SnapToPolyline(polyline [][]float64, latitude, longitude float64) (Snap, bool) {
+	if len(polyline) < 2 {
+		return Snap{}, false
+	}
+
+	best := Snap{DistanceMeters: math.Inf(1)}
+	along := 0.0
+
+	for i := 0; i < len(polyline)-1; i++ {
+		aLat, aLon := polyline[i][0], polyline[i][1]
+		bLat, bLon := polyline[i+1][0], polyline[i+1][1]
+
+		t := projectOntoSegment(aLat, aLon, bLat, bLon, latitude, longitude)
+		snapLat := aLat + t*(bLat-aLat)
+		snapLon := aLon + t*(bLon-aLon)
+
+		distance := DistanceMeters(latitude, longitude, snapLat, snapLon)
+		if distance < best.DistanceMeters {
+			best = Snap{
+				Latitude:            snapLat,
+				Longitude:           snapLon,
+				SegmentIndex:        i,
+				DistanceMeters:      distance,
+				DistanceAlongMeters: along + DistanceMeters(aLat, aLon, snapLat, snapLon),
+			}
+		}
+
+		along += DistanceMeters(aLat, aLon, bLat, bLon)
+	}
+
+	best.LengthMeters = along
+	return best, true
+}
+
+// projectOntoSegment returns the position (0 to 1) along segment A-B closest to point P,
+// using an equirectangular projection around A, which is accurate for route-sized segments
+func projectOntoSegment(aLat, aLon, bLat, bLon, pLat, pLon float64) float64 {
+	scale := math.Cos(toRadians(aLat))
+
+	abX, abY := (bLon-aLon)*scale, bLat-aLat
+	apX, apY := (pLon-aLon)*scale, pLat-aLat
+
+	lengthSquared := abX*abX + abY*abY
+	if lengthSquared == 0 {
+		return 0
+	}
+
+	t := (apX*abX + apY*abY) / lengthSquared
+	return math.Max(0, math.Min(1, t))
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"velocity-be/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// inviteCodeBytes is the number of random bytes in an invite code (16 hex characters)
+const inviteCodeBytes = 8
+
+// accessCodeFailures limits wrong passcodes and invite codes per stream and client IP,
+// so short passcodes can't be guessed and each guess's bcrypt compare can't be used to
+// exhaust the CPU. A client over the quota gets 429 whatever code it sends.
+var accessCodeFailures = newQuotaLimiter(Quota{Requests: 10, Window: 10 * time.Minute})
+
+// applyAccessSettings stores the requested visibility on a new stream and returns the
+// invite codes issued for invite_list streams. The request must have passed ValidateAccess.
+func applyAccessSettings(stream *models.Stream, req *models.CreateStreamRequest) ([]models.InviteCode, error) {
+	stream.Visibility = req.Visibility
+
+	switch req.Visibility {
+	case models.VisibilityPasscode:
+		hash, err := bcrypt.GenerateFromPassword([]byte(req.Passcode), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, err
+		}
+		stream.PasscodeHash = string(hash)
+
+	case models.VisibilityInviteList:
+		codes := make([]models.InviteCode, 0, len(req.Invitees))
+		for _, name := range req.Invitees {
+			bytes := make([]byte, inviteCodeBytes)
+			if _, err := rand.Read(bytes); err != nil {
+				return nil, err
+			}
+
+			code := models.InviteCode{
+				ID:   uuid.New().String(),
+				Name: name,
+				Code: hex.EncodeToString(bytes),
+			}
+			codes = append(codes, code)
+			stream.Invites = append(stream.Invites, models.StreamInvite{
+				ID:       code.ID,
+				Name:     name,
+				CodeHash: hashBroadcasterToken(code.Code),
+			})
+		}
+		return codes, nil
+	}
+
+	return nil, nil
+}
+
+// accessCodeFromRequest reads a viewer's passcode or invite code from the
+// "X-Access-Code" header, falling back to the "code" query parameter
+func accessCodeFromRequest(c *gin.Context) string {
+	if code := c.GetHeader("X-Access-Code"); code != "" {
+		return code
+	}
+	return c.Query("code")
+}
+
+// authorizeViewer enforces the stream's bans and visibility. The broadcaster token always grants
+// access, a valid share token grants access if its scope covers the endpoint's, and is returned.
+// It writes a 401/403/429 response and returns false when the request is not authorized.
+func authorizeViewer(c *gin.Context, stream *models.Stream, scope string) (*models.ShareToken, bool) {
+	if token := broadcasterTokenFromRequest(c); token != "" && broadcasterTokenMatches(token, stream) {
+		return nil, true
+	}
+
+	if rejectBanned(c, stream) {
+		return nil, false
+	}
+
+	if token := shareTokenFromRequest(c); token != "" {
+		shareToken, err := verifyShareToken(token, stream)
+		switch err {
+		case nil:
+		case errShareTokenExpired:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Share token expired"})
+			return nil, false
+		case errShareTokenRevoked:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Share token revoked"})
+			return nil, false
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid share token"})
+			return nil, false
+		}
+
+		if shareScopeGrants(shareToken.Scope, scope) {
+			return shareToken, true
+		}
+
+		// Public streams don't need the token for history
+		if !isPrivate(stream) {
+			return nil, true
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": "Share token does not grant access to history"})
+		return nil, false
+	}
+
+	code := accessCodeFromRequest(c)
+	failureKey := stream.StreamID + ":" + c.ClientIP()
+
+	switch stream.Visibility {
+	case models.VisibilityPasscode:
+		if code == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Passcode required"})
+			return nil, false
+		}
+		if rejectTooManyFailures(c, failureKey) {
+			return nil, false
+		}
+		if bcrypt.CompareHashAndPassword([]byte(stream.PasscodeHash), []byte(code)) != nil {
+			accessCodeFailures.allow(failureKey)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid passcode"})
+			return nil, false
+		}
+
+	case models.VisibilityInviteList:
+		if code == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invite code required"})
+			return nil, false
+		}
+		if rejectTooManyFailures(c, failureKey) {
+			return nil, false
+		}
+		if findInvite(stream, code) == nil {
+			accessCodeFailures.allow(failureKey)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invalid invite code"})
+			return nil, false
+		}
+	}
+
+	return nil, true
+}
+
+// isPrivate reports whether viewers need a code or share token to watch the stream
+func isPrivate(stream *models.Stream) bool {
+	return stream.Visibility == models.VisibilityPasscode || stream.Visibility == models.VisibilityInviteList
+}
+
+// rejectTooManyFailures writes a 429 response and returns true when the client used up
+// its quota of wrong access codes for the stream
+func rejectTooManyFailures(c *gin.Context, failureKey string) bool {
+	exhausted, retryAfter := accessCodeFailures.exhausted(failureKey)
+	if !exhausted {
+		return false
+	}
+
+	setRetryAfter(c, retryAfter)
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many wrong codes, try again later"})
+	return true
+}
+
+// rejectBanned writes a 403 response and returns true when the broadcaster banned the
+// request's client IP from the stream
+func rejectBanned(c *gin.Context, stream *models.Stream) bool {
+	ipAddress := c.ClientIP()
+	for _, ban := range stream.Bans {
+		if ban.IPAddress == ipAddress {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Banned from this stream"})
+			return true
+		}
+	}
+	return false
+}
+
+// isMuted reports whether the broadcaster muted the chat of an IP address on the stream
+func isMuted(stream *models.Stream, ipAddress string) bool {
+	for _, mute := range stream.Mutes {
+		if mute.IPAddress == ipAddress {
+			return true
+		}
+	}
+	return false
+}
+
+// findInvite returns the stream's invite matching the code, or nil
+func findInvite(stream *models.Stream, code string) *models.StreamInvite {
+	hash := hashBroadcasterToken(code)
+	for i := range stream.Invites {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(stream.Invites[i].CodeHash)) == 1 {
+			return &stream.Invites[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultChatHistorySize is the number of chat messages returned when no limit is given
+	defaultChatHistorySize = 100
+
+	// maxChatHistorySize is the largest number of chat messages a client can request
+	maxChatHistorySize = 500
+)
+
+// GetChatHistoryHandler returns the latest chat messages of a stream, oldest first,
+// so viewers joining late can catch up. Supports an optional "limit".
+func GetChatHistoryHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+
+		limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(defaultChatHistorySize)), 10, 64)
+		if err != nil || limit < 1 || limit > maxChatHistorySize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'limit', must be between 1 and " + strconv.Itoa(maxChatHistorySize)})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if _, ok := authorizeViewer(c, stream, models.ShareScopeLive); !ok {
+			return
+		}
+
+		messages, err := s.ChatMessages().List(ctx, streamID, limit)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load chat"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.ChatHistoryResponse{
+			StreamID: streamID,
+			Messages: messages,
+		})
+	}
+}
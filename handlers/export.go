@@ -0,0 +1,552 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxExportPoints caps the number of track points rendered into an export
+const maxExportPoints = 100000
+
+// exportFormat describes how a drive is rendered for download
+type exportFormat struct {
+	ContentType string
+	Extension   string
+	Render      func(meta exportMetadata, points []models.TrackPoint) ([]byte, error)
+}
+
+var exportFormats = map[string]exportFormat{
+	"gpx":     {ContentType: "application/gpx+xml", Extension: "gpx", Render: renderGPX},
+	"kml":     {ContentType: "application/vnd.google-earth.kml+xml", Extension: "kml", Render: renderKML},
+	"geojson": {ContentType: "application/geo+json", Extension: "geojson", Render: renderGeoJSON},
+	"csv":     {ContentType: "text/csv", Extension: "csv", Render: renderCSV},
+}
+
+// exportLocation is a named point such as the start or destination of a drive
+type exportLocation struct {
+	Name      string
+	Address   string
+	Latitude  float64
+	Longitude float64
+}
+
+// exportMetadata holds the drive details shared by all export formats
+type exportMetadata struct {
+	StreamID    string
+	Name        string
+	CarName     string
+	StartedAt   time.Time
+	Duration    time.Duration
+	DistanceKm  float64
+	MaxSpeedKmh float64
+	Start       *exportLocation
+	Destination *exportLocation
+	Route       [][]float64 // Planned route as [lat, long] pairs
+}
+
+// Description returns a human-readable summary of the drive
+func (m exportMetadata) Description() string {
+	parts := []string{}
+	if m.CarName != "" {
+		parts = append(parts, "Car: "+m.CarName)
+	}
+	parts = append(parts,
+		"Duration: "+m.Duration.Round(time.Second).String(),
+		fmt.Sprintf("Distance: %.2f km", m.DistanceKm),
+		fmt.Sprintf("Max speed: %.1f km/h", m.MaxSpeedKmh),
+	)
+	return strings.Join(parts, ", ")
+}
+
+// ExportStreamHandler renders the recorded drive of a stream as GPX, KML, GeoJSON or CSV
+func ExportStreamHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+
+		formatName := strings.ToLower(c.DefaultQuery("format", "gpx"))
+		format, ok := exportFormats[formatName]
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, expected one of gpx, kml, geojson, csv"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if _, ok := authorizeViewer(c, stream, models.ShareScopeLiveHistory); !ok {
+			return
+		}
+
+		points, err := s.TrackPoints().List(ctx, store.TrackQuery{
+			StreamID: streamID,
+			Limit:    maxExportPoints,
+		})
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load track"})
+			return
+		}
+
+		body, err := format.Render(buildExportMetadata(stream, points), points)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render export"})
+			return
+		}
+
+		filename := fmt.Sprintf("velocity-%s.%s", shortStreamID(streamID), format.Extension)
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+		c.Data(http.StatusOK, format.ContentType, body)
+	}
+}
+
+// buildExportMetadata derives the drive details from the stream's latest data and track
+func buildExportMetadata(stream *models.Stream, points []models.TrackPoint) exportMetadata {
+	meta := exportMetadata{
+		StreamID:  stream.StreamID,
+		Name:      "Velocity drive " + shortStreamID(stream.StreamID),
+		StartedAt: stream.CreatedAt,
+	}
+
+	if len(points) > 0 {
+		meta.StartedAt = points[0].Timestamp
+		meta.Duration = points[len(points)-1].Timestamp.Sub(points[0].Timestamp)
+	}
+
+	for _, point := range points {
+		if point.SpeedKmh > meta.MaxSpeedKmh {
+			meta.MaxSpeedKmh = point.SpeedKmh
+		}
+	}
+
+	data := stream.LatestData
+	if data == nil {
+		return meta
+	}
+
+	meta.CarName = strings.TrimSpace(data.Car.Name + " " + data.Car.Model)
+	if meta.CarName != "" {
+		meta.Name = "Velocity drive - " + meta.CarName
+	}
+	if data.Duration > 0 {
+		meta.Duration = time.Duration(data.Duration * float64(time.Second))
+	}
+	if data.MaxSpeedKmh > meta.MaxSpeedKmh {
+		meta.MaxSpeedKmh = data.MaxSpeedKmh
+	}
+	meta.DistanceKm = data.DistanceKm
+
+	if data.StartLatitude != 0 || data.StartLongitude != 0 {
+		meta.Start = &exportLocation{
+			Name:      "Start",
+			Address:   joinAddress(data.StartAddressLine, data.StartPostalCode, data.StartCity),
+			Latitude:  data.StartLatitude,
+			Longitude: data.StartLongitude,
+		}
+	}
+
+	if data.DestinationLatitude != 0 || data.DestinationLongitude != 0 {
+		name := data.DestinationName
+		if name == "" {
+			name = "Destination"
+		}
+		meta.Destination = &exportLocation{
+			Name:      name,
+			Address:   joinAddress(data.DestinationAddressLine, data.DestinationPostalCode, data.DestinationCity),
+			Latitude:  data.DestinationLatitude,
+			Longitude: data.DestinationLongitude,
+		}
+	}
+
+	if data.NavigationData != nil {
+		meta.Route = data.NavigationData.Polyline
+	}
+
+	return meta
+}
+
+// joinAddress joins the non-empty address parts with commas
+func joinAddress(parts ...string) string {
+	nonEmpty := []string{}
+	for _, part := range parts {
+		if part = strings.TrimSpace(part); part != "" {
+			nonEmpty = append(nonEmpty, part)
+		}
+	}
+	return strings.Join(nonEmpty, ", ")
+}
+
+// shortStreamID returns a short prefix of the stream ID suitable for names
+func shortStreamID(streamID string) string {
+	if len(streamID) > 8 {
+		return streamID[:8]
+	}
+	return streamID
+}
+
+// ==================== GPX ====================
+
+type gpxDocument struct {
+	XMLName   xml.Name      `xml:"gpx"`
+	Xmlns     string        `xml:"xmlns,attr"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Metadata  gpxMetadata   `xml:"metadata"`
+	Waypoints []gpxWaypoint `xml:"wpt"`
+	Route     *gpxRoute     `xml:"rte,omitempty"`
+	Track     gpxTrack      `xml:"trk"`
+}
+
+type gpxMetadata struct {
+	Name string `xml:"name"`
+	Desc string `xml:"desc"`
+	Time string `xml:"time"`
+}
+
+type gpxWaypoint struct {
+	Lat  float64 `xml:"lat,attr"`
+	Lon  float64 `xml:"lon,attr"`
+	Time string  `xml:"time,omitempty"`
+	Name string  `xml:"name,omitempty"`
+	Desc string  `xml:"desc,omitempty"`
+}
+
+type gpxRoute struct {
+	Name   string        `xml:"name"`
+	Points []gpxWaypoint `xml:"rtept"`
+}
+
+type gpxTrack struct {
+	Name    string        `xml:"name"`
+	Desc    string        `xml:"desc"`
+	Segment []gpxWaypoint `xml:"trkseg>trkpt"`
+}
+
+func renderGPX(meta exportMetadata, points []models.TrackPoint) ([]byte, error) {
+	doc := gpxDocument{
+		Xmlns:   "http://www.topografix.com/GPX/1/1",
+		Version: "1.1",
+		Creator: "Velocity",
+		Metadata: gpxMetadata{
+			Name: meta.Name,
+			Desc: meta.Description(),
+			Time: meta.StartedAt.UTC().Format(time.RFC3339),
+		},
+		Track: gpxTrack{
+			Name: meta.Name,
+			Desc: meta.Description(),
+		},
+	}
+
+	for _, location := range []*exportLocation{meta.Start, meta.Destination} {
+		if location != nil {
+			doc.Waypoints = append(doc.Waypoints, gpxWaypoint{
+				Lat:  location.Latitude,
+				Lon:  location.Longitude,
+				Name: location.Name,
+				Desc: location.Address,
+			})
+		}
+	}
+
+	if len(meta.Route) > 0 {
+		doc.Route = &gpxRoute{Name: "Planned route"}
+		for _, coord := range meta.Route {
+			if len(coord) >= 2 {
+				doc.Route.Points = append(doc.Route.Points, gpxWaypoint{Lat: coord[0], Lon: coord[1]})
+			}
+		}
+	}
+
+	for _, point := range points {
+		doc.Track.Segment = append(doc.Track.Segment, gpxWaypoint{
+			Lat:  point.Latitude,
+			Lon:  point.Longitude,
+			Time: point.Timestamp.UTC().Format(time.RFC3339),
+		})
+	}
+
+	return marshalXML(doc)
+}
+
+// ==================== KML ====================
+
+type kmlDocument struct {
+	XMLName  xml.Name `xml:"kml"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Document struct {
+		Name        string         `xml:"name"`
+		Description string         `xml:"description"`
+		Placemarks  []kmlPlacemark `xml:"Placemark"`
+	} `xml:"Document"`
+}
+
+type kmlPlacemark struct {
+	Name        string         `xml:"name"`
+	Description string         `xml:"description,omitempty"`
+	Point       *kmlGeometry   `xml:"Point,omitempty"`
+	LineString  *kmlGeometry   `xml:"LineString,omitempty"`
+	TimeSpan    *kmlTimeSpan   `xml:"TimeSpan,omitempty"`
+	Extended    []kmlDataField `xml:"ExtendedData>Data,omitempty"`
+}
+
+type kmlGeometry struct {
+	Coordinates string `xml:"coordinates"`
+}
+
+type kmlTimeSpan struct {
+	Begin string `xml:"begin"`
+	End   string `xml:"end"`
+}
+
+type kmlDataField struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+func renderKML(meta exportMetadata, points []models.TrackPoint) ([]byte, error) {
+	doc := kmlDocument{Xmlns: "http://www.opengis.net/kml/2.2"}
+	doc.Document.Name = meta.Name
+	doc.Document.Description = meta.Description()
+
+	for _, location := range []*exportLocation{meta.Start, meta.Destination} {
+		if location != nil {
+			doc.Document.Placemarks = append(doc.Document.Placemarks, kmlPlacemark{
+				Name:        location.Name,
+				Description: location.Address,
+				Point:       &kmlGeometry{Coordinates: kmlCoordinate(location.Latitude, location.Longitude)},
+			})
+		}
+	}
+
+	if len(meta.Route) > 0 {
+		coords := make([]string, 0, len(meta.Route))
+		for _, coord := range meta.Route {
+			if len(coord) >= 2 {
+				coords = append(coords, kmlCoordinate(coord[0], coord[1]))
+			}
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, kmlPlacemark{
+			Name:       "Planned route",
+			LineString: &kmlGeometry{Coordinates: strings.Join(coords, " ")},
+		})
+	}
+
+	if len(points) > 0 {
+		coords := make([]string, 0, len(points))
+		for _, point := range points {
+			coords = append(coords, kmlCoordinate(point.Latitude, point.Longitude))
+		}
+		doc.Document.Placemarks = append(doc.Document.Placemarks, kmlPlacemark{
+			Name:        "Driven route",
+			Description: meta.Description(),
+			LineString:  &kmlGeometry{Coordinates: strings.Join(coords, " ")},
+			TimeSpan: &kmlTimeSpan{
+				Begin: points[0].Timestamp.UTC().Format(time.RFC3339),
+				End:   points[len(points)-1].Timestamp.UTC().Format(time.RFC3339),
+			},
+			Extended: []kmlDataField{
+				{Name: "car", Value: meta.CarName},
+				{Name: "durationSeconds", Value: strconv.FormatFloat(meta.Duration.Seconds(), 'f', 0, 64)},
+				{Name: "distanceKm", Value: strconv.FormatFloat(meta.DistanceKm, 'f', 2, 64)},
+				{Name: "maxSpeedKmh", Value: strconv.FormatFloat(meta.MaxSpeedKmh, 'f', 1, 64)},
+			},
+		})
+	}
+
+	return marshalXML(doc)
+}
+
+// kmlCoordinate formats a point in KML's "long,lat" order
+func kmlCoordinate(lat, lon float64) string {
+	return strconv.FormatFloat(lon, 'f', -1, 64) + "," + strconv.FormatFloat(lat, 'f', -1, 64)
+}
+
+func marshalXML(doc interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return buf.Bytes(), nil
+}
+
+// ==================== GeoJSON ====================
+
+type geoJSONFeatureCollection struct {
+	Type     string                 `json:"type"`
+	Metadata map[string]interface{} `json:"metadata"`
+	Features []geoJSONFeature       `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string                 `json:"type"`
+	Geometry   geoJSONGeometry        `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string      `json:"type"`
+	Coordinates interface{} `json:"coordinates"`
+}
+
+func renderGeoJSON(meta exportMetadata, points []models.TrackPoint) ([]byte, error) {
+	collection := geoJSONFeatureCollection{
+		Type: "FeatureCollection",
+		Metadata: map[string]interface{}{
+			"streamId":        meta.StreamID,
+			"name":            meta.Name,
+			"car":             meta.CarName,
+			"startedAt":       meta.StartedAt.UTC().Format(time.RFC3339),
+			"durationSeconds": meta.Duration.Seconds(),
+			"distanceKm":      meta.DistanceKm,
+			"maxSpeedKmh":     meta.MaxSpeedKmh,
+		},
+		Features: []geoJSONFeature{},
+	}
+
+	for _, location := range []*exportLocation{meta.Start, meta.Destination} {
+		if location != nil {
+			collection.Features = append(collection.Features, geoJSONFeature{
+				Type:     "Feature",
+				Geometry: geoJSONGeometry{Type: "Point", Coordinates: []float64{location.Longitude, location.Latitude}},
+				Properties: map[string]interface{}{
+					"name":    location.Name,
+					"address": location.Address,
+				},
+			})
+		}
+	}
+
+	if len(meta.Route) > 0 {
+		coords := make([][]float64, 0, len(meta.Route))
+		for _, coord := range meta.Route {
+			if len(coord) >= 2 {
+				coords = append(coords, []float64{coord[1], coord[0]})
+			}
+		}
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONGeometry{Type: "LineString", Coordinates: coords},
+			Properties: map[string]interface{}{"name": "Planned route"},
+		})
+	}
+
+	if len(points) > 0 {
+		coords := make([][]float64, 0, len(points))
+		times := make([]string, 0, len(points))
+		speeds := make([]float64, 0, len(points))
+		for _, point := range points {
+			coords = append(coords, []float64{point.Longitude, point.Latitude})
+			times = append(times, point.Timestamp.UTC().Format(time.RFC3339))
+			speeds = append(speeds, point.SpeedKmh)
+		}
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:     "Feature",
+			Geometry: geoJSONGeometry{Type: "LineString", Coordinates: coords},
+			Properties: map[string]interface{}{
+				"name":        "Driven route",
+				"description": meta.Description(),
+				"times":       times,
+				"speedsKmh":   speeds,
+			},
+		})
+	}
+
+	return json.MarshalIndent(collection, "", "  ")
+}
+
+// ==================== CSV ====================
+
+// csvSafe prefixes free-text values that spreadsheets would evaluate as a formula
+// with a single quote, so they are displayed as text
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+func renderCSV(meta exportMetadata, points []models.TrackPoint) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	// Drive metadata as leading comment rows, which CSV readers can skip with '#' as comment character
+	metadata := [][]string{
+		{"# streamId", meta.StreamID},
+		{"# name", csvSafe(meta.Name)},
+		{"# car", csvSafe(meta.CarName)},
+		{"# startedAt", meta.StartedAt.UTC().Format(time.RFC3339)},
+		{"# durationSeconds", strconv.FormatFloat(meta.Duration.Seconds(), 'f', -1, 64)},
+		{"# distanceKm", strconv.FormatFloat(meta.DistanceKm, 'f', 2, 64)},
+		{"# maxSpeedKmh", strconv.FormatFloat(meta.MaxSpeedKmh, 'f', -1, 64)},
+	}
+	for _, location := range []struct {
+		key      string
+		location *exportLocation
+	}{{"# start", meta.Start}, {"# destination", meta.Destination}} {
+		if location.location != nil {
+			metadata = append(metadata, []string{
+				location.key,
+				csvSafe(location.location.Address),
+				strconv.FormatFloat(location.location.Latitude, 'f', -1, 64),
+				strconv.FormatFloat(location.location.Longitude, 'f', -1, 64),
+			})
+		}
+	}
+	if len(meta.Route) > 0 {
+		// Planned route as "lat long" pairs separated by semicolons
+		coords := make([]string, 0, len(meta.Route))
+		for _, coord := range meta.Route {
+			if len(coord) >= 2 {
+				coords = append(coords, strconv.FormatFloat(coord[0], 'f', -1, 64)+" "+strconv.FormatFloat(coord[1], 'f', -1, 64))
+			}
+		}
+		metadata = append(metadata, []string{"# route", strings.Join(coords, ";")})
+	}
+	if err := writer.WriteAll(metadata); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Write([]string{"timestamp", "latitude", "longitude", "speedKmh", "isPaused"}); err != nil {
+		return nil, err
+	}
+
+	for _, point := range points {
+		record := []string{
+			point.Timestamp.UTC().Format(time.RFC3339Nano),
+			strconv.FormatFloat(point.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(point.Longitude, 'f', -1, 64),
+			strconv.FormatFloat(point.SpeedKmh, 'f', -1, 64),
+			strconv.FormatBool(point.IsPaused),
+		}
+		if err := writer.Write(record); err != nil {
+			return nil, err
+		}
+	}
+
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"velocity-be/hub"
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxEventLogEntries caps the number of events returned by the event log
+const maxEventLogEntries = 1000
+
+// prepareGeofences validates geofences and assigns IDs to those without one
+func prepareGeofences(fences []models.Geofence) error {
+	if err := models.ValidateGeofences(fences); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(fences))
+	for i := range fences {
+		if fences[i].ID == "" {
+			fences[i].ID = uuid.New().String()
+		}
+		if seen[fences[i].ID] {
+			return fmt.Errorf("duplicate geofence id: %s", fences[i].ID)
+		}
+		seen[fences[i].ID] = true
+	}
+
+	return nil
+}
+
+// UpdateGeofencesHandler replaces the geofences of a stream.
+// Requires the broadcaster token issued when the stream was created.
+func UpdateGeofencesHandler(s store.Store, h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+
+		var req models.GeofencesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		if err := prepareGeofences(req.Geofences); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if !verifyBroadcasterToken(c, stream) {
+			return
+		}
+
+		if stream.DeletedAt != nil {
+			c.JSON(http.StatusGone, gin.H{"error": "Stream has been closed"})
+			return
+		}
+
+		if req.Geofences == nil {
+			req.Geofences = []models.Geofence{}
+		}
+
+		if err := s.Streams().UpdateGeofences(ctx, streamID, req.Geofences); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update geofences"})
+			return
+		}
+
+		h.SetGeofences(streamID, req.Geofences)
+
+		c.JSON(http.StatusOK, gin.H{
+			"streamId":  streamID,
+			"geofences": req.Geofences,
+		})
+	}
+}
+
+// GetStreamEventLogHandler returns the arrival and zone events recorded for a stream
+func GetStreamEventLogHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if _, ok := authorizeViewer(c, stream, models.ShareScopeLiveHistory); !ok {
+			return
+		}
+
+		events, err := s.StreamEvents().List(ctx, streamID, maxEventLogEntries)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load events"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"streamId": streamID,
+			"events":   events,
+		})
+	}
+}
@@ -3,23 +3,27 @@ package handlers
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
-	"log"
+	"encoding/json"
+	"io"
 	"net/http"
+	"strings"
 	"time"
 
-	"velocity-be/db"
 	"velocity-be/hub"
+	"velocity-be/logging"
 	"velocity-be/models"
+	"velocity-be/store"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
-// generateSecureStreamID generates a cryptographically secure 64-character stream ID
-func generateSecureStreamID() (string, error) {
+// generateSecureToken generates a cryptographically secure 64-character hex token
+func generateSecureToken() (string, error) {
 	bytes := make([]byte, 32) // 32 bytes = 64 hex characters
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err
@@ -27,64 +31,171 @@ func generateSecureStreamID() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+// generateSecureStreamID generates a cryptographically secure 64-character stream ID
+func generateSecureStreamID() (string, error) {
+	return generateSecureToken()
+}
+
+// hashBroadcasterToken returns the hex-encoded SHA-256 hash of a broadcaster token
+func hashBroadcasterToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// broadcasterTokenFromRequest reads the broadcaster token from the
+// "Authorization: Bearer <token>" header, falling back to the "token" query parameter
+func broadcasterTokenFromRequest(c *gin.Context) string {
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	return c.Query("token")
+}
+
+// verifyBroadcasterToken checks the request's broadcaster token against the stream.
+// It writes a 401/403 response and returns false when the request is not authorized.
+func verifyBroadcasterToken(c *gin.Context, stream *models.Stream) bool {
+	token := broadcasterTokenFromRequest(c)
+	if token == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Broadcaster token required"})
+		return false
+	}
+
+	if !broadcasterTokenMatches(token, stream) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid broadcaster token"})
+		return false
+	}
+
+	return true
+}
+
+// broadcasterTokenMatches compares a broadcaster token with the stream's stored hash in constant time
+func broadcasterTokenMatches(token string, stream *models.Stream) bool {
+	hash := hashBroadcasterToken(token)
+	return stream.BroadcasterTokenHash != "" && subtle.ConstantTimeCompare([]byte(hash), []byte(stream.BroadcasterTokenHash)) == 1
+}
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	Subprotocols:    hub.Subprotocols, // Clients may negotiate a compact encoding, JSON otherwise
 	CheckOrigin: func(r *http.Request) bool {
 		return true // Allow all origins for development
 	},
 }
 
-// CreateStreamHandler generates a unique stream ID for mobile app
-func CreateStreamHandler(c *gin.Context) {
-	streamID, err := generateSecureStreamID()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate stream ID"})
-		return
+// bindOptionalJSON decodes the request body into obj, allowing an empty body
+func bindOptionalJSON(c *gin.Context, obj interface{}) error {
+	if c.Request.Body == nil {
+		return nil
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	stream := models.Stream{
-		StreamID:    streamID,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
-		IsActive:    true,
-		ViewerCount: 0,
+	err := json.NewDecoder(c.Request.Body).Decode(obj)
+	if err == io.EOF {
+		return nil
 	}
+	return err
+}
 
-	_, err = db.StreamsCollection().InsertOne(ctx, stream)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stream"})
-		return
-	}
+// CreateStreamHandler generates a unique stream ID and broadcaster token for mobile app.
+// Accepts an optional JSON body with geofences.
+func CreateStreamHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req models.CreateStreamRequest
+		if err := bindOptionalJSON(c, &req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
 
-	c.JSON(http.StatusOK, models.StreamIDResponse{
-		StreamID: streamID,
-		Message:  "Stream created successfully",
-	})
-}
+		if err := prepareGeofences(req.Geofences); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-// GetStreamHandler returns stream info
-func GetStreamHandler(c *gin.Context) {
-	streamID := c.Param("streamId")
+		if err := req.ValidateAccess(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		streamID, err := generateSecureStreamID()
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate stream ID"})
+			return
+		}
+
+		broadcasterToken, err := generateSecureToken()
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate broadcaster token"})
+			return
+		}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream := models.Stream{
+			StreamID:    streamID,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+			IsActive:    true,
+			ViewerCount: 0,
+
+			BroadcasterTokenHash: hashBroadcasterToken(broadcasterToken),
+			Geofences:            req.Geofences,
+			CreatorKey:           c.GetString(creatorKeyContextKey),
+			CreatorTokenKey:      c.GetString(creatorTokenKeyContextKey),
+		}
 
-	var stream models.Stream
-	err := db.StreamsCollection().FindOne(ctx, bson.M{"streamId": streamID}).Decode(&stream)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
-		return
+		invites, err := applyAccessSettings(&stream, &req)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to secure stream"})
+			return
+		}
+
+		err = s.Streams().Create(ctx, &stream)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stream"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.StreamIDResponse{
+			StreamID:         streamID,
+			BroadcasterToken: broadcasterToken,
+			Visibility:       stream.Visibility,
+			Invites:          invites,
+			Message:          "Stream created successfully",
+		})
 	}
+}
+
+// GetStreamHandler returns stream info.
+// Private streams require their passcode or an invite code.
+func GetStreamHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
 
-	c.JSON(http.StatusOK, stream)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if _, ok := authorizeViewer(c, stream, models.ShareScopeLive); !ok {
+			return
+		}
+
+		c.JSON(http.StatusOK, stream)
+	}
 }
 
-// DeleteStreamHandler soft deletes a stream and closes all connections
-func DeleteStreamHandler(h *hub.Hub) gin.HandlerFunc {
+// DeleteStreamHandler soft deletes a stream and closes all connections.
+// Requires the broadcaster token issued when the stream was created.
+func DeleteStreamHandler(s store.Store, h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		streamID := c.Param("streamId")
 
@@ -92,13 +203,17 @@ func DeleteStreamHandler(h *hub.Hub) gin.HandlerFunc {
 		defer cancel()
 
 		// Check if stream exists
-		var stream models.Stream
-		err := db.StreamsCollection().FindOne(ctx, bson.M{"streamId": streamID}).Decode(&stream)
+		stream, err := s.Streams().Get(ctx, streamID)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
 			return
 		}
 
+		// Only the broadcaster may delete the stream
+		if !verifyBroadcasterToken(c, stream) {
+			return
+		}
+
 		// Check if already deleted
 		if stream.DeletedAt != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Stream already deleted"})
@@ -107,35 +222,40 @@ func DeleteStreamHandler(h *hub.Hub) gin.HandlerFunc {
 
 		// Soft delete by setting deletedAt
 		now := time.Now()
-		_, err = db.StreamsCollection().UpdateOne(
-			ctx,
-			bson.M{"streamId": streamID},
-			bson.M{
-				"$set": bson.M{
-					"deletedAt": now,
-					"isActive":  false,
-					"updatedAt": now,
-				},
-			},
-		)
+		err = s.Streams().MarkDeleted(ctx, streamID, now, false)
 		if err != nil {
+			c.Error(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete stream"})
 			return
 		}
 
+		// Summarise the drive for the closed stream page, without failing the deletion
+		summaryCtx, summaryCancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer summaryCancel()
+
+		summary, err := h.SaveTripSummary(summaryCtx, streamID)
+		if err != nil {
+			logging.FromRequest(c).Error("Error saving trip summary", "error", err)
+		}
+
 		// Close all connections for this stream
 		h.CloseStream(streamID)
 
-		c.JSON(http.StatusOK, gin.H{
+		response := gin.H{
 			"message":   "Stream deleted successfully",
 			"streamId":  streamID,
 			"deletedAt": now,
-		})
+		}
+		if summary != nil {
+			response["summary"] = summary
+		}
+		c.JSON(http.StatusOK, response)
 	}
 }
 
-// MobileWebSocketHandler handles WebSocket connections from mobile app (broadcaster)
-func MobileWebSocketHandler(h *hub.Hub) gin.HandlerFunc {
+// MobileWebSocketHandler handles WebSocket connections from mobile app (broadcaster).
+// Requires the broadcaster token issued when the stream was created.
+func MobileWebSocketHandler(s store.Store, h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		streamID := c.Param("streamId")
 		if streamID == "" {
@@ -147,8 +267,7 @@ func MobileWebSocketHandler(h *hub.Hub) gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		var stream models.Stream
-		err := db.StreamsCollection().FindOne(ctx, bson.M{"streamId": streamID}).Decode(&stream)
+		stream, err := s.Streams().Get(ctx, streamID)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
 			return
@@ -160,19 +279,29 @@ func MobileWebSocketHandler(h *hub.Hub) gin.HandlerFunc {
 			return
 		}
 
+		// Only the holder of the broadcaster token may broadcast
+		if !verifyBroadcasterToken(c, stream) {
+			return
+		}
+
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
-			log.Printf("WebSocket upgrade error: %v", err)
+			logging.FromRequest(c).Warn("WebSocket upgrade error", "error", err)
 			return
 		}
 
+		// Evaluate the stream's geofences against the broadcaster's frames
+		h.SetGeofences(streamID, stream.Geofences)
+
 		client := &hub.Client{
-			ID:       uuid.New().String(),
-			StreamID: streamID,
-			Conn:     conn,
-			Send:     make(chan []byte, 256),
-			IsMobile: true,
-			Hub:      h,
+			ID:        uuid.New().String(),
+			StreamID:  streamID,
+			Conn:      conn,
+			Send:      make(chan []byte, 256),
+			IsMobile:  true,
+			Hub:       h,
+			Encoding:  conn.Subprotocol(),
+			RequestID: logging.RequestID(c.Request.Context()),
 		}
 
 		h.Register <- client
@@ -182,8 +311,23 @@ func MobileWebSocketHandler(h *hub.Hub) gin.HandlerFunc {
 	}
 }
 
-// ViewerWebSocketHandler handles WebSocket connections from web viewers
-func ViewerWebSocketHandler(h *hub.Hub) gin.HandlerFunc {
+// wantsDeltas reports whether a viewer opted into stream_data_delta messages with "?deltas=true"
+func wantsDeltas(c *gin.Context) bool {
+	return c.Query("deltas") == "true"
+}
+
+// viewerDisplayName returns the display name a viewer chose with "?name=", or one derived
+// from its client ID if it chose none
+func viewerDisplayName(c *gin.Context, clientID string) string {
+	if name := models.SanitizeDisplayName(c.Query("name")); name != "" {
+		return name
+	}
+	return "Viewer " + clientID[:4]
+}
+
+// ViewerWebSocketHandler handles WebSocket connections from web viewers.
+// Private streams require their passcode, an invite code or a share token.
+func ViewerWebSocketHandler(s store.Store, h *hub.Hub) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		streamID := c.Param("streamId")
 		if streamID == "" {
@@ -195,8 +339,7 @@ func ViewerWebSocketHandler(h *hub.Hub) gin.HandlerFunc {
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		var stream models.Stream
-		err := db.StreamsCollection().FindOne(ctx, bson.M{"streamId": streamID}).Decode(&stream)
+		stream, err := s.Streams().Get(ctx, streamID)
 		if err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
 			return
@@ -208,21 +351,38 @@ func ViewerWebSocketHandler(h *hub.Hub) gin.HandlerFunc {
 			return
 		}
 
+		shareToken, ok := authorizeViewer(c, stream, models.ShareScopeLive)
+		if !ok {
+			return
+		}
+
 		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 		if err != nil {
-			log.Printf("WebSocket upgrade error: %v", err)
+			logging.FromRequest(c).Warn("WebSocket upgrade error", "error", err)
 			return
 		}
 
+		clientID := uuid.New().String()
 		client := &hub.Client{
-			ID:        uuid.New().String(),
-			StreamID:  streamID,
-			Conn:      conn,
-			Send:      make(chan []byte, 256),
-			IsMobile:  false,
-			Hub:       h,
-			UserAgent: c.Request.UserAgent(),
-			IPAddress: c.ClientIP(),
+			ID:          clientID,
+			StreamID:    streamID,
+			Conn:        conn,
+			Send:        make(chan []byte, 256),
+			IsMobile:    false,
+			Hub:         h,
+			UserAgent:   c.Request.UserAgent(),
+			IPAddress:   c.ClientIP(),
+			Encoding:    conn.Subprotocol(),
+			Deltas:      wantsDeltas(c),
+			RequestID:   logging.RequestID(c.Request.Context()),
+			DisplayName: viewerDisplayName(c, clientID),
+		}
+		client.SetChatMuted(isMuted(stream, client.IPAddress))
+
+		// Viewers that joined with a share token are disconnected when it is revoked or expires
+		if shareToken != nil {
+			client.ShareTokenID = shareToken.ID
+			client.ShareTokenExpiresAt = shareToken.ExpiresAt
 		}
 
 		h.Register <- client
@@ -233,25 +393,26 @@ func ViewerWebSocketHandler(h *hub.Hub) gin.HandlerFunc {
 }
 
 // GetFeatureFlagsHandler returns the feature flags from the database
-func GetFeatureFlagsHandler(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	var featureFlags models.FeatureFlags
-	err := db.FeatureFlagsCollection().FindOne(ctx, bson.M{}).Decode(&featureFlags)
-	if err != nil {
-		// Return default values (all false) if no feature flags document exists
+func GetFeatureFlagsHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		featureFlags, err := s.FeatureFlags().Get(ctx)
+		if err != nil {
+			// Return default values (all false) if no feature flags document exists
+			c.JSON(http.StatusOK, models.FeatureFlagsResponse{
+				EnableLiveStreams:   false,
+				EnableiCloudStorage: false,
+				EnableCarPlay:       false,
+			})
+			return
+		}
+
 		c.JSON(http.StatusOK, models.FeatureFlagsResponse{
-			EnableLiveStreams:   false,
-			EnableiCloudStorage: false,
-			EnableCarPlay:       false,
+			EnableLiveStreams:   featureFlags.EnableLiveStreams,
+			EnableiCloudStorage: featureFlags.EnableiCloudStorage,
+			EnableCarPlay:       featureFlags.EnableCarPlay,
 		})
-		return
 	}
-
-	c.JSON(http.StatusOK, models.FeatureFlagsResponse{
-		EnableLiveStreams:   featureFlags.EnableLiveStreams,
-		EnableiCloudStorage: featureFlags.EnableiCloudStorage,
-		EnableCarPlay:       featureFlags.EnableCarPlay,
-	})
 }
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"velocity-be/store"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// Gin context keys under which StreamCreationLimits stores the keys identifying
+// the creator of a new stream: its IP address, and its client token if one was sent
+const (
+	creatorKeyContextKey      = "creatorKey"
+	creatorTokenKeyContextKey = "creatorTokenKey"
+)
+
+// activeStreamsRetryAfter is the Retry-After sent to creators at the active stream cap.
+// Their streams end whenever they choose, so it is only a hint for when to try again.
+const activeStreamsRetryAfter = time.Minute
+
+// limiterIdleTimeout is how long a client's quota is kept after its last request.
+// Quotas are refilled well before, so forgetting them doesn't loosen the limits.
+const limiterIdleTimeout = time.Hour
+
+// Quota allows Requests requests per Window, in bursts of up to Requests. Zero disables the quota.
+type Quota struct {
+	Requests int
+	Window   time.Duration
+}
+
+// CreationLimits configures the abuse controls of stream creation
+type CreationLimits struct {
+	PerIP    Quota // Per client IP address
+	PerToken Quota // Per X-Client-Token header, for clients that send one
+
+	// Maximum number of active streams per IP address, and per client token for clients that send one, 0 for no limit
+	MaxActiveStreams int
+}
+
+// quotaLimiter tracks a Quota for each client key
+type quotaLimiter struct {
+	quota     Quota
+	limiters  map[string]*clientLimiter
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newQuotaLimiter(quota Quota) *quotaLimiter {
+	return &quotaLimiter{
+		quota:     quota,
+		limiters:  make(map[string]*clientLimiter),
+		lastSweep: time.Now(),
+	}
+}
+
+func (q *quotaLimiter) enabled() bool {
+	return q.quota.Requests > 0 && q.quota.Window > 0
+}
+
+// allow takes a request from key's quota, or returns how long until one is available
+func (q *quotaLimiter) allow(key string) (bool, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	reservation := q.limiter(key, now).ReserveN(now, 1)
+	if delay := reservation.DelayFrom(now); delay > 0 {
+		reservation.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// exhausted reports whether key's quota is used up, and how long until a request is
+// available, without taking one
+func (q *quotaLimiter) exhausted(key string) (bool, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	reservation := q.limiter(key, now).ReserveN(now, 1)
+	delay := reservation.DelayFrom(now)
+	reservation.CancelAt(now)
+	return delay > 0, delay
+}
+
+// limiter returns key's rate limiter, creating it if needed. Callers must hold q.mu.
+func (q *quotaLimiter) limiter(key string, now time.Time) *rate.Limiter {
+	q.sweep(now)
+
+	client, exists := q.limiters[key]
+	if !exists {
+		every := rate.Every(q.quota.Window / time.Duration(q.quota.Requests))
+		client = &clientLimiter{limiter: rate.NewLimiter(every, q.quota.Requests)}
+		q.limiters[key] = client
+	}
+	client.lastSeen = now
+	return client.limiter
+}
+
+// sweep forgets the clients that have been idle for limiterIdleTimeout, at most once per timeout
+func (q *quotaLimiter) sweep(now time.Time) {
+	if now.Sub(q.lastSweep) < limiterIdleTimeout {
+		return
+	}
+	q.lastSweep = now
+
+	for key, client := range q.limiters {
+		if now.Sub(client.lastSeen) >= limiterIdleTimeout {
+			delete(q.limiters, key)
+		}
+	}
+}
+
+// StreamCreationLimits returns a middleware enforcing limits on the stream creation handler.
+// Requests over a quota are rejected with 429 and a Retry-After header, as are IP addresses
+// and client tokens that already have MaxActiveStreams active streams. The cap is checked
+// before the stream is created, so concurrent requests may exceed it slightly.
+func StreamCreationLimits(s store.Store, limits CreationLimits) gin.HandlerFunc {
+	perIP := newQuotaLimiter(limits.PerIP)
+	perToken := newQuotaLimiter(limits.PerToken)
+
+	return func(c *gin.Context) {
+		ip := c.ClientIP()
+		token := c.GetHeader("X-Client-Token")
+
+		if perIP.enabled() {
+			if ok, retryAfter := perIP.allow(ip); !ok {
+				rejectRateLimited(c, retryAfter)
+				return
+			}
+		}
+
+		if token != "" && perToken.enabled() {
+			if ok, retryAfter := perToken.allow(hashBroadcasterToken(token)); !ok {
+				rejectRateLimited(c, retryAfter)
+				return
+			}
+		}
+
+		// The IP address is always capped, so a client can't dodge the cap by rotating tokens
+		creatorKeys := []string{hashBroadcasterToken("ip:" + ip)}
+		c.Set(creatorKeyContextKey, creatorKeys[0])
+		if token != "" {
+			creatorKeys = append(creatorKeys, hashBroadcasterToken("token:"+token))
+			c.Set(creatorTokenKeyContextKey, creatorKeys[1])
+		}
+
+		if limits.MaxActiveStreams > 0 {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			for _, creatorKey := range creatorKeys {
+				active, err := s.Streams().CountActiveByCreator(ctx, creatorKey)
+				if err != nil {
+					c.Error(err)
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stream"})
+					return
+				}
+
+				if active >= int64(limits.MaxActiveStreams) {
+					setRetryAfter(c, activeStreamsRetryAfter)
+					c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many active streams, end one before starting another"})
+					return
+				}
+			}
+		}
+
+		c.Next()
+	}
+}
+
+// rejectRateLimited aborts a request that exceeded its quota
+func rejectRateLimited(c *gin.Context, retryAfter time.Duration) {
+	setRetryAfter(c, retryAfter)
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests"})
+}
+
+// setRetryAfter sets the Retry-After header in whole seconds, rounded up
+func setRetryAfter(c *gin.Context, retryAfter time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"velocity-be/hub"
+	"velocity-be/logging"
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ReplayWebSocketHandler plays a finished drive back over a WebSocket, with the messages
+// of the live stream. Requires the same access as the track history.
+func ReplayWebSocketHandler(s store.Store, h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if _, ok := authorizeViewer(c, stream, models.ShareScopeLiveHistory); !ok {
+			return
+		}
+
+		if stream.DeletedAt == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Stream is still live"})
+			return
+		}
+
+		replay, err := h.LoadReplay(ctx, stream)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load replay"})
+			return
+		}
+
+		conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			logging.FromRequest(c).Warn("WebSocket upgrade error", "error", err)
+			return
+		}
+
+		replay.Start(&hub.Client{
+			ID:        uuid.New().String(),
+			StreamID:  streamID,
+			Conn:      conn,
+			Send:      make(chan []byte, 256),
+			Hub:       h,
+			UserAgent: c.Request.UserAgent(),
+			IPAddress: c.ClientIP(),
+			Encoding:  conn.Subprotocol(),
+			RequestID: logging.RequestID(c.Request.Context()),
+		})
+	}
+}
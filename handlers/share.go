@@ -0,0 +1,291 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"velocity-be/hub"
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Reasons a share token is rejected
+var (
+	errShareTokenInvalid = errors.New("invalid share token")
+	errShareTokenExpired = errors.New("share token expired")
+	errShareTokenRevoked = errors.New("share token revoked")
+)
+
+// shareTokenSecret signs share tokens. It is random unless SetShareTokenSecret is called,
+// in which case tokens minted before a restart or by another instance stay valid.
+var shareTokenSecret = newShareTokenSecret()
+
+func newShareTokenSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("failed to generate share token secret: " + err.Error())
+	}
+	return secret
+}
+
+// SetShareTokenSecret sets the key used to sign and verify share tokens. It must be
+// called before the server starts and be the same on every instance.
+func SetShareTokenSecret(secret string) {
+	shareTokenSecret = []byte(secret)
+}
+
+// shareTokenClaims is the signed payload of a share token
+type shareTokenClaims struct {
+	ID        string `json:"jti"`
+	StreamID  string `json:"sid"`
+	Scope     string `json:"scope"`
+	ExpiresAt int64  `json:"exp"` // Unix seconds
+}
+
+// signShareToken encodes claims as "<base64url payload>.<base64url HMAC-SHA256>"
+func signShareToken(claims shareTokenClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(shareTokenSignature(encoded)), nil
+}
+
+func shareTokenSignature(encodedPayload string) []byte {
+	mac := hmac.New(sha256.New, shareTokenSecret)
+	mac.Write([]byte(encodedPayload))
+	return mac.Sum(nil)
+}
+
+// verifyShareToken checks a share token's signature, stream, revocation and expiry,
+// and returns the stream's record of it
+func verifyShareToken(token string, stream *models.Stream) (*models.ShareToken, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return nil, errShareTokenInvalid
+	}
+
+	decodedSignature, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(decodedSignature, shareTokenSignature(encoded)) {
+		return nil, errShareTokenInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errShareTokenInvalid
+	}
+
+	var claims shareTokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.StreamID != stream.StreamID {
+		return nil, errShareTokenInvalid
+	}
+
+	record := findShareToken(stream, claims.ID)
+	if record == nil {
+		return nil, errShareTokenInvalid
+	}
+	if record.RevokedAt != nil {
+		return nil, errShareTokenRevoked
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errShareTokenExpired
+	}
+
+	return record, nil
+}
+
+// shareTokenFromRequest reads a viewer's share token from the "X-Share-Token" header,
+// falling back to the "share" query parameter
+func shareTokenFromRequest(c *gin.Context) string {
+	if token := c.GetHeader("X-Share-Token"); token != "" {
+		return token
+	}
+	return c.Query("share")
+}
+
+// shareScopeGrants reports whether a share token with the granted scope may access an
+// endpoint requiring the required scope
+func shareScopeGrants(granted, required string) bool {
+	return required == models.ShareScopeLive || granted == models.ShareScopeLiveHistory
+}
+
+// findShareToken returns the stream's share token with the given ID, or nil
+func findShareToken(stream *models.Stream, tokenID string) *models.ShareToken {
+	for i := range stream.ShareTokens {
+		if stream.ShareTokens[i].ID == tokenID {
+			return &stream.ShareTokens[i]
+		}
+	}
+	return nil
+}
+
+// CreateShareTokenHandler mints a signed share token for a private stream.
+// Requires the broadcaster token issued when the stream was created.
+func CreateShareTokenHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+
+		var req models.CreateShareTokenRequest
+		if err := bindOptionalJSON(c, &req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+
+		if err := req.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if !verifyBroadcasterToken(c, stream) {
+			return
+		}
+
+		if stream.DeletedAt != nil {
+			c.JSON(http.StatusGone, gin.H{"error": "Stream has been closed"})
+			return
+		}
+
+		// Anyone can watch a public stream without a token, so its expiry, revocation
+		// and scope would restrict nothing
+		if !isPrivate(stream) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Share tokens require a passcode or invite_list stream"})
+			return
+		}
+
+		if len(stream.ShareTokens) >= models.MaxShareTokens {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Share token limit reached"})
+			return
+		}
+
+		now := time.Now().UTC().Truncate(time.Second)
+		record := models.ShareToken{
+			ID:        uuid.New().String(),
+			Name:      req.Name,
+			Scope:     req.Scope,
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Duration(req.ExpiresInSeconds) * time.Second),
+		}
+
+		token, err := signShareToken(shareTokenClaims{
+			ID:        record.ID,
+			StreamID:  streamID,
+			Scope:     record.Scope,
+			ExpiresAt: record.ExpiresAt.Unix(),
+		})
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate share token"})
+			return
+		}
+
+		if err := s.Streams().AddShareToken(ctx, streamID, record); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save share token"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.ShareTokenResponse{
+			ShareToken: record,
+			Token:      token,
+		})
+	}
+}
+
+// ListShareTokensHandler returns the share tokens minted for a stream, without the tokens themselves.
+// Requires the broadcaster token issued when the stream was created.
+func ListShareTokensHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if !verifyBroadcasterToken(c, stream) {
+			return
+		}
+
+		tokens := stream.ShareTokens
+		if tokens == nil {
+			tokens = []models.ShareToken{}
+		}
+
+		c.JSON(http.StatusOK, models.ShareTokensResponse{
+			StreamID:    streamID,
+			ShareTokens: tokens,
+		})
+	}
+}
+
+// RevokeShareTokenHandler revokes a share token and disconnects the viewers using it.
+// Requires the broadcaster token issued when the stream was created.
+func RevokeShareTokenHandler(s store.Store, h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+		tokenID := c.Param("tokenId")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if !verifyBroadcasterToken(c, stream) {
+			return
+		}
+
+		record := findShareToken(stream, tokenID)
+		if record == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Share token not found"})
+			return
+		}
+
+		revokedAt := time.Now()
+		if record.RevokedAt != nil {
+			revokedAt = *record.RevokedAt
+		} else if err := s.Streams().RevokeShareToken(ctx, streamID, tokenID, revokedAt); err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke share token"})
+			return
+		}
+
+		h.RevokeShareToken(streamID, tokenID)
+
+		c.JSON(http.StatusOK, gin.H{
+			"message":   "Share token revoked",
+			"streamId":  streamID,
+			"id":        tokenID,
+			"revokedAt": revokedAt,
+		})
+	}
+}
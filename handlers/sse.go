@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"velocity-be/hub"
+	"velocity-be/logging"
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// sseKeepAliveInterval is how often a comment is sent to keep idle proxies from closing the stream
+const sseKeepAliveInterval = 25 * time.Second
+
+// ViewerEventsHandler streams a stream's viewer messages as Server-Sent Events, for
+// networks that block WebSocket upgrades. Each message is sent as the data of an
+// unnamed event, in the same JSON format as /ws/viewer/:streamId.
+// Private streams require their passcode, an invite code or a share token.
+func ViewerEventsHandler(s store.Store, h *hub.Hub) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if stream.DeletedAt != nil {
+			c.JSON(http.StatusGone, gin.H{"error": "Stream has been closed"})
+			return
+		}
+
+		shareToken, ok := authorizeViewer(c, stream, models.ShareScopeLive)
+		if !ok {
+			return
+		}
+
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no") // Disable response buffering in nginx
+		c.Status(http.StatusOK)
+		c.Writer.Flush()
+
+		clientID := uuid.New().String()
+		client := &hub.Client{
+			ID:          clientID,
+			StreamID:    streamID,
+			Send:        make(chan []byte, 256),
+			IsMobile:    false,
+			Hub:         h,
+			UserAgent:   c.Request.UserAgent(),
+			IPAddress:   c.ClientIP(),
+			Deltas:      wantsDeltas(c),
+			RequestID:   logging.RequestID(c.Request.Context()),
+			DisplayName: viewerDisplayName(c, clientID),
+		}
+
+		if shareToken != nil {
+			client.ShareTokenID = shareToken.ID
+			client.ShareTokenExpiresAt = shareToken.ExpiresAt
+		}
+
+		h.Register <- client
+		defer func() {
+			h.Unregister <- client
+		}()
+
+		keepAlive := time.NewTicker(sseKeepAliveInterval)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case message, ok := <-client.Send:
+				if !ok {
+					// The hub closed the viewer, e.g. because the stream ended
+					return
+				}
+				if err := writeSSEMessage(c.Writer, message); err != nil {
+					return
+				}
+			case <-keepAlive.C:
+				if _, err := io.WriteString(c.Writer, ": keep-alive\n\n"); err != nil {
+					return
+				}
+			case <-c.Request.Context().Done():
+				return
+			}
+			c.Writer.Flush()
+		}
+	}
+}
+
+// writeSSEMessage writes a message as an unnamed event, one data field per line
+func writeSSEMessage(w io.Writer, message []byte) error {
+	var buf bytes.Buffer
+	for _, line := range bytes.Split(message, []byte("\n")) {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteByte('\n')
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
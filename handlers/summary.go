@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetStreamSummaryHandler returns the trip summary computed when the stream ended.
+// Streams that are still live, or ended before summaries existed, have none.
+func GetStreamSummaryHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if _, ok := authorizeViewer(c, stream, models.ShareScopeLiveHistory); !ok {
+			return
+		}
+
+		if stream.Summary == nil {
+			if stream.DeletedAt == nil {
+				c.JSON(http.StatusConflict, gin.H{"error": "Stream has not ended"})
+				return
+			}
+			c.JSON(http.StatusNotFound, gin.H{"error": "Trip summary not available"})
+			return
+		}
+
+		c.JSON(http.StatusOK, stream.Summary)
+	}
+}
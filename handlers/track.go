@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultTrackPageSize is the number of track points returned when no limit is given
+	defaultTrackPageSize = 1000
+
+	// maxTrackPageSize is the largest page of track points a client can request
+	maxTrackPageSize = 5000
+)
+
+// GetStreamTrackHandler returns the recorded track points of a stream.
+// Supports optional "from"/"to" RFC3339 time range and "limit"/"offset" pagination.
+func GetStreamTrackHandler(s store.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		streamID := c.Param("streamId")
+
+		query := store.TrackQuery{StreamID: streamID}
+
+		if from := c.Query("from"); from != "" {
+			fromTime, err := time.Parse(time.RFC3339, from)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'from' timestamp, expected RFC3339"})
+				return
+			}
+			query.From = fromTime
+		}
+
+		if to := c.Query("to"); to != "" {
+			toTime, err := time.Parse(time.RFC3339, to)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'to' timestamp, expected RFC3339"})
+				return
+			}
+			query.To = toTime
+		}
+
+		limit, err := strconv.ParseInt(c.DefaultQuery("limit", strconv.Itoa(defaultTrackPageSize)), 10, 64)
+		if err != nil || limit < 1 || limit > maxTrackPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'limit', must be between 1 and " + strconv.Itoa(maxTrackPageSize)})
+			return
+		}
+
+		offset, err := strconv.ParseInt(c.DefaultQuery("offset", "0"), 10, 64)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid 'offset', must be a non-negative integer"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// Verify stream exists (deleted streams keep their history for review)
+		stream, err := s.Streams().Get(ctx, streamID)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Stream not found"})
+			return
+		}
+
+		if _, ok := authorizeViewer(c, stream, models.ShareScopeLiveHistory); !ok {
+			return
+		}
+
+		total, err := s.TrackPoints().Count(ctx, query)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load track"})
+			return
+		}
+
+		query.Offset = offset
+		query.Limit = limit
+
+		points, err := s.TrackPoints().List(ctx, query)
+		if err != nil {
+			c.Error(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load track"})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.TrackResponse{
+			StreamID: streamID,
+			Points:   points,
+			Total:    total,
+			Limit:    limit,
+			Offset:   offset,
+			HasMore:  offset+int64(len(points)) < total,
+		})
+	}
+}
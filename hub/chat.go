@@ -0,0 +1,135 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"velocity-be/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// handleChat validates a viewer's chat message, then sends it to the stream's clients
+// (including the sender, as confirmation) and records it in the chat history
+func (h *Hub) handleChat(c *Client, payload json.RawMessage) {
+	if c.chatMuted.Load() {
+		h.sendError(c, "You were muted by the broadcaster")
+		return
+	}
+
+	var request models.ChatRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		h.sendError(c, "Invalid chat payload")
+		return
+	}
+	if err := request.Validate(); err != nil {
+		h.sendError(c, "Invalid chat payload: "+err.Error())
+		return
+	}
+
+	message := models.ChatMessage{
+		ID:          primitive.NewObjectID(),
+		StreamID:    c.StreamID,
+		ClientID:    c.ID,
+		DisplayName: c.DisplayName,
+		Text:        request.Text,
+		SentAt:      time.Now(),
+	}
+	h.sendChat(c, "chat", message)
+
+	go h.recordChatMessage(message)
+}
+
+// handleReaction validates a viewer's emoji reaction, then sends it to the stream's clients
+func (h *Hub) handleReaction(c *Client, payload json.RawMessage) {
+	if c.chatMuted.Load() {
+		h.sendError(c, "You were muted by the broadcaster")
+		return
+	}
+
+	var request models.ReactionRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		h.sendError(c, "Invalid reaction payload")
+		return
+	}
+	if err := request.Validate(); err != nil {
+		h.sendError(c, "Invalid reaction payload: "+err.Error())
+		return
+	}
+
+	h.sendChat(c, "reaction", models.Reaction{
+		StreamID:    c.StreamID,
+		ClientID:    c.ID,
+		DisplayName: c.DisplayName,
+		Emoji:       request.Emoji,
+		SentAt:      time.Now(),
+	})
+}
+
+// sendChat sends a chat or reaction message to the clients of the sender's stream,
+// on this and every other instance
+func (h *Hub) sendChat(c *Client, messageType string, payload interface{}) {
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    messageType,
+		Payload: payload,
+	})
+	if err != nil {
+		c.logger().Error("Error marshaling chat message", "type", messageType, "error", err)
+		return
+	}
+
+	h.deliverChat(c.StreamID, data)
+	h.publish(eventChat, c.StreamID, data)
+}
+
+// deliverChat queues a chat or reaction message for the local viewers of a stream and
+// for its broadcaster, unless the broadcaster muted chat
+func (h *Hub) deliverChat(streamID string, data []byte) {
+	// Held so the broadcaster's Send channel is not closed concurrently
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	streamHub, exists := h.Streams[streamID]
+	if !exists {
+		return
+	}
+
+	h.broadcastToViewers(streamHub, data)
+
+	if broadcaster := streamHub.Broadcaster; broadcaster != nil && !broadcaster.chatMuted.Load() {
+		broadcaster.queue(newEncodedMessage(data))
+	}
+}
+
+func (h *Hub) recordChatMessage(message models.ChatMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Store.ChatMessages().Insert(ctx, message); err != nil {
+		slog.Error("Error recording chat message", "streamId", message.StreamID, "error", err)
+	}
+}
+
+// handleChatSettings applies the broadcaster's chat settings to its connection and echoes them back
+func (h *Hub) handleChatSettings(c *Client, payload json.RawMessage) {
+	var settings models.ChatSettings
+	if err := json.Unmarshal(payload, &settings); err != nil {
+		h.sendError(c, "Invalid chat_settings payload")
+		return
+	}
+
+	c.chatMuted.Store(settings.Muted)
+	c.logger().Info("Chat settings changed", "muted", settings.Muted)
+
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    "chat_settings",
+		Payload: settings,
+	})
+	if err != nil {
+		c.logger().Error("Error marshaling chat settings", "error", err)
+		return
+	}
+	h.sendToClient(c, data)
+}
@@ -1,16 +1,12 @@
 package hub
 
 import (
-	"context"
 	"encoding/json"
-	"log"
 	"time"
 
-	"velocity-be/db"
 	"velocity-be/models"
 
 	"github.com/gorilla/websocket"
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 // ReadPump pumps messages from the WebSocket connection to the hub
@@ -20,41 +16,163 @@ func (c *Client) ReadPump(h *Hub) {
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(512 * 1024) // 512KB max message size
+	c.Conn.SetReadLimit(h.MaxMessageBytes)
 	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 	c.Conn.SetPongHandler(func(string) error {
 		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
 		return nil
 	})
 
+	limiter := h.newMessageLimiter()
+
 	for {
 		_, message, err := c.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				c.logger().Warn("WebSocket error", "error", err)
 			}
 			break
 		}
 
+		allowed, abusive := h.allowMessage(c, limiter)
+		if abusive {
+			closeAbusive(c)
+			break
+		}
+		if !allowed {
+			continue
+		}
+
+		message, err = c.decode(message)
+		if err != nil {
+			c.logger().Warn("Error decoding message", "error", err)
+			h.sendError(c, "Invalid message format")
+			continue
+		}
+
+		var wsMessage struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(message, &wsMessage); err != nil {
+			c.logger().Warn("Error parsing message", "error", err)
+			h.sendError(c, "Invalid message format")
+			continue
+		}
+
 		if c.IsMobile {
 			// Mobile app is sending stream data - broadcast to all viewers
-			var wsMessage models.WebSocketMessage
-			if err := json.Unmarshal(message, &wsMessage); err != nil {
-				log.Printf("Error parsing message: %v", err)
-				continue
+			switch wsMessage.Type {
+			case "stream_data":
+				h.handleStreamData(c, wsMessage.Payload)
+			case "stream_data_delta":
+				h.handleStreamDataDelta(c, wsMessage.Payload)
+			case "chat_settings":
+				h.handleChatSettings(c, wsMessage.Payload)
+			case "moderate":
+				h.handleModerate(c, wsMessage.Payload)
+			case "list_viewers":
+				go h.sendViewerList(c)
 			}
-
-			if wsMessage.Type == "stream_data" {
-				// Update stream in database
-				go updateStreamData(c.StreamID, wsMessage.Payload)
-
-				// Broadcast to all viewers
-				h.BroadcastToViewers(c.StreamID, message)
+		} else {
+			switch wsMessage.Type {
+			case "chat":
+				h.handleChat(c, wsMessage.Payload)
+			case "reaction":
+				h.handleReaction(c, wsMessage.Payload)
 			}
 		}
 	}
 }
 
+// handleStreamData validates a stream_data payload from the broadcaster, then
+// stores and broadcasts the sanitized frame. Invalid frames are rejected with an error message.
+func (h *Hub) handleStreamData(c *Client, payload json.RawMessage) {
+	var data models.StreamData
+	if err := json.Unmarshal(payload, &data); err != nil {
+		h.sendError(c, "Invalid stream_data payload")
+		return
+	}
+
+	data.Sanitize()
+	if err := data.Validate(); err != nil {
+		h.sendError(c, "Invalid stream_data payload: "+err.Error())
+		return
+	}
+
+	now := time.Now()
+
+	// Attach the server's remaining distance and ETA so every viewer sees the same estimate
+	data.Progress = h.computeProgress(c.StreamID, data, now)
+
+	// Re-encode so only known, sanitized fields reach viewers
+	sanitized, err := json.Marshal(data)
+	if err != nil {
+		c.logger().Error("Error marshaling stream data", "error", err)
+		return
+	}
+	message, err := json.Marshal(models.WebSocketMessage{
+		Type:    "stream_data",
+		Payload: json.RawMessage(sanitized),
+	})
+	if err != nil {
+		c.logger().Error("Error marshaling stream data message", "error", err)
+		return
+	}
+
+	// Update the stream and append the point to its track history in the database,
+	// coalescing frames to StreamDataWritesPerSecond, and keep the point for late joiners
+	point := newTrackPoint(c.StreamID, now, data)
+	h.persistFrame(c.StreamID, data, point)
+	previous := h.recordFrame(c.StreamID, sanitized, point)
+
+	// Broadcast to all viewers, here and on other instances
+	h.broadcastFrame(c.StreamID, message, previous, sanitized)
+	h.publish(eventBroadcast, c.StreamID, message)
+
+	// Notify viewers about arrival, zone and route transitions
+	h.emitStreamEvents(c.StreamID, h.evaluateGeofences(c.StreamID, data, now))
+	h.emitStreamEvents(c.StreamID, h.evaluateOffRoute(c.StreamID, data, data.Progress, now))
+}
+
+// sendError sends an "error" message to a client if it is still registered
+func (h *Hub) sendError(c *Client, message string) {
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    "error",
+		Payload: models.ErrorMessage{Message: message},
+	})
+	if err != nil {
+		c.logger().Error("Error marshaling error message", "error", err)
+		return
+	}
+
+	h.sendToClient(c, data)
+}
+
+// sendToClient queues data for a single client. The hub lock guarantees the
+// client's Send channel is not closed concurrently.
+func (h *Hub) sendToClient(c *Client, data []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	streamHub, exists := h.Streams[c.StreamID]
+	if !exists {
+		return
+	}
+
+	streamHub.mu.RLock()
+	registered := streamHub.Broadcaster == c || streamHub.Viewers[c]
+	streamHub.mu.RUnlock()
+
+	if !registered {
+		return
+	}
+
+	if !c.queue(newEncodedMessage(data)) {
+		c.logger().Warn("Failed to send message to client")
+	}
+}
+
 // WritePump pumps messages from the hub to the WebSocket connection
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(54 * time.Second)
@@ -72,7 +190,7 @@ func (c *Client) WritePump() {
 				return
 			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
+			w, err := c.Conn.NextWriter(c.frameType())
 			if err != nil {
 				return
 			}
@@ -90,21 +208,19 @@ func (c *Client) WritePump() {
 	}
 }
 
-func updateStreamData(streamID string, payload interface{}) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := db.StreamsCollection().UpdateOne(
-		ctx,
-		bson.M{"streamId": streamID},
-		bson.M{
-			"$set": bson.M{
-				"latestData": payload,
-				"updatedAt":  time.Now(),
-			},
-		},
-	)
-	if err != nil {
-		log.Printf("Error updating stream data: %v", err)
+// newTrackPoint builds a track point from a stream_data payload, or returns nil
+// when the frame has no GPS fix
+func newTrackPoint(streamID string, timestamp time.Time, data models.StreamData) *models.TrackPoint {
+	if data.CurrentLocation.Latitude == 0 && data.CurrentLocation.Longitude == 0 {
+		return nil
+	}
+
+	return &models.TrackPoint{
+		StreamID:  streamID,
+		Timestamp: timestamp,
+		Latitude:  data.CurrentLocation.Latitude,
+		Longitude: data.CurrentLocation.Longitude,
+		SpeedKmh:  data.CurrentSpeedKmh,
+		IsPaused:  data.IsPaused,
 	}
 }
@@ -0,0 +1,200 @@
+package hub
+
+import (
+	"encoding/json"
+	"log/slog"
+	"reflect"
+
+	"velocity-be/models"
+)
+
+// DefaultKeyframeInterval is how many stream_data frames are sent to delta viewers as
+// deltas before a full keyframe, so a viewer that missed a delta recovers
+const DefaultKeyframeInterval = 20
+
+// Deltas are JSON merge patches (RFC 7386): fields present replace the previous value,
+// nested objects are merged, arrays are replaced whole and null removes a field.
+// Applying a patch twice gives the same result, so a delta that arrives after a
+// snapshot already containing it is harmless.
+
+// handleStreamDataDelta rebuilds a full stream_data payload from a broadcaster's delta
+// and processes it like a full frame
+func (h *Hub) handleStreamDataDelta(c *Client, patch json.RawMessage) {
+	base := h.latestFrame(c.StreamID)
+	if base == nil {
+		// Nothing to apply the delta to, e.g. after a restart
+		h.sendKeyframeRequired(c)
+		return
+	}
+
+	payload, err := applyMergePatch(base, patch)
+	if err != nil {
+		h.sendError(c, "Invalid stream_data_delta payload")
+		return
+	}
+
+	h.handleStreamData(c, payload)
+}
+
+// sendKeyframeRequired asks the broadcaster to send a full stream_data frame
+func (h *Hub) sendKeyframeRequired(c *Client) {
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    "keyframe_required",
+		Payload: models.KeyframeRequired{StreamID: c.StreamID},
+	})
+	if err != nil {
+		c.logger().Error("Error marshaling keyframe_required message", "error", err)
+		return
+	}
+
+	h.sendToClient(c, data)
+}
+
+// latestFrame returns the last stream_data payload of a stream, or nil
+func (h *Hub) latestFrame(streamID string) json.RawMessage {
+	h.mu.RLock()
+	streamHub, exists := h.Streams[streamID]
+	h.mu.RUnlock()
+
+	if !exists {
+		return nil
+	}
+
+	streamHub.mu.RLock()
+	defer streamHub.mu.RUnlock()
+	return streamHub.LatestData
+}
+
+// broadcastFrame sends a stream_data message to the local viewers of a stream. Viewers that
+// opted into deltas get the changes since the previous payload, and a keyframe every
+// KeyframeInterval frames or when there is no previous payload.
+func (h *Hub) broadcastFrame(streamID string, message []byte, previous, payload json.RawMessage) {
+	h.mu.RLock()
+	streamHub, exists := h.Streams[streamID]
+	h.mu.RUnlock()
+
+	if !exists {
+		return
+	}
+
+	full := newEncodedMessage(message)
+
+	streamHub.mu.Lock()
+	streamHub.framesSinceKeyframe++
+	keyframe := previous == nil || streamHub.framesSinceKeyframe >= h.KeyframeInterval
+	if keyframe {
+		streamHub.framesSinceKeyframe = 0
+	}
+	streamHub.mu.Unlock()
+
+	var delta *encodedMessage
+	if !keyframe {
+		delta = newDeltaMessage(previous, payload)
+	}
+
+	streamHub.mu.RLock()
+	defer streamHub.mu.RUnlock()
+
+	h.metrics.messagesBroadcast.Inc()
+	for viewer := range streamHub.Viewers {
+		// Client buffer full, skip. A viewer that missed frames can't apply the next delta.
+		if viewer.Deltas && delta != nil && !viewer.lagging() {
+			viewer.queue(delta)
+		} else {
+			viewer.queue(full)
+		}
+	}
+}
+
+// newDeltaMessage builds a stream_data_delta message, or returns nil if the delta can't be computed
+func newDeltaMessage(previous, payload json.RawMessage) *encodedMessage {
+	patch, err := createMergePatch(previous, payload)
+	if err != nil {
+		slog.Error("Error computing stream data delta", "error", err)
+		return nil
+	}
+
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    "stream_data_delta",
+		Payload: json.RawMessage(patch),
+	})
+	if err != nil {
+		slog.Error("Error marshaling stream data delta", "error", err)
+		return nil
+	}
+	return newEncodedMessage(data)
+}
+
+// applyMergePatch applies a JSON merge patch to a JSON object
+func applyMergePatch(target, patch []byte) ([]byte, error) {
+	var targetObject, patchObject map[string]interface{}
+	if err := json.Unmarshal(target, &targetObject); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(patch, &patchObject); err != nil {
+		return nil, err
+	}
+	return json.Marshal(mergeObjects(targetObject, patchObject))
+}
+
+func mergeObjects(target, patch map[string]interface{}) map[string]interface{} {
+	if target == nil {
+		target = make(map[string]interface{})
+	}
+
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+
+		if patchObject, ok := value.(map[string]interface{}); ok {
+			targetObject, _ := target[key].(map[string]interface{})
+			target[key] = mergeObjects(targetObject, patchObject)
+			continue
+		}
+
+		target[key] = value
+	}
+	return target
+}
+
+// createMergePatch returns the JSON merge patch turning one JSON object into another
+func createMergePatch(from, to []byte) ([]byte, error) {
+	var fromObject, toObject map[string]interface{}
+	if err := json.Unmarshal(from, &fromObject); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(to, &toObject); err != nil {
+		return nil, err
+	}
+	return json.Marshal(diffObjects(fromObject, toObject))
+}
+
+func diffObjects(from, to map[string]interface{}) map[string]interface{} {
+	patch := make(map[string]interface{})
+
+	for key, value := range to {
+		previous, existed := from[key]
+		if existed && reflect.DeepEqual(previous, value) {
+			continue
+		}
+
+		fromObject, fromIsObject := previous.(map[string]interface{})
+		toObject, toIsObject := value.(map[string]interface{})
+		if fromIsObject && toIsObject {
+			patch[key] = diffObjects(fromObject, toObject)
+			continue
+		}
+
+		patch[key] = value
+	}
+
+	for key := range from {
+		if _, exists := to[key]; !exists {
+			patch[key] = nil
+		}
+	}
+
+	return patch
+}
@@ -0,0 +1,104 @@
+package hub
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// WebSocket subprotocols selecting the wire encoding. Clients that request none use JSON.
+// Messages have the same {"type", "payload"} structure in every encoding.
+const (
+	SubprotocolJSON    = "velocity.json"
+	SubprotocolMsgPack = "velocity.msgpack" // MessagePack in binary frames, to save cellular data
+)
+
+// Subprotocols lists the supported subprotocols in order of preference, for the upgrader
+var Subprotocols = []string{SubprotocolMsgPack, SubprotocolJSON}
+
+// The hub works with JSON messages internally. Messages are transcoded from the
+// client's encoding when read, and to it when queued on the client's Send channel.
+
+// usesMsgPack reports whether the client negotiated MessagePack
+func (c *Client) usesMsgPack() bool {
+	return c.Encoding == SubprotocolMsgPack
+}
+
+// frameType returns the WebSocket frame type for the client's encoding
+func (c *Client) frameType() int {
+	if c.usesMsgPack() {
+		return websocket.BinaryMessage
+	}
+	return websocket.TextMessage
+}
+
+// queue adds a JSON message to the client's Send channel in the client's encoding without
+// blocking. It returns false if the message could not be encoded or the buffer is full,
+// in which case the drop counts towards evicting slow viewers.
+func (c *Client) queue(message *encodedMessage) bool {
+	data, err := message.forClient(c)
+	if err != nil {
+		c.logger().Error("Error encoding message for client", "error", err)
+		return false
+	}
+
+	select {
+	case c.Send <- data:
+		c.recordDelivery()
+		return true
+	default:
+		c.recordDrop()
+		return false
+	}
+}
+
+// decode converts a message received from the client to JSON
+func (c *Client) decode(message []byte) ([]byte, error) {
+	if !c.usesMsgPack() {
+		return message, nil
+	}
+
+	var value interface{}
+	if err := msgpack.Unmarshal(message, &value); err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// encodedMessage converts a JSON message to each client's encoding, encoding it at most
+// once per encoding so a broadcast isn't transcoded for every viewer
+type encodedMessage struct {
+	json    []byte
+	msgpack []byte
+}
+
+func newEncodedMessage(data []byte) *encodedMessage {
+	return &encodedMessage{json: data}
+}
+
+// forClient returns the message in the client's encoding
+func (m *encodedMessage) forClient(c *Client) ([]byte, error) {
+	if !c.usesMsgPack() {
+		return m.json, nil
+	}
+
+	if m.msgpack == nil {
+		var value interface{}
+		if err := json.Unmarshal(m.json, &value); err != nil {
+			return nil, err
+		}
+
+		var buf bytes.Buffer
+		encoder := msgpack.NewEncoder(&buf)
+		// Whole numbers (counts, most speeds) take 1-5 bytes instead of 9
+		encoder.UseCompactInts(true)
+		encoder.UseCompactFloats(true)
+		if err := encoder.Encode(value); err != nil {
+			return nil, err
+		}
+		m.msgpack = buf.Bytes()
+	}
+	return m.msgpack, nil
+}
@@ -0,0 +1,272 @@
+package hub
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"velocity-be/models"
+	"velocity-be/pubsub"
+)
+
+// Pub/sub event kinds exchanged between hub instances
+const (
+	eventBroadcast    = "broadcast"     // Data is a message for every viewer of the stream
+	eventViewerCount  = "viewer_count"  // Data is a models.ViewerCountUpdate with the publisher's local count
+	eventCloseViewers = "close_viewers" // Disconnect every viewer of the stream
+	eventCloseStream  = "close_stream"  // Close every connection of the stream
+
+	eventBroadcasterConnected = "broadcaster_connected" // The broadcaster (re)connected to the publishing instance
+	eventGeofences            = "geofences"             // Data is the stream's updated list of models.Geofence
+	eventRevokeShareToken     = "revoke_share_token"    // Data is the ID of a revoked share token
+	eventChat                 = "chat"                  // Data is a chat or reaction message for the viewers and broadcaster
+	eventModerate             = "moderate"              // Data is a models.ModerationRequest from the broadcaster
+	eventListViewers          = "list_viewers"          // Data is the ID of a viewer list request, answered with eventViewerList
+	eventViewerList           = "viewer_list"           // Data is a viewerListReply with the publisher's local viewers
+)
+
+// remoteViewerMissedHeartbeats is the number of heartbeats after which a remote count expires
+const remoteViewerMissedHeartbeats = 3
+
+// remoteViewerCount is the number of viewers another instance reported, and when
+type remoteViewerCount struct {
+	count  int
+	seenAt time.Time
+}
+
+// publish sends an event to the other instances
+func (h *Hub) publish(kind, streamID string, data []byte) {
+	err := h.PubSub.Publish(pubsub.Event{
+		Origin:    h.InstanceID,
+		Kind:      kind,
+		StreamID:  streamID,
+		Data:      data,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		slog.Error("Error publishing pub/sub event", "streamId", streamID, "kind", kind, "error", err)
+	}
+}
+
+// publishViewerCount shares the number of viewers connected to this instance
+func (h *Hub) publishViewerCount(streamID string, localCount int, newUser bool) {
+	data, err := json.Marshal(models.ViewerCountUpdate{
+		StreamID:    streamID,
+		ViewerCount: localCount,
+		NewUser:     newUser,
+	})
+	if err != nil {
+		slog.Error("Error marshaling viewer count event", "streamId", streamID, "error", err)
+		return
+	}
+
+	h.publish(eventViewerCount, streamID, data)
+}
+
+// totalViewerCount returns the number of viewers of a stream across all instances.
+// Callers must hold h.mu.
+func (h *Hub) totalViewerCount(streamHub *StreamHub) int {
+	streamHub.mu.RLock()
+	count := len(streamHub.Viewers)
+	streamHub.mu.RUnlock()
+
+	for _, remoteCount := range h.remoteViewers[streamHub.StreamID] {
+		count += remoteCount.count
+	}
+	return count
+}
+
+// closeAllViewers disconnects the viewers of a stream on this and every other instance.
+// Callers must hold h.mu.
+func (h *Hub) closeAllViewers(streamHub *StreamHub) {
+	closeViewers(streamHub)
+	delete(h.remoteViewers, streamHub.StreamID)
+	h.publish(eventCloseViewers, streamHub.StreamID, nil)
+}
+
+// handleEvent applies an event published by another instance to local clients
+func (h *Hub) handleEvent(event pubsub.Event) {
+	if event.Origin == h.InstanceID {
+		return // Already applied locally
+	}
+
+	switch event.Kind {
+	case eventBroadcast:
+		h.handleRemoteBroadcast(event.StreamID, event.Data)
+	case eventViewerCount:
+		h.handleRemoteViewerCount(event)
+	case eventCloseViewers:
+		h.handleRemoteCloseViewers(event.StreamID)
+	case eventCloseStream:
+		h.closeStream(event.StreamID)
+	case eventBroadcasterConnected:
+		h.handleRemoteBroadcasterConnected(event.StreamID)
+	case eventGeofences:
+		var fences []models.Geofence
+		if err := json.Unmarshal(event.Data, &fences); err != nil {
+			slog.Error("Error parsing geofences event", "streamId", event.StreamID, "error", err)
+			return
+		}
+		h.setGeofences(event.StreamID, fences)
+	case eventRevokeShareToken:
+		h.revokeShareToken(event.StreamID, string(event.Data))
+	case eventChat:
+		h.deliverChat(event.StreamID, event.Data)
+	case eventModerate:
+		var request models.ModerationRequest
+		if err := json.Unmarshal(event.Data, &request); err != nil {
+			slog.Error("Error parsing moderate event", "streamId", event.StreamID, "error", err)
+			return
+		}
+		h.moderate(event.StreamID, request)
+	case eventListViewers:
+		h.publishViewerList(event.StreamID, string(event.Data))
+	case eventViewerList:
+		h.handleRemoteViewerList(event)
+	default:
+		slog.Warn("Ignoring unknown pub/sub event kind", "streamId", event.StreamID, "kind", event.Kind)
+	}
+}
+
+func (h *Hub) handleRemoteBroadcast(streamID string, data []byte) {
+	h.mu.RLock()
+	streamHub, exists := h.Streams[streamID]
+	h.mu.RUnlock()
+
+	if !exists {
+		return
+	}
+
+	// Keep the late-join snapshot and delta base up to date with frames from a remote broadcaster
+	var message struct {
+		Type    string          `json:"type"`
+		Payload json.RawMessage `json:"payload"`
+	}
+	if err := json.Unmarshal(data, &message); err == nil && message.Type == "stream_data" {
+		var streamData models.StreamData
+		if err := json.Unmarshal(message.Payload, &streamData); err == nil {
+			previous := h.recordFrame(streamID, message.Payload, newTrackPoint(streamID, time.Now(), streamData))
+			h.broadcastFrame(streamID, data, previous, message.Payload)
+			return
+		}
+	}
+
+	h.broadcastToViewers(streamHub, data)
+}
+
+func (h *Hub) handleRemoteViewerCount(event pubsub.Event) {
+	var update models.ViewerCountUpdate
+	if err := json.Unmarshal(event.Data, &update); err != nil {
+		slog.Error("Error parsing viewer count event", "streamId", event.StreamID, "error", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	counts, exists := h.remoteViewers[event.StreamID]
+	if !exists {
+		counts = make(map[string]remoteViewerCount)
+		h.remoteViewers[event.StreamID] = counts
+	}
+
+	if update.ViewerCount > 0 {
+		previous := counts[event.Origin].count
+		counts[event.Origin] = remoteViewerCount{count: update.ViewerCount, seenAt: time.Now()}
+
+		// Heartbeats refresh the count without notifying the broadcaster if nothing changed
+		if previous == update.ViewerCount && !update.NewUser {
+			return
+		}
+	} else {
+		delete(counts, event.Origin)
+		if len(counts) == 0 {
+			delete(h.remoteViewers, event.StreamID)
+		}
+	}
+
+	if streamHub, exists := h.Streams[event.StreamID]; exists {
+		h.notifyBroadcasterViewerCount(streamHub, h.totalViewerCount(streamHub), update.NewUser)
+	}
+}
+
+// heartbeatViewerCounts republishes the local viewer count of every stream with viewers,
+// so other instances keep counting them, and expires the counts of instances that stopped
+// reporting theirs, notifying the affected broadcasters
+func (h *Hub) heartbeatViewerCounts(interval time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for streamID, streamHub := range h.Streams {
+		streamHub.mu.RLock()
+		localCount := len(streamHub.Viewers)
+		streamHub.mu.RUnlock()
+
+		if localCount > 0 {
+			h.publishViewerCount(streamID, localCount, false)
+		}
+	}
+
+	cutoff := time.Now().Add(-remoteViewerMissedHeartbeats * interval)
+	for streamID, counts := range h.remoteViewers {
+		expired := false
+		for instanceID, remoteCount := range counts {
+			if remoteCount.seenAt.Before(cutoff) {
+				delete(counts, instanceID)
+				expired = true
+				slog.Warn("Expiring viewer count of an unresponsive instance", "streamId", streamID, "instance", instanceID, "viewerCount", remoteCount.count)
+			}
+		}
+		if !expired {
+			continue
+		}
+
+		if len(counts) == 0 {
+			delete(h.remoteViewers, streamID)
+		}
+		if streamHub, exists := h.Streams[streamID]; exists {
+			h.notifyBroadcasterViewerCount(streamHub, h.totalViewerCount(streamHub), false)
+		}
+	}
+}
+
+func (h *Hub) handleRemoteCloseViewers(streamID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.remoteViewers, streamID)
+
+	streamHub, exists := h.Streams[streamID]
+	if !exists {
+		return
+	}
+
+	closeViewers(streamHub)
+
+	if streamHub.Broadcaster == nil && streamHub.graceTimer == nil {
+		delete(h.Streams, streamID)
+		slog.Info("Stream hub removed (no clients)", "streamId", streamID)
+	}
+}
+
+func (h *Hub) handleRemoteBroadcasterConnected(streamID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	streamHub, exists := h.Streams[streamID]
+	if !exists {
+		return
+	}
+
+	// The broadcaster came back on another instance, so stop waiting for it here
+	h.endGracePeriod(streamHub)
+
+	streamHub.mu.RLock()
+	isEmpty := streamHub.Broadcaster == nil && len(streamHub.Viewers) == 0
+	streamHub.mu.RUnlock()
+
+	if isEmpty {
+		delete(h.Streams, streamID)
+		slog.Info("Stream hub removed (no clients)", "streamId", streamID)
+	}
+}
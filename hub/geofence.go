@@ -0,0 +1,172 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"velocity-be/geo"
+	"velocity-be/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ArrivalRadiusMeters is how close to the destination the broadcaster must be to have arrived
+const ArrivalRadiusMeters = 100.0
+
+// geofenceTracker remembers which zones a stream is inside so only transitions emit events
+type geofenceTracker struct {
+	fences  []models.Geofence
+	inside  map[string]bool
+	arrived bool
+	// Destination the arrival was detected for, so a new destination can be arrived at again
+	arrivedLatitude  float64
+	arrivedLongitude float64
+}
+
+func newGeofenceTracker() *geofenceTracker {
+	return &geofenceTracker{inside: make(map[string]bool)}
+}
+
+// SetGeofences replaces the user-defined geofences evaluated for a stream on every instance
+func (h *Hub) SetGeofences(streamID string, fences []models.Geofence) {
+	h.setGeofences(streamID, fences)
+
+	data, err := json.Marshal(fences)
+	if err != nil {
+		slog.Error("Error marshaling geofences", "streamId", streamID, "error", err)
+		return
+	}
+	h.publish(eventGeofences, streamID, data)
+}
+
+func (h *Hub) setGeofences(streamID string, fences []models.Geofence) {
+	h.geofencesMu.Lock()
+	defer h.geofencesMu.Unlock()
+
+	tracker, exists := h.geofences[streamID]
+	if !exists {
+		tracker = newGeofenceTracker()
+		h.geofences[streamID] = tracker
+	}
+
+	tracker.fences = fences
+
+	// Forget zones that no longer exist
+	known := make(map[string]bool, len(fences))
+	for _, fence := range fences {
+		known[fence.ID] = true
+	}
+	for id := range tracker.inside {
+		if !known[id] {
+			delete(tracker.inside, id)
+		}
+	}
+}
+
+// forgetGeofences drops the geofence state of a closed stream
+func (h *Hub) forgetGeofences(streamID string) {
+	h.geofencesMu.Lock()
+	defer h.geofencesMu.Unlock()
+
+	delete(h.geofences, streamID)
+}
+
+// evaluateGeofences checks the current location against the destination and the
+// stream's geofences and returns the events for any transitions
+func (h *Hub) evaluateGeofences(streamID string, data models.StreamData, timestamp time.Time) []models.StreamEvent {
+	latitude := data.CurrentLocation.Latitude
+	longitude := data.CurrentLocation.Longitude
+	if latitude == 0 && longitude == 0 {
+		return nil // No GPS fix
+	}
+
+	h.geofencesMu.Lock()
+	defer h.geofencesMu.Unlock()
+
+	tracker, exists := h.geofences[streamID]
+	if !exists {
+		tracker = newGeofenceTracker()
+		h.geofences[streamID] = tracker
+	}
+
+	newEvent := func(eventType string) models.StreamEvent {
+		return models.StreamEvent{
+			ID:        primitive.NewObjectID(),
+			StreamID:  streamID,
+			Type:      eventType,
+			Timestamp: timestamp,
+			Latitude:  latitude,
+			Longitude: longitude,
+		}
+	}
+
+	var events []models.StreamEvent
+
+	// Arrival at the destination
+	destLat, destLon := data.DestinationLatitude, data.DestinationLongitude
+	if destLat != 0 || destLon != 0 {
+		if tracker.arrived && (tracker.arrivedLatitude != destLat || tracker.arrivedLongitude != destLon) {
+			tracker.arrived = false // Destination changed
+		}
+
+		if !tracker.arrived && geo.DistanceMeters(latitude, longitude, destLat, destLon) <= ArrivalRadiusMeters {
+			tracker.arrived = true
+			tracker.arrivedLatitude = destLat
+			tracker.arrivedLongitude = destLon
+
+			event := newEvent("arrived")
+			event.ZoneName = data.DestinationName
+			events = append(events, event)
+		}
+	}
+
+	// User-defined zones
+	for _, fence := range tracker.fences {
+		inside := geo.DistanceMeters(latitude, longitude, fence.Latitude, fence.Longitude) <= fence.RadiusMeters
+		if inside == tracker.inside[fence.ID] {
+			continue
+		}
+		tracker.inside[fence.ID] = inside
+
+		eventType := "left_zone"
+		if inside {
+			eventType = "entered_zone"
+		}
+		event := newEvent(eventType)
+		event.ZoneID = fence.ID
+		event.ZoneName = fence.Name
+		events = append(events, event)
+	}
+
+	return events
+}
+
+// emitStreamEvents persists stream events and broadcasts them to viewers
+func (h *Hub) emitStreamEvents(streamID string, events []models.StreamEvent) {
+	for _, event := range events {
+		go h.recordStreamEvent(event)
+
+		data, err := json.Marshal(models.WebSocketMessage{
+			Type:    event.Type,
+			Payload: event,
+		})
+		if err != nil {
+			slog.Error("Error marshaling stream event", "streamId", streamID, "type", event.Type, "error", err)
+			continue
+		}
+
+		h.BroadcastToViewers(streamID, data)
+		slog.Info("Stream event", "streamId", streamID, "type", event.Type, "zone", event.ZoneName)
+	}
+}
+
+func (h *Hub) recordStreamEvent(event models.StreamEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Store.StreamEvents().Insert(ctx, event); err != nil {
+		slog.Error("Error recording stream event", "streamId", event.StreamID, "type", event.Type, "error", err)
+	}
+}
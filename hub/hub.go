@@ -3,28 +3,80 @@ package hub
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"velocity-be/db"
 	"velocity-be/models"
+	"velocity-be/pubsub"
+	"velocity-be/store"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
-	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // Client represents a connected WebSocket client
 type Client struct {
 	ID        string
 	StreamID  string
-	Conn      *websocket.Conn
+	Conn      *websocket.Conn // nil for viewers receiving Server-Sent Events
 	Send      chan []byte
 	IsMobile  bool // true if this is the mobile app (broadcaster), false if viewer
 	Hub       *Hub
 	UserAgent string
 	IPAddress string
-	JoinLogID interface{}
+	JoinLogID primitive.ObjectID
+	Encoding  string // Negotiated subprotocol, empty for JSON
+	Deltas    bool   // Viewer opted into stream_data_delta messages
+	RequestID string // ID of the HTTP request that opened the connection, for log correlation
+
+	// Name shown with the viewer's chat messages and reactions
+	DisplayName string
+
+	// When the viewer was registered, for the broadcaster's viewer list
+	JoinedAt time.Time
+
+	// For viewers, whether the broadcaster muted their chat. For the broadcaster,
+	// whether it stopped receiving chat and reactions.
+	chatMuted atomic.Bool
+
+	// Share token the viewer joined with, if any, and when it expires
+	ShareTokenID        string
+	ShareTokenExpiresAt time.Time
+
+	// Closed once the join log is created, so the leave is only logged after it
+	joinLogged  chan struct{}
+	leaveLogged sync.Once
+
+	// Slow consumer tracking, updated by concurrent broadcasts
+	consecutiveDrops atomic.Int64
+	evicted          atomic.Bool
+	leaveReason      atomic.Value // string, why the server disconnected the client
+}
+
+// SetChatMuted mutes or unmutes a viewer's chat and reactions, e.g. when it joins
+// from an IP address the broadcaster muted
+func (c *Client) SetChatMuted(muted bool) {
+	c.chatMuted.Store(muted)
+}
+
+// role names the client's side of the stream in logs and metrics
+func (c *Client) role() string {
+	if c.IsMobile {
+		return "broadcaster"
+	}
+	return "viewer"
+}
+
+// logger returns a logger carrying the client's stream, ID, role and request ID
+func (c *Client) logger() *slog.Logger {
+	logger := slog.With("streamId", c.StreamID, "clientId", c.ID, "role", c.role())
+	if c.RequestID != "" {
+		logger = logger.With("requestId", c.RequestID)
+	}
+	return logger
 }
 
 // Hub maintains the set of active clients and broadcasts messages
@@ -38,6 +90,68 @@ type Hub struct {
 	// Unregister requests from clients
 	Unregister chan *Client
 
+	// How long viewers are kept connected after the broadcaster drops (0 disconnects them immediately)
+	BroadcasterGracePeriod time.Duration
+
+	// Distance from the navigation polyline beyond which a stream is off route, and how long
+	// it must stay that far before viewers are notified (0 notifies immediately)
+	OffRouteDistanceMeters float64
+	OffRouteDuration       time.Duration
+
+	// Number of stream_data frames between keyframes sent to viewers receiving deltas
+	KeyframeInterval int
+
+	// Limits on messages received from each connection: maximum size, sustained rate
+	// (0 for no limit) and burst. A client is disconnected after more than
+	// MaxRateLimitedMessages consecutive messages over the rate (0 never disconnects).
+	MaxMessageBytes        int64
+	MessageRate            float64
+	MessageBurst           int
+	MaxRateLimitedMessages int
+
+	// Maximum number of latest data and track point writes per stream and second, frames
+	// in between are coalesced and their track points batched (0 writes every frame)
+	StreamDataWritesPerSecond float64
+
+	// Viewers are evicted after more than MaxConsecutiveDrops messages in a row were
+	// dropped because their send buffer was full (0 never evicts)
+	MaxConsecutiveDrops int64
+
+	// Unique ID of this hub among all instances sharing the pub/sub backend
+	InstanceID string
+
+	// Fans out broadcasts and viewer counts to other instances
+	PubSub pubsub.PubSub
+
+	// Persists stream state, join logs, track points and events
+	Store store.Store
+
+	// How often the instance republishes its viewer counts. Counts reported by other
+	// instances expire after three missed heartbeats, e.g. when an instance crashed.
+	ViewerCountHeartbeat time.Duration
+
+	// Viewer counts reported by other instances, by stream ID and instance ID
+	remoteViewers map[string]map[string]remoteViewerCount
+
+	// Viewer list requests waiting for other instances to answer, by request ID
+	viewerLists   map[string]chan remoteViewerList
+	viewerListsMu sync.Mutex
+
+	// Geofence state by stream ID, guarded by its own mutex as it's updated on every frame
+	geofences   map[string]*geofenceTracker
+	geofencesMu sync.Mutex
+
+	// Speed samples for ETA estimates by stream ID
+	routes   map[string]*routeTracker
+	routesMu sync.Mutex
+
+	// Coalesced latest data writes by stream ID
+	writers   map[string]*latestDataWriter
+	writersMu sync.Mutex
+
+	// Prometheus metrics, exported when the hub is registered as a collector
+	metrics *hubMetrics
+
 	// Mutex for thread-safe access
 	mu sync.RWMutex
 }
@@ -47,26 +161,94 @@ type StreamHub struct {
 	StreamID    string
 	Broadcaster *Client
 	Viewers     map[*Client]bool
-	mu          sync.RWMutex
+	LatestData  json.RawMessage     // Payload of the last stream_data frame, replayed to late joiners
+	RecentTrack []models.TrackPoint // Bounded buffer of the most recent track points
+	graceTimer  *time.Timer         // Running while waiting for a dropped broadcaster to reconnect
+
+	framesSinceKeyframe int // Frames sent to delta viewers since the last keyframe
+	mu                  sync.RWMutex
 }
 
-// NewHub creates a new Hub instance
-func NewHub() *Hub {
+// DefaultBroadcasterGracePeriod is how long viewers wait for a dropped broadcaster by default
+const DefaultBroadcasterGracePeriod = 30 * time.Second
+
+// DefaultViewerCountHeartbeat is how often viewer counts are republished to other instances by default
+const DefaultViewerCountHeartbeat = 15 * time.Second
+
+// Default off-route detection settings
+const (
+	DefaultOffRouteDistanceMeters = 75.0
+	DefaultOffRouteDuration       = 20 * time.Second
+)
+
+// NewHub creates a new standalone Hub instance backed by an in-memory pub/sub
+func NewHub(s store.Store) *Hub {
+	return NewHubWithPubSub(s, pubsub.NewMemory())
+}
+
+// NewHubWithPubSub creates a new Hub instance that shares broadcasts and viewer
+// counts with every other hub subscribed to ps
+func NewHubWithPubSub(s store.Store, ps pubsub.PubSub) *Hub {
 	return &Hub{
 		Streams:    make(map[string]*StreamHub),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
+
+		BroadcasterGracePeriod: DefaultBroadcasterGracePeriod,
+		OffRouteDistanceMeters: DefaultOffRouteDistanceMeters,
+		OffRouteDuration:       DefaultOffRouteDuration,
+		KeyframeInterval:       DefaultKeyframeInterval,
+
+		MaxMessageBytes:           DefaultMaxMessageBytes,
+		MessageRate:               DefaultMessageRate,
+		MessageBurst:              DefaultMessageBurst,
+		MaxRateLimitedMessages:    DefaultMaxRateLimitedMessages,
+		StreamDataWritesPerSecond: DefaultStreamDataWritesPerSecond,
+		MaxConsecutiveDrops:       DefaultMaxConsecutiveDrops,
+
+		InstanceID:           uuid.New().String(),
+		PubSub:               ps,
+		Store:                s,
+		ViewerCountHeartbeat: DefaultViewerCountHeartbeat,
+
+		remoteViewers: make(map[string]map[string]remoteViewerCount),
+		viewerLists:   make(map[string]chan remoteViewerList),
+		geofences:     make(map[string]*geofenceTracker),
+		routes:        make(map[string]*routeTracker),
+		writers:       make(map[string]*latestDataWriter),
+		metrics:       newHubMetrics(),
 	}
 }
 
 // Run starts the hub's main loop
 func (h *Hub) Run() {
+	events, err := h.PubSub.Subscribe(context.Background())
+	if err != nil {
+		slog.Error("Error subscribing to pub/sub, running without fan-out", "error", err)
+	}
+
+	heartbeatInterval := h.ViewerCountHeartbeat
+	if heartbeatInterval <= 0 {
+		heartbeatInterval = DefaultViewerCountHeartbeat
+	}
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
 	for {
 		select {
+		case <-heartbeat.C:
+			h.heartbeatViewerCounts(heartbeatInterval)
 		case client := <-h.Register:
 			h.registerClient(client)
 		case client := <-h.Unregister:
 			h.unregisterClient(client)
+		case event, ok := <-events:
+			if !ok {
+				slog.Warn("Pub/sub subscription closed, running without fan-out")
+				events = nil
+				continue
+			}
+			h.handleEvent(event)
 		}
 	}
 }
@@ -85,21 +267,46 @@ func (h *Hub) registerClient(client *Client) {
 	}
 
 	if client.IsMobile {
+		// A newer connection replaces a stale one (e.g. after a cell handover)
+		if previous := streamHub.Broadcaster; previous != nil && previous != client {
+			close(previous.Send)
+		}
 		streamHub.Broadcaster = client
-		log.Printf("Mobile broadcaster registered for stream: %s", client.StreamID)
+		client.logger().Info("Mobile broadcaster registered")
+
+		// Resume the stream for viewers waiting on a reconnect, here or on another instance
+		h.endGracePeriod(streamHub)
+		h.publish(eventBroadcasterConnected, client.StreamID, nil)
+
+		if viewerCount := h.totalViewerCount(streamHub); viewerCount > 0 {
+			h.notifyBroadcasterViewerCount(streamHub, viewerCount, false)
+		}
 	} else {
+		client.JoinedAt = time.Now()
+
 		streamHub.mu.Lock()
 		streamHub.Viewers[client] = true
-		viewerCount := len(streamHub.Viewers)
+		localViewerCount := len(streamHub.Viewers)
 		streamHub.mu.Unlock()
 
-		// Log the join in the database
-		go logStreamJoin(client)
+		// Bring the new viewer up to date immediately
+		h.sendSnapshot(streamHub, client)
+
+		if client.ShareTokenID != "" {
+			h.scheduleShareTokenExpiry(client)
+		}
+
+		// Log the join in the database. The ID is assigned here so the leave can't miss it.
+		client.JoinLogID = primitive.NewObjectID()
+		client.joinLogged = make(chan struct{})
+		go h.logStreamJoin(client)
 
 		// Notify broadcaster about viewer count (newUser: true because a user just joined)
+		viewerCount := h.totalViewerCount(streamHub)
 		h.notifyBroadcasterViewerCount(streamHub, viewerCount, true)
+		h.publishViewerCount(streamHub.StreamID, localViewerCount, true)
 
-		log.Printf("Viewer joined stream %s (total viewers: %d)", client.StreamID, viewerCount)
+		client.logger().Info("Viewer joined stream", "viewerCount", viewerCount)
 	}
 }
 
@@ -113,45 +320,53 @@ func (h *Hub) unregisterClient(client *Client) {
 	}
 
 	if client.IsMobile {
-		streamHub.Broadcaster = nil
-		log.Printf("Mobile broadcaster disconnected from stream: %s", client.StreamID)
+		// Ignore stale connections that were already replaced by a reconnect
+		if streamHub.Broadcaster != client {
+			return
+		}
 
-		// Close all viewer connections when broadcaster leaves
-		streamHub.mu.Lock()
-		for viewer := range streamHub.Viewers {
-			close(viewer.Send)
-			delete(streamHub.Viewers, viewer)
+		streamHub.Broadcaster = nil
+		client.logger().Info("Mobile broadcaster disconnected")
+
+		if h.BroadcasterGracePeriod > 0 && h.totalViewerCount(streamHub) > 0 {
+			// Keep viewers connected while the broadcaster has a chance to reconnect
+			h.startGracePeriod(streamHub)
+		} else {
+			// Close all viewer connections (on every instance) when broadcaster leaves
+			h.closeAllViewers(streamHub)
 		}
-		streamHub.mu.Unlock()
 	} else {
 		streamHub.mu.Lock()
 		if _, ok := streamHub.Viewers[client]; ok {
 			delete(streamHub.Viewers, client)
 			close(client.Send)
 		}
-		viewerCount := len(streamHub.Viewers)
+		localViewerCount := len(streamHub.Viewers)
 		streamHub.mu.Unlock()
 
 		// Log the leave in the database
-		go logStreamLeave(client)
+		go h.logStreamLeave(client)
 
 		// Notify broadcaster about viewer count (newUser: false because a user left)
+		viewerCount := h.totalViewerCount(streamHub)
 		h.notifyBroadcasterViewerCount(streamHub, viewerCount, false)
+		h.publishViewerCount(streamHub.StreamID, localViewerCount, false)
 
-		log.Printf("Viewer left stream %s (total viewers: %d)", client.StreamID, viewerCount)
+		client.logger().Info("Viewer left stream", "viewerCount", viewerCount)
 	}
 
-	// Clean up empty stream hubs
+	// Clean up empty stream hubs (a pending grace period keeps the hub alive for
+	// viewers on other instances and is cleaned up when it expires)
 	streamHub.mu.RLock()
-	isEmpty := streamHub.Broadcaster == nil && len(streamHub.Viewers) == 0
+	isEmpty := streamHub.Broadcaster == nil && len(streamHub.Viewers) == 0 && streamHub.graceTimer == nil
 	streamHub.mu.RUnlock()
 
 	if isEmpty {
 		delete(h.Streams, client.StreamID)
-		log.Printf("Stream hub %s removed (no clients)", client.StreamID)
+		slog.Info("Stream hub removed (no clients)", "streamId", client.StreamID)
 
 		// Update LastConnectionAt in database when all clients disconnect
-		go updateLastConnectionTime(client.StreamID)
+		go h.updateLastConnectionTime(client.StreamID)
 	}
 }
 
@@ -171,14 +386,12 @@ func (h *Hub) notifyBroadcasterViewerCount(streamHub *StreamHub, count int, newU
 
 	data, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error marshaling viewer count: %v", err)
+		slog.Error("Error marshaling viewer count", "streamId", streamHub.StreamID, "error", err)
 		return
 	}
 
-	select {
-	case streamHub.Broadcaster.Send <- data:
-	default:
-		log.Printf("Failed to send viewer count to broadcaster")
+	if !streamHub.Broadcaster.queue(newEncodedMessage(data)) {
+		streamHub.Broadcaster.logger().Warn("Failed to send viewer count to broadcaster")
 	}
 }
 
@@ -188,23 +401,159 @@ func (h *Hub) BroadcastToViewers(streamID string, data []byte) {
 	streamHub, exists := h.Streams[streamID]
 	h.mu.RUnlock()
 
-	if !exists {
-		return
+	if exists {
+		h.broadcastToViewers(streamHub, data)
 	}
 
+	// Deliver to viewers connected to other instances
+	h.publish(eventBroadcast, streamID, data)
+}
+
+func (h *Hub) broadcastToViewers(streamHub *StreamHub, data []byte) {
 	streamHub.mu.RLock()
 	defer streamHub.mu.RUnlock()
 
+	h.metrics.messagesBroadcast.Inc()
+	message := newEncodedMessage(data)
 	for viewer := range streamHub.Viewers {
-		select {
-		case viewer.Send <- data:
-		default:
-			// Client buffer full, skip
+		// Client buffer full, skip
+		viewer.queue(message)
+	}
+}
+
+// sendToViewers marshals a typed message and sends it to all viewers of a stream,
+// on this and every other instance. Callers may hold h.mu.
+func (h *Hub) sendToViewers(streamHub *StreamHub, messageType string, payload interface{}) {
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    messageType,
+		Payload: payload,
+	})
+	if err != nil {
+		slog.Error("Error marshaling viewer message", "streamId", streamHub.StreamID, "type", messageType, "error", err)
+		return
+	}
+
+	h.broadcastToViewers(streamHub, data)
+	h.publish(eventBroadcast, streamHub.StreamID, data)
+}
+
+// closeViewers disconnects every viewer of a stream hub
+func closeViewers(streamHub *StreamHub) {
+	streamHub.mu.Lock()
+	defer streamHub.mu.Unlock()
+
+	for viewer := range streamHub.Viewers {
+		close(viewer.Send)
+		delete(streamHub.Viewers, viewer)
+	}
+}
+
+// disconnectViewers tells the matching viewers of a stream why they are disconnected and
+// closes them, recording leaveReason in their join logs if it is not empty. Their ReadPump
+// then unregisters them, which updates the viewer count.
+func (h *Hub) disconnectViewers(streamID, messageType string, payload interface{}, leaveReason string, match func(c *Client) bool) {
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    messageType,
+		Payload: payload,
+	})
+	if err != nil {
+		slog.Error("Error marshaling disconnect message", "streamId", streamID, "type", messageType, "error", err)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	streamHub, exists := h.Streams[streamID]
+	if !exists {
+		return
+	}
+
+	streamHub.mu.Lock()
+	defer streamHub.mu.Unlock()
+
+	for viewer := range streamHub.Viewers {
+		if !match(viewer) {
+			continue
 		}
+
+		if leaveReason != "" {
+			viewer.leaveReason.Store(leaveReason)
+		}
+		viewer.queue(newEncodedMessage(data))
+		close(viewer.Send)
+		delete(streamHub.Viewers, viewer)
+		viewer.logger().Info("Disconnected viewer", "reason", messageType)
+	}
+}
+
+// startGracePeriod notifies viewers that the broadcaster dropped and schedules their
+// disconnection unless the broadcaster re-registers in time. Callers must hold h.mu.
+func (h *Hub) startGracePeriod(streamHub *StreamHub) {
+	deadline := time.Now().Add(h.BroadcasterGracePeriod)
+
+	h.sendToViewers(streamHub, "broadcaster_reconnecting", models.BroadcasterStatusUpdate{
+		StreamID:           streamHub.StreamID,
+		GracePeriodSeconds: h.BroadcasterGracePeriod.Seconds(),
+		ReconnectDeadline:  &deadline,
+	})
+
+	if streamHub.graceTimer != nil {
+		streamHub.graceTimer.Stop()
 	}
+	var timer *time.Timer
+	timer = time.AfterFunc(h.BroadcasterGracePeriod, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+
+		// The broadcaster reconnected or a newer grace period replaced this one
+		if streamHub.graceTimer != timer {
+			return
+		}
+		h.expireGracePeriod(streamHub)
+	})
+	streamHub.graceTimer = timer
+
+	slog.Info("Waiting for broadcaster to reconnect", "streamId", streamHub.StreamID, "gracePeriod", h.BroadcasterGracePeriod)
+}
+
+// endGracePeriod stops a pending grace period and tells viewers the broadcaster is back.
+// Callers must hold h.mu.
+func (h *Hub) endGracePeriod(streamHub *StreamHub) {
+	if streamHub.graceTimer == nil {
+		return
+	}
+
+	streamHub.graceTimer.Stop()
+	streamHub.graceTimer = nil
+
+	h.sendToViewers(streamHub, "broadcaster_reconnected", models.BroadcasterStatusUpdate{
+		StreamID: streamHub.StreamID,
+	})
+	slog.Info("Mobile broadcaster reconnected", "streamId", streamHub.StreamID)
 }
 
-// GetViewerCount returns the number of viewers for a stream
+// expireGracePeriod disconnects the viewers of a stream whose broadcaster did not come back.
+// Callers must hold h.mu.
+func (h *Hub) expireGracePeriod(streamHub *StreamHub) {
+	streamHub.graceTimer = nil
+
+	// The stream hub was closed or replaced in the meantime
+	if h.Streams[streamHub.StreamID] != streamHub {
+		return
+	}
+
+	slog.Info("Broadcaster did not reconnect, disconnecting viewers", "streamId", streamHub.StreamID)
+
+	h.closeAllViewers(streamHub)
+
+	delete(h.Streams, streamHub.StreamID)
+	slog.Info("Stream hub removed (no clients)", "streamId", streamHub.StreamID)
+
+	go h.updateLastConnectionTime(streamHub.StreamID)
+}
+
+// GetViewerCount returns the number of viewers for a stream connected to this instance
 func (h *Hub) GetViewerCount(streamID string) int {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
@@ -217,17 +566,33 @@ func (h *Hub) GetViewerCount(streamID string) int {
 	return 0
 }
 
-// CloseStream closes all connections for a specific stream
+// CloseStream closes all connections for a specific stream on every instance
 func (h *Hub) CloseStream(streamID string) {
+	h.closeStream(streamID)
+	h.publish(eventCloseStream, streamID, nil)
+}
+
+// closeStream closes all connections for a specific stream on this instance
+func (h *Hub) closeStream(streamID string) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	delete(h.remoteViewers, streamID)
+	h.forgetGeofences(streamID)
+	h.forgetRoute(streamID)
+	h.forgetLatestData(streamID)
+
 	streamHub, exists := h.Streams[streamID]
 	if !exists {
 		return
 	}
 
-	log.Printf("Closing stream %s and disconnecting all clients", streamID)
+	slog.Info("Closing stream and disconnecting all clients", "streamId", streamID)
+
+	if streamHub.graceTimer != nil {
+		streamHub.graceTimer.Stop()
+		streamHub.graceTimer = nil
+	}
 
 	// Close broadcaster connection
 	if streamHub.Broadcaster != nil {
@@ -240,66 +605,67 @@ func (h *Hub) CloseStream(streamID string) {
 	streamHub.mu.Lock()
 	for viewer := range streamHub.Viewers {
 		close(viewer.Send)
-		viewer.Conn.Close()
+		if viewer.Conn != nil {
+			viewer.Conn.Close()
+		}
 		delete(streamHub.Viewers, viewer)
 	}
 	streamHub.mu.Unlock()
 
 	// Remove the stream hub
 	delete(h.Streams, streamID)
-	log.Printf("Stream %s closed successfully", streamID)
+	slog.Info("Stream closed", "streamId", streamID)
 }
 
-func logStreamJoin(client *Client) {
+func (h *Hub) logStreamJoin(client *Client) {
+	defer close(client.joinLogged)
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	joinLog := models.StreamJoinLog{
+		ID:        client.JoinLogID,
 		StreamID:  client.StreamID,
-		JoinedAt:  time.Now(),
+		JoinedAt:  client.JoinedAt,
 		UserAgent: client.UserAgent,
 		IPAddress: client.IPAddress,
+
+		ClientID:    client.ID,
+		DisplayName: client.DisplayName,
 	}
 
-	result, err := db.StreamJoinLogsCollection().InsertOne(ctx, joinLog)
-	if err != nil {
-		log.Printf("Error logging stream join: %v", err)
-		return
+	if err := h.Store.JoinLogs().Create(ctx, &joinLog); err != nil {
+		client.logger().Error("Error logging stream join", "error", err)
 	}
-	client.JoinLogID = result.InsertedID
 }
 
-func logStreamLeave(client *Client) {
-	if client.JoinLogID == nil {
-		return
+// logStreamLeave records when a viewer left, once, after its join was logged. Viewers
+// disconnected by the hub are unregistered again when their connection closes.
+func (h *Hub) logStreamLeave(client *Client) {
+	if client.joinLogged == nil {
+		return // Never registered
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	client.leaveLogged.Do(func() {
+		leftAt := time.Now()
+		<-client.joinLogged
 
-	now := time.Now()
-	_, err := db.StreamJoinLogsCollection().UpdateOne(
-		ctx,
-		bson.M{"_id": client.JoinLogID},
-		bson.M{"$set": bson.M{"leftAt": now}},
-	)
-	if err != nil {
-		log.Printf("Error logging stream leave: %v", err)
-	}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		reason, _ := client.leaveReason.Load().(string)
+		if err := h.Store.JoinLogs().MarkLeft(ctx, client.JoinLogID, leftAt, reason); err != nil {
+			client.logger().Error("Error logging stream leave", "error", err)
+		}
+	})
 }
 
-func updateLastConnectionTime(streamID string) {
+func (h *Hub) updateLastConnectionTime(streamID string) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	now := time.Now()
-	_, err := db.StreamsCollection().UpdateOne(
-		ctx,
-		bson.M{"streamId": streamID},
-		bson.M{"$set": bson.M{"lastConnectionAt": now}},
-	)
-	if err != nil {
-		log.Printf("Error updating last connection time for stream %s: %v", streamID, err)
+	if err := h.Store.Streams().UpdateLastConnection(ctx, streamID, time.Now()); err != nil {
+		slog.Error("Error updating last connection time", "streamId", streamID, "error", err)
 	}
 }
 
@@ -315,12 +681,12 @@ func (h *Hub) StartInactiveStreamCleanup(ctx context.Context) {
 	ticker := time.NewTicker(InactiveStreamCleanupInterval)
 	defer ticker.Stop()
 
-	log.Printf("Starting inactive stream cleanup job (interval: %v, timeout: %v)", InactiveStreamCleanupInterval, InactiveStreamTimeout)
+	slog.Info("Starting inactive stream cleanup job", "interval", InactiveStreamCleanupInterval, "timeout", InactiveStreamTimeout)
 
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("Stopping inactive stream cleanup job")
+			slog.Info("Stopping inactive stream cleanup job")
 			return
 		case <-ticker.C:
 			h.cleanupInactiveStreams()
@@ -332,34 +698,12 @@ func (h *Hub) cleanupInactiveStreams() {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	logger := slog.With("job", "inactive_stream_cleanup")
 	cutoffTime := time.Now().Add(-InactiveStreamTimeout)
 
-	// Find streams that:
-	// 1. Are still active (isActive: true)
-	// 2. Haven't been manually deleted (deletedAt: null)
-	// 3. Have lastConnectionAt older than 6 hours OR lastConnectionAt is null and createdAt is older than 6 hours
-	filter := bson.M{
-		"isActive":  true,
-		"deletedAt": nil,
-		"$or": []bson.M{
-			{"lastConnectionAt": bson.M{"$lt": cutoffTime}},
-			{
-				"lastConnectionAt": nil,
-				"createdAt":        bson.M{"$lt": cutoffTime},
-			},
-		},
-	}
-
-	cursor, err := db.StreamsCollection().Find(ctx, filter)
+	streamsToCancel, err := h.Store.Streams().ListInactive(ctx, cutoffTime)
 	if err != nil {
-		log.Printf("Error finding inactive streams: %v", err)
-		return
-	}
-	defer cursor.Close(ctx)
-
-	var streamsToCancel []models.Stream
-	if err := cursor.All(ctx, &streamsToCancel); err != nil {
-		log.Printf("Error decoding inactive streams: %v", err)
+		logger.Error("Error finding inactive streams", "error", err)
 		return
 	}
 
@@ -367,21 +711,22 @@ func (h *Hub) cleanupInactiveStreams() {
 		// Double-check that there are no active connections in the hub
 		if h.HasActiveConnections(stream.StreamID) {
 			// Stream has active connections, update lastConnectionAt and skip
-			go updateLastConnectionTime(stream.StreamID)
+			go h.updateLastConnectionTime(stream.StreamID)
 			continue
 		}
 
 		// Auto-cancel the stream
 		if err := h.autoCancelStream(ctx, stream.StreamID); err != nil {
-			log.Printf("Error auto-cancelling stream %s: %v", stream.StreamID, err)
+			logger.Error("Error auto-cancelling stream", "streamId", stream.StreamID, "error", err)
 			continue
 		}
+		h.metrics.autoCancelled.Inc()
 
-		log.Printf("Auto-cancelled inactive stream: %s (no connections for 6+ hours)", stream.StreamID)
+		logger.Info("Auto-cancelled inactive stream", "streamId", stream.StreamID, "timeout", InactiveStreamTimeout)
 	}
 
 	if len(streamsToCancel) > 0 {
-		log.Printf("Inactive stream cleanup completed: checked %d streams", len(streamsToCancel))
+		logger.Info("Inactive stream cleanup completed", "checked", len(streamsToCancel))
 	}
 }
 
@@ -402,24 +747,14 @@ func (h *Hub) HasActiveConnections(streamID string) bool {
 }
 
 func (h *Hub) autoCancelStream(ctx context.Context, streamID string) error {
-	now := time.Now()
-
-	_, err := db.StreamsCollection().UpdateOne(
-		ctx,
-		bson.M{"streamId": streamID},
-		bson.M{
-			"$set": bson.M{
-				"isActive":      false,
-				"autoCancelled": true,
-				"deletedAt":     now,
-				"updatedAt":     now,
-			},
-		},
-	)
-	if err != nil {
+	if err := h.Store.Streams().MarkDeleted(ctx, streamID, time.Now(), true); err != nil {
 		return err
 	}
 
+	if _, err := h.SaveTripSummary(ctx, streamID); err != nil {
+		slog.Error("Error saving trip summary", "streamId", streamID, "error", err)
+	}
+
 	// Close any remaining connections (shouldn't be any, but just in case)
 	h.CloseStream(streamID)
 
@@ -0,0 +1,82 @@
+package hub
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// hubMetrics are the Prometheus metrics of a hub. The hub is a prometheus.Collector,
+// so they are only exported once it is registered.
+type hubMetrics struct {
+	messagesBroadcast prometheus.Counter
+	droppedSends      *prometheus.CounterVec
+	autoCancelled     prometheus.Counter
+
+	// Connection gauges, computed from the hub's state when collected
+	streamHubs   *prometheus.Desc
+	broadcasters *prometheus.Desc
+	viewers      *prometheus.Desc
+}
+
+func newHubMetrics() *hubMetrics {
+	return &hubMetrics{
+		messagesBroadcast: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "velocity_messages_broadcast_total",
+			Help: "Messages fanned out to the viewers of a stream connected to this instance.",
+		}),
+		droppedSends: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "velocity_dropped_sends_total",
+			Help: "Messages dropped because a client's send buffer was full.",
+		}, []string{"role"}),
+		autoCancelled: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "velocity_auto_cancelled_streams_total",
+			Help: "Streams auto-cancelled after being inactive for too long.",
+		}),
+
+		streamHubs:   prometheus.NewDesc("velocity_stream_hubs", "Streams with clients connected to this instance.", nil, nil),
+		broadcasters: prometheus.NewDesc("velocity_broadcasters", "Broadcasters connected to this instance.", nil, nil),
+		viewers:      prometheus.NewDesc("velocity_viewers", "Viewers connected to this instance.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (h *Hub) Describe(ch chan<- *prometheus.Desc) {
+	h.metrics.messagesBroadcast.Describe(ch)
+	h.metrics.droppedSends.Describe(ch)
+	h.metrics.autoCancelled.Describe(ch)
+	ch <- h.metrics.streamHubs
+	ch <- h.metrics.broadcasters
+	ch <- h.metrics.viewers
+}
+
+// Collect implements prometheus.Collector
+func (h *Hub) Collect(ch chan<- prometheus.Metric) {
+	h.metrics.messagesBroadcast.Collect(ch)
+	h.metrics.droppedSends.Collect(ch)
+	h.metrics.autoCancelled.Collect(ch)
+
+	h.mu.RLock()
+	streamHubs := len(h.Streams)
+	var broadcasters, viewers int
+	for _, streamHub := range h.Streams {
+		streamHub.mu.RLock()
+		if streamHub.Broadcaster != nil {
+			broadcasters++
+		}
+		viewers += len(streamHub.Viewers)
+		streamHub.mu.RUnlock()
+	}
+	h.mu.RUnlock()
+
+	ch <- prometheus.MustNewConstMetric(h.metrics.streamHubs, prometheus.GaugeValue, float64(streamHubs))
+	ch <- prometheus.MustNewConstMetric(h.metrics.broadcasters, prometheus.GaugeValue, float64(broadcasters))
+	ch <- prometheus.MustNewConstMetric(h.metrics.viewers, prometheus.GaugeValue, float64(viewers))
+}
+
+// recordDroppedSend counts a message dropped for a client
+func (c *Client) recordDroppedSend() {
+	if c.Hub == nil {
+		return
+	}
+
+	c.Hub.metrics.droppedSends.WithLabelValues(c.role()).Inc()
+}
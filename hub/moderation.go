@@ -0,0 +1,431 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"time"
+
+	"velocity-be/models"
+	"velocity-be/pubsub"
+
+	"github.com/google/uuid"
+)
+
+// handleModerate applies the broadcaster's moderation of a viewer
+func (h *Hub) handleModerate(c *Client, payload json.RawMessage) {
+	var request models.ModerationRequest
+	if err := json.Unmarshal(payload, &request); err != nil {
+		h.sendError(c, "Invalid moderate payload")
+		return
+	}
+	if err := request.Validate(); err != nil {
+		h.sendError(c, "Invalid moderate payload: "+err.Error())
+		return
+	}
+
+	switch request.Action {
+	case models.ModerationBan:
+		// Resolving the viewer's IP address and saving the ban need the store
+		go h.banViewer(c, request)
+	case models.ModerationMute, models.ModerationUnmute:
+		go h.muteViewer(c, request)
+	default:
+		h.applyModeration(c, request)
+	}
+}
+
+// applyModeration applies a moderation request on this and every other instance, as the
+// viewer may be connected to any of them
+func (h *Hub) applyModeration(c *Client, request models.ModerationRequest) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		c.logger().Error("Error marshaling moderate event", "error", err)
+		return
+	}
+
+	c.logger().Info("Moderating viewer", "action", request.Action, "viewerId", request.ClientID, "viewerIp", request.IPAddress)
+	h.moderate(c.StreamID, request)
+	h.publish(eventModerate, c.StreamID, data)
+}
+
+// banViewer saves a ban of the viewer's IP address, so it is rejected for the rest of the stream,
+// disconnects its connections and confirms the ban to the broadcaster
+func (h *Hub) banViewer(c *Client, request models.ModerationRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !h.resolveIPAddress(ctx, c, &request) {
+		return
+	}
+
+	ban := models.ViewerBan{
+		IPAddress: request.IPAddress,
+		ClientID:  request.ClientID,
+		BannedAt:  time.Now(),
+	}
+	if err := h.Store.Streams().AddBan(ctx, c.StreamID, ban); err != nil {
+		c.logger().Error("Error saving viewer ban", "error", err)
+		h.sendError(c, "Failed to ban viewer")
+		return
+	}
+
+	h.applyModeration(c, request)
+
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    "viewer_banned",
+		Payload: ban,
+	})
+	if err != nil {
+		c.logger().Error("Error marshaling viewer ban", "error", err)
+		return
+	}
+	h.sendToClient(c, data)
+}
+
+// muteViewer saves or lifts a mute of the viewer's IP address, so it also applies to the
+// viewer's later connections, then applies it to its current ones
+func (h *Hub) muteViewer(c *Client, request models.ModerationRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !h.resolveIPAddress(ctx, c, &request) {
+		return
+	}
+
+	var err error
+	if request.Action == models.ModerationMute {
+		err = h.Store.Streams().AddMute(ctx, c.StreamID, models.ViewerMute{
+			IPAddress: request.IPAddress,
+			ClientID:  request.ClientID,
+			MutedAt:   time.Now(),
+		})
+	} else {
+		err = h.Store.Streams().RemoveMute(ctx, c.StreamID, request.IPAddress)
+	}
+	if err != nil {
+		c.logger().Error("Error saving viewer mute", "action", request.Action, "error", err)
+		h.sendError(c, "Failed to "+request.Action+" viewer")
+		return
+	}
+
+	h.applyModeration(c, request)
+}
+
+// resolveIPAddress fills in the IP address of a request made by client ID. It sends the
+// broadcaster an error and returns false when the viewer can't be found.
+func (h *Hub) resolveIPAddress(ctx context.Context, c *Client, request *models.ModerationRequest) bool {
+	if request.IPAddress != "" {
+		return true
+	}
+
+	ipAddress, err := h.viewerIPAddress(ctx, c.StreamID, request.ClientID)
+	if err != nil {
+		c.logger().Error("Error looking up viewer to moderate", "action", request.Action, "viewerId", request.ClientID, "error", err)
+		h.sendError(c, "Failed to "+request.Action+" viewer")
+		return false
+	}
+	if ipAddress == "" {
+		h.sendError(c, "Unknown viewer")
+		return false
+	}
+	request.IPAddress = ipAddress
+	return true
+}
+
+// viewerIPAddress finds the IP address of a viewer connected to this or another instance,
+// returning an empty string if no viewer of the stream has the client ID
+func (h *Hub) viewerIPAddress(ctx context.Context, streamID, clientID string) (string, error) {
+	if ipAddress := h.localViewerIPAddress(streamID, clientID); ipAddress != "" {
+		return ipAddress, nil
+	}
+
+	joinLogs, err := h.Store.JoinLogs().List(ctx, streamID)
+	if err != nil {
+		return "", err
+	}
+	for _, joinLog := range joinLogs {
+		if joinLog.ClientID == clientID {
+			return joinLog.IPAddress, nil
+		}
+	}
+	return "", nil
+}
+
+func (h *Hub) localViewerIPAddress(streamID, clientID string) string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	streamHub, exists := h.Streams[streamID]
+	if !exists {
+		return ""
+	}
+
+	streamHub.mu.RLock()
+	defer streamHub.mu.RUnlock()
+
+	for viewer := range streamHub.Viewers {
+		if viewer.ID == clientID {
+			return viewer.IPAddress
+		}
+	}
+	return ""
+}
+
+// moderate applies a moderation request to the matching local viewers of a stream
+func (h *Hub) moderate(streamID string, request models.ModerationRequest) {
+	notice := models.ViewerModerated{StreamID: streamID}
+	match := func(c *Client) bool {
+		return (request.ClientID != "" && c.ID == request.ClientID) ||
+			(request.IPAddress != "" && c.IPAddress == request.IPAddress)
+	}
+
+	switch request.Action {
+	case models.ModerationKick:
+		h.disconnectViewers(streamID, "kicked", notice, models.LeaveReasonKicked, match)
+		return
+	case models.ModerationBan:
+		h.disconnectViewers(streamID, "banned", notice, models.LeaveReasonBanned, match)
+		return
+	}
+
+	muted := request.Action == models.ModerationMute
+	messageType := "chat_unmuted"
+	if muted {
+		messageType = "chat_muted"
+	}
+
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    messageType,
+		Payload: notice,
+	})
+	if err != nil {
+		slog.Error("Error marshaling moderation message", "streamId", streamID, "type", messageType, "error", err)
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	streamHub, exists := h.Streams[streamID]
+	if !exists {
+		return
+	}
+
+	streamHub.mu.RLock()
+	defer streamHub.mu.RUnlock()
+
+	for viewer := range streamHub.Viewers {
+		if match(viewer) {
+			viewer.chatMuted.Store(muted)
+			viewer.queue(newEncodedMessage(data))
+		}
+	}
+}
+
+// viewerListTimeout is how long the broadcaster's instance waits for the other instances
+// to list their viewers before falling back to the join logs
+const viewerListTimeout = 2 * time.Second
+
+// viewerListReply is an instance's answer to a viewer list request
+type viewerListReply struct {
+	RequestID string              `json:"requestId"`
+	Viewers   []models.ViewerInfo `json:"viewers"`
+}
+
+// remoteViewerList is a viewerListReply received from the instance Origin
+type remoteViewerList struct {
+	Origin  string
+	Viewers []models.ViewerInfo
+}
+
+// sendViewerList sends the broadcaster the viewers connected to the stream: those of this
+// instance, and those of the other instances reporting viewers, which are asked over pub/sub.
+// Instances that don't answer in time are covered by the open join logs.
+func (h *Hub) sendViewerList(c *Client) {
+	viewers := h.localViewers(c.StreamID)
+
+	if instances := h.remoteViewerInstances(c.StreamID); len(instances) > 0 {
+		remote, complete := h.requestRemoteViewers(c.StreamID, instances)
+		viewers = append(viewers, remote...)
+
+		if !complete {
+			var err error
+			if viewers, err = h.mergeJoinLogViewers(c.StreamID, viewers); err != nil {
+				c.logger().Error("Error listing viewers", "error", err)
+				h.sendError(c, "Failed to list viewers")
+				return
+			}
+		}
+	}
+
+	sort.Slice(viewers, func(i, j int) bool {
+		return viewers[i].JoinedAt.Before(viewers[j].JoinedAt)
+	})
+
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type: "viewer_list",
+		Payload: models.ViewerList{
+			StreamID: c.StreamID,
+			Viewers:  viewers,
+		},
+	})
+	if err != nil {
+		c.logger().Error("Error marshaling viewer list", "error", err)
+		return
+	}
+	h.sendToClient(c, data)
+}
+
+// localViewers lists the viewers of a stream connected to this instance
+func (h *Hub) localViewers(streamID string) []models.ViewerInfo {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	viewers := []models.ViewerInfo{}
+
+	streamHub, exists := h.Streams[streamID]
+	if !exists {
+		return viewers
+	}
+
+	streamHub.mu.RLock()
+	defer streamHub.mu.RUnlock()
+
+	for viewer := range streamHub.Viewers {
+		viewers = append(viewers, models.ViewerInfo{
+			ClientID:    viewer.ID,
+			DisplayName: viewer.DisplayName,
+			IPAddress:   viewer.IPAddress,
+			UserAgent:   viewer.UserAgent,
+			JoinedAt:    viewer.JoinedAt,
+		})
+	}
+	return viewers
+}
+
+// remoteViewerInstances returns the IDs of the other instances reporting viewers of a stream
+func (h *Hub) remoteViewerInstances(streamID string) map[string]bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	instances := make(map[string]bool)
+	for instanceID := range h.remoteViewers[streamID] {
+		instances[instanceID] = true
+	}
+	return instances
+}
+
+// requestRemoteViewers asks the other instances for their viewers of a stream and waits
+// until every one of instances answered, or viewerListTimeout. It reports whether all did.
+func (h *Hub) requestRemoteViewers(streamID string, instances map[string]bool) ([]models.ViewerInfo, bool) {
+	requestID := uuid.New().String()
+	replies := make(chan remoteViewerList, len(instances))
+
+	h.viewerListsMu.Lock()
+	h.viewerLists[requestID] = replies
+	h.viewerListsMu.Unlock()
+
+	defer func() {
+		h.viewerListsMu.Lock()
+		delete(h.viewerLists, requestID)
+		h.viewerListsMu.Unlock()
+	}()
+
+	h.publish(eventListViewers, streamID, []byte(requestID))
+
+	timeout := time.NewTimer(viewerListTimeout)
+	defer timeout.Stop()
+
+	var viewers []models.ViewerInfo
+	for len(instances) > 0 {
+		select {
+		case reply := <-replies:
+			if instances[reply.Origin] {
+				delete(instances, reply.Origin)
+				viewers = append(viewers, reply.Viewers...)
+			}
+		case <-timeout.C:
+			slog.Warn("Instances didn't list their viewers in time", "streamId", streamID, "instances", len(instances))
+			return viewers, false
+		}
+	}
+	return viewers, true
+}
+
+// publishViewerList answers another instance's viewer list request with the local viewers
+// of the stream, if this instance has any clients of it
+func (h *Hub) publishViewerList(streamID, requestID string) {
+	h.mu.RLock()
+	_, exists := h.Streams[streamID]
+	h.mu.RUnlock()
+
+	if !exists {
+		return
+	}
+
+	data, err := json.Marshal(viewerListReply{
+		RequestID: requestID,
+		Viewers:   h.localViewers(streamID),
+	})
+	if err != nil {
+		slog.Error("Error marshaling viewer list event", "streamId", streamID, "error", err)
+		return
+	}
+	h.publish(eventViewerList, streamID, data)
+}
+
+// handleRemoteViewerList hands another instance's viewers to the pending request, if it
+// was made by this instance
+func (h *Hub) handleRemoteViewerList(event pubsub.Event) {
+	var reply viewerListReply
+	if err := json.Unmarshal(event.Data, &reply); err != nil {
+		slog.Error("Error parsing viewer list event", "streamId", event.StreamID, "error", err)
+		return
+	}
+
+	h.viewerListsMu.Lock()
+	defer h.viewerListsMu.Unlock()
+
+	replies, exists := h.viewerLists[reply.RequestID]
+	if !exists {
+		return
+	}
+
+	select {
+	case replies <- remoteViewerList{Origin: event.Origin, Viewers: reply.Viewers}:
+	default: // Duplicate answer from an instance, the buffer holds one per instance
+	}
+}
+
+// mergeJoinLogViewers adds the viewers of open join logs missing from viewers, covering the
+// instances that didn't answer. Recently closed connections may still be listed.
+func (h *Hub) mergeJoinLogViewers(streamID string, viewers []models.ViewerInfo) ([]models.ViewerInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	joinLogs, err := h.Store.JoinLogs().List(ctx, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := make(map[string]bool, len(viewers))
+	for _, viewer := range viewers {
+		listed[viewer.ClientID] = true
+	}
+
+	for _, joinLog := range joinLogs {
+		if joinLog.LeftAt != nil || listed[joinLog.ClientID] {
+			continue
+		}
+		viewers = append(viewers, models.ViewerInfo{
+			ClientID:    joinLog.ClientID,
+			DisplayName: joinLog.DisplayName,
+			IPAddress:   joinLog.IPAddress,
+			UserAgent:   joinLog.UserAgent,
+			JoinedAt:    joinLog.JoinedAt,
+		})
+	}
+	return viewers, nil
+}
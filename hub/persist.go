@@ -0,0 +1,120 @@
+package hub
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"velocity-be/models"
+)
+
+// DefaultStreamDataWritesPerSecond is how often a stream's latest data and track points are written to the store by default
+const DefaultStreamDataWritesPerSecond = 1.0
+
+// latestDataWriter coalesces the stream_data frames of a stream into at most one
+// flush per interval. Only the newest pending frame is written, along with the
+// track points of every frame since the last flush in one batch.
+type latestDataWriter struct {
+	pending       *models.StreamData
+	pendingPoints []models.TrackPoint
+	scheduled     bool
+	lastWrite     time.Time
+	writeMu       sync.Mutex // Serializes writes so an older frame never overwrites a newer one
+}
+
+// persistFrame schedules a write of a stream's latest data and of the frame's track point,
+// if any, immediately if the previous write is older than the write interval
+func (h *Hub) persistFrame(streamID string, data models.StreamData, point *models.TrackPoint) {
+	if h.StreamDataWritesPerSecond <= 0 {
+		go h.updateStreamData(streamID, data)
+		if point != nil {
+			go h.recordTrackPoints(streamID, []models.TrackPoint{*point})
+		}
+		return
+	}
+	interval := time.Duration(float64(time.Second) / h.StreamDataWritesPerSecond)
+
+	h.writersMu.Lock()
+	defer h.writersMu.Unlock()
+
+	writer, exists := h.writers[streamID]
+	if !exists {
+		writer = &latestDataWriter{}
+		h.writers[streamID] = writer
+	}
+
+	writer.pending = &data
+	if point != nil {
+		writer.pendingPoints = append(writer.pendingPoints, *point)
+	}
+	if writer.scheduled {
+		return
+	}
+	writer.scheduled = true
+
+	delay := time.Until(writer.lastWrite.Add(interval))
+	time.AfterFunc(max(delay, 0), func() {
+		h.flushLatestData(streamID, writer)
+	})
+}
+
+// flushLatestData writes a stream's pending data and track points
+func (h *Hub) flushLatestData(streamID string, writer *latestDataWriter) {
+	writer.writeMu.Lock()
+	defer writer.writeMu.Unlock()
+
+	h.writersMu.Lock()
+	data := writer.pending
+	points := writer.pendingPoints
+	writer.pending = nil
+	writer.pendingPoints = nil
+	writer.scheduled = false
+	writer.lastWrite = time.Now()
+	h.writersMu.Unlock()
+
+	if data != nil {
+		h.updateStreamData(streamID, *data)
+	}
+	if len(points) > 0 {
+		h.recordTrackPoints(streamID, points)
+	}
+}
+
+// flushPendingWrites writes a stream's pending data and track points now, waiting for
+// a flush in progress, so the store is up to date when it returns
+func (h *Hub) flushPendingWrites(streamID string) {
+	h.writersMu.Lock()
+	writer, exists := h.writers[streamID]
+	h.writersMu.Unlock()
+
+	if exists {
+		h.flushLatestData(streamID, writer)
+	}
+}
+
+// forgetLatestData drops the write state of a closed stream. A pending write still happens.
+func (h *Hub) forgetLatestData(streamID string) {
+	h.writersMu.Lock()
+	defer h.writersMu.Unlock()
+
+	delete(h.writers, streamID)
+}
+
+func (h *Hub) recordTrackPoints(streamID string, points []models.TrackPoint) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Store.TrackPoints().InsertMany(ctx, points); err != nil {
+		slog.Error("Error recording track points", "streamId", streamID, "points", len(points), "error", err)
+	}
+}
+
+func (h *Hub) updateStreamData(streamID string, data models.StreamData) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.Store.Streams().UpdateLatestData(ctx, streamID, data); err != nil {
+		slog.Error("Error updating stream data", "streamId", streamID, "error", err)
+	}
+}
@@ -0,0 +1,76 @@
+package hub
+
+import (
+	"encoding/json"
+	"time"
+
+	"velocity-be/models"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/time/rate"
+)
+
+// Default limits on messages received from a WebSocket connection
+const (
+	DefaultMaxMessageBytes        = 512 * 1024 // 512KB
+	DefaultMessageRate            = 10.0       // Messages per second
+	DefaultMessageBurst           = 20
+	DefaultMaxRateLimitedMessages = 50
+)
+
+// messageLimiter enforces the hub's message rate on a single connection
+type messageLimiter struct {
+	limiter     *rate.Limiter
+	rateLimited int // Consecutive messages dropped for exceeding the rate
+}
+
+func (h *Hub) newMessageLimiter() *messageLimiter {
+	limit := rate.Limit(h.MessageRate)
+	if h.MessageRate <= 0 {
+		limit = rate.Inf
+	}
+	return &messageLimiter{limiter: rate.NewLimiter(limit, h.MessageBurst)}
+}
+
+// allowMessage reports whether a message received now may be processed. When it may not,
+// the client is told once per run of dropped messages, and abusive reports whether
+// the client exceeded MaxRateLimitedMessages and must be disconnected.
+func (h *Hub) allowMessage(c *Client, l *messageLimiter) (allowed, abusive bool) {
+	if l.limiter.Allow() {
+		l.rateLimited = 0
+		return true, false
+	}
+
+	l.rateLimited++
+	if l.rateLimited == 1 {
+		h.sendRateLimited(c)
+	}
+
+	return false, h.MaxRateLimitedMessages > 0 && l.rateLimited > h.MaxRateLimitedMessages
+}
+
+// sendRateLimited tells a client that its messages are being dropped
+func (h *Hub) sendRateLimited(c *Client) {
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type: "rate_limited",
+		Payload: models.RateLimitNotice{
+			MessagesPerSecond: h.MessageRate,
+			Burst:             h.MessageBurst,
+		},
+	})
+	if err != nil {
+		c.logger().Error("Error marshaling rate_limited message", "error", err)
+		return
+	}
+
+	h.sendToClient(c, data)
+}
+
+// closeAbusive closes a client's connection with a policy violation. WriteControl
+// may be called concurrently with WritePump.
+func closeAbusive(c *Client) {
+	c.logger().Warn("Disconnecting client for exceeding the message rate")
+
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "message rate exceeded")
+	c.Conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+}
@@ -0,0 +1,380 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/gorilla/websocket"
+)
+
+// ReplaySpeeds are the playback speeds a replay viewer may choose
+var ReplaySpeeds = []float64{1, 2, 10}
+
+// Upper bounds on the recorded data loaded for a replay
+const (
+	ReplayMaxPoints = 100000
+	ReplayMaxEvents = 10000
+)
+
+// replayFrame is a recorded message of a drive, at its offset from the start
+type replayFrame struct {
+	offset time.Duration
+	point  int                 // Index of the track point of a stream_data frame, -1 for events
+	event  *models.StreamEvent // Event replayed as its own message type
+}
+
+// replayInput is a control received from the viewer, or an error to send back instead
+type replayInput struct {
+	control models.ReplayControl
+	err     string
+}
+
+// Replay plays a finished drive back to a single viewer with the messages and timing of
+// the live stream. The viewer is not registered with the hub, so the replay owns its Send channel.
+type Replay struct {
+	StreamID string
+
+	startedAt time.Time
+	duration  time.Duration
+	base      models.StreamData // Last frame of the drive, for the fields track points don't record
+	points    []models.TrackPoint
+	distances []float64 // Distance driven up to each point, in km
+	maxSpeeds []float64 // Max speed up to each point
+	frames    []replayFrame
+
+	client   *Client
+	controls chan replayInput
+	done     chan struct{} // Closed when the viewer disconnects
+
+	// Playback state, owned by the play goroutine
+	position time.Duration
+	next     int // Index of the next frame to send
+	speed    float64
+	paused   bool
+}
+
+// LoadReplay loads the recorded track and events of an ended stream for playback
+func (h *Hub) LoadReplay(ctx context.Context, stream *models.Stream) (*Replay, error) {
+	points, err := h.Store.TrackPoints().List(ctx, store.TrackQuery{StreamID: stream.StreamID, Limit: ReplayMaxPoints})
+	if err != nil {
+		return nil, err
+	}
+
+	events, err := h.Store.StreamEvents().List(ctx, stream.StreamID, ReplayMaxEvents)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Replay{
+		StreamID: stream.StreamID,
+		points:   points,
+		controls: make(chan replayInput, 8),
+		done:     make(chan struct{}),
+		speed:    1,
+	}
+
+	if stream.LatestData != nil {
+		r.base = *stream.LatestData
+		r.base.Progress = nil // Computed live, it would be wrong at any other point of the drive
+	}
+
+	if len(points) > 0 {
+		r.startedAt = points[0].Timestamp
+	} else if len(events) > 0 {
+		r.startedAt = events[0].Timestamp
+	}
+
+	r.distances = make([]float64, len(points))
+	r.maxSpeeds = make([]float64, len(points))
+	for i, point := range points {
+		r.maxSpeeds[i] = point.SpeedKmh
+		if i > 0 {
+			_, distanceKm, _ := tripSegment(points[i-1], point)
+			r.distances[i] = r.distances[i-1] + distanceKm
+			r.maxSpeeds[i] = max(r.maxSpeeds[i-1], point.SpeedKmh)
+		}
+		r.frames = append(r.frames, replayFrame{offset: point.Timestamp.Sub(r.startedAt), point: i})
+	}
+	for i := range events {
+		offset := max(events[i].Timestamp.Sub(r.startedAt), 0)
+		r.frames = append(r.frames, replayFrame{offset: offset, point: -1, event: &events[i]})
+	}
+
+	// Stable, so an event follows the frame that triggered it
+	sort.SliceStable(r.frames, func(i, j int) bool {
+		return r.frames[i].offset < r.frames[j].offset
+	})
+	if len(r.frames) > 0 {
+		r.duration = r.frames[len(r.frames)-1].offset
+	}
+
+	return r, nil
+}
+
+// Start plays the drive to the viewer's connection and reads its controls
+func (r *Replay) Start(c *Client) {
+	r.client = c
+	c.logger().Info("Replay started", "frames", len(r.frames))
+
+	go c.WritePump()
+	go r.play()
+	go r.readControls()
+}
+
+// readControls reads replay_control messages until the viewer disconnects
+func (r *Replay) readControls() {
+	c := r.client
+	h := c.Hub
+	defer func() {
+		close(r.done)
+		c.Conn.Close()
+		c.logger().Info("Replay ended")
+	}()
+
+	c.Conn.SetReadLimit(h.MaxMessageBytes)
+	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	c.Conn.SetPongHandler(func(string) error {
+		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		return nil
+	})
+
+	limiter := h.newMessageLimiter()
+
+	for {
+		_, message, err := c.Conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+				c.logger().Warn("WebSocket error", "error", err)
+			}
+			return
+		}
+
+		allowed, abusive := h.allowMessage(c, limiter)
+		if abusive {
+			closeAbusive(c)
+			return
+		}
+		if !allowed {
+			continue
+		}
+
+		message, err = c.decode(message)
+		if err != nil {
+			r.input(replayInput{err: "Invalid message format"})
+			continue
+		}
+
+		var wsMessage struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := json.Unmarshal(message, &wsMessage); err != nil {
+			r.input(replayInput{err: "Invalid message format"})
+			continue
+		}
+		if wsMessage.Type != "replay_control" {
+			continue
+		}
+
+		var control models.ReplayControl
+		if err := json.Unmarshal(wsMessage.Payload, &control); err != nil {
+			r.input(replayInput{err: "Invalid replay_control payload"})
+			continue
+		}
+		r.input(replayInput{control: control, err: validateReplayControl(control)})
+	}
+}
+
+// validateReplayControl returns why a control can't be applied, or an empty string
+func validateReplayControl(control models.ReplayControl) string {
+	switch control.Action {
+	case models.ReplayActionPlay, models.ReplayActionPause:
+		return ""
+	case models.ReplayActionSeek:
+		if control.PositionSeconds < 0 {
+			return "Invalid replay_control payload: positionSeconds must not be negative"
+		}
+		return ""
+	case models.ReplayActionSpeed:
+		if !slices.Contains(ReplaySpeeds, control.Speed) {
+			return "Invalid replay_control payload: speed must be 1, 2 or 10"
+		}
+		return ""
+	default:
+		return "Invalid replay_control payload: unknown action"
+	}
+}
+
+// input hands a control to the play goroutine
+func (r *Replay) input(in replayInput) {
+	select {
+	case r.controls <- in:
+	case <-r.done:
+	}
+}
+
+// play sends the frames at their original pace, scaled by the playback speed, and applies
+// the viewer's controls. It is the only goroutine sending to the viewer.
+func (r *Replay) play() {
+	defer close(r.client.Send)
+
+	r.sendState()
+	r.sendSnapshot()
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+
+	for {
+		var wake <-chan time.Time
+		resumed := time.Now()
+		if !r.paused && r.next < len(r.frames) {
+			timer.Reset(time.Duration(float64(r.frames[r.next].offset-r.position) / r.speed))
+			wake = timer.C
+		}
+
+		select {
+		case <-r.done:
+			timer.Stop()
+			return
+		case <-wake:
+			frame := r.frames[r.next]
+			r.position = frame.offset
+			r.next++
+			r.sendFrame(frame)
+
+			if r.next == len(r.frames) {
+				r.sendState()
+			}
+		case in := <-r.controls:
+			if wake != nil {
+				timer.Stop()
+				elapsed := time.Duration(float64(time.Since(resumed)) * r.speed)
+				r.position = min(r.position+elapsed, r.frames[r.next].offset)
+			}
+			r.apply(in)
+		}
+	}
+}
+
+// apply changes the playback state and reports the new state to the viewer
+func (r *Replay) apply(in replayInput) {
+	if in.err != "" {
+		r.send("error", models.ErrorMessage{Message: in.err})
+		return
+	}
+
+	switch in.control.Action {
+	case models.ReplayActionPlay:
+		// Playing a finished replay starts it over
+		if r.next == len(r.frames) {
+			r.seek(0)
+		}
+		r.paused = false
+	case models.ReplayActionPause:
+		r.paused = true
+	case models.ReplayActionSeek:
+		r.seek(time.Duration(in.control.PositionSeconds * float64(time.Second)))
+	case models.ReplayActionSpeed:
+		r.speed = in.control.Speed
+	}
+
+	r.sendState()
+}
+
+// seek moves the playback to position and sends a snapshot of the drive up to there
+func (r *Replay) seek(position time.Duration) {
+	r.position = min(max(position, 0), r.duration)
+	r.next = sort.Search(len(r.frames), func(i int) bool {
+		return r.frames[i].offset > r.position
+	})
+	r.sendSnapshot()
+}
+
+// sendSnapshot sends the drive up to the current position, as a live viewer joining then would get it
+func (r *Replay) sendSnapshot() {
+	snapshot := models.StreamSnapshot{
+		StreamID:             r.StreamID,
+		RecentTrack:          []models.TrackPoint{},
+		BroadcasterConnected: true, // The recording plays like a live broadcast
+	}
+
+	latest := -1
+	for _, frame := range r.frames[:r.next] {
+		if frame.point >= 0 {
+			latest = frame.point
+		}
+	}
+	if latest >= 0 {
+		data, err := json.Marshal(r.streamData(latest))
+		if err != nil {
+			r.client.logger().Error("Error marshaling replay frame", "error", err)
+			return
+		}
+		snapshot.LatestData = data
+		snapshot.RecentTrack = r.points[max(latest+1-RecentTrackLimit, 0) : latest+1]
+	}
+
+	r.send("snapshot", snapshot)
+}
+
+// sendFrame sends a recorded frame as the message the live stream sent
+func (r *Replay) sendFrame(frame replayFrame) {
+	if frame.point >= 0 {
+		r.send("stream_data", r.streamData(frame.point))
+		return
+	}
+	r.send(frame.event.Type, frame.event)
+}
+
+// streamData rebuilds the stream_data payload of a track point from the drive's last frame
+func (r *Replay) streamData(i int) models.StreamData {
+	point := r.points[i]
+
+	data := r.base
+	data.CurrentLocation = models.CurrentLocation{Latitude: point.Latitude, Longitude: point.Longitude}
+	data.CurrentSpeedKmh = point.SpeedKmh
+	data.IsPaused = point.IsPaused
+	data.Duration = point.Timestamp.Sub(r.startedAt).Seconds()
+	data.DistanceKm = r.distances[i]
+	data.MaxSpeedKmh = r.maxSpeeds[i]
+	return data
+}
+
+func (r *Replay) sendState() {
+	r.send("replay_state", models.ReplayState{
+		StreamID:        r.StreamID,
+		StartedAt:       r.startedAt,
+		DurationSeconds: r.duration.Seconds(),
+		PositionSeconds: r.position.Seconds(),
+		Speed:           r.speed,
+		Paused:          r.paused,
+		Finished:        r.next == len(r.frames),
+	})
+}
+
+// send queues a message for the viewer, waiting for room in its buffer rather than
+// dropping frames, as a replay has no live edge to keep up with
+func (r *Replay) send(messageType string, payload interface{}) {
+	data, err := json.Marshal(models.WebSocketMessage{Type: messageType, Payload: payload})
+	if err != nil {
+		r.client.logger().Error("Error marshaling replay message", "type", messageType, "error", err)
+		return
+	}
+
+	data, err = newEncodedMessage(data).forClient(r.client)
+	if err != nil {
+		r.client.logger().Error("Error encoding message for client", "error", err)
+		return
+	}
+
+	select {
+	case r.client.Send <- data:
+	case <-r.done:
+	}
+}
@@ -0,0 +1,164 @@
+package hub
+
+import (
+	"time"
+
+	"velocity-be/geo"
+	"velocity-be/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Settings for the server-computed ETA
+const (
+	ETASpeedWindow      = 5 * time.Minute // How far back speed samples are averaged
+	MinETASpeedKmh      = 1.0             // Below this average speed no ETA is given
+	maxRouteSpeedSample = 1000            // Upper bound on buffered speed samples per stream
+)
+
+type speedSample struct {
+	timestamp time.Time
+	speedKmh  float64
+}
+
+// routeTracker keeps the recent speed samples of a stream used to estimate its arrival time,
+// and whether it has strayed from its navigation route
+type routeTracker struct {
+	speeds        []speedSample
+	offRoute      bool      // off_route was sent and back_on_route not yet
+	deviatedSince time.Time // First frame of the current deviation, zero while on route
+}
+
+// averageSpeed records a new sample and returns the mean speed over ETASpeedWindow.
+// Paused frames are left out so stops don't drag the estimate down.
+func (t *routeTracker) averageSpeed(timestamp time.Time, speedKmh float64, isPaused bool) float64 {
+	if !isPaused {
+		t.speeds = append(t.speeds, speedSample{timestamp: timestamp, speedKmh: speedKmh})
+	}
+
+	cutoff := timestamp.Add(-ETASpeedWindow)
+	start := 0
+	for start < len(t.speeds) && (t.speeds[start].timestamp.Before(cutoff) || len(t.speeds)-start > maxRouteSpeedSample) {
+		start++
+	}
+	t.speeds = t.speeds[start:]
+
+	if len(t.speeds) == 0 {
+		return 0
+	}
+
+	total := 0.0
+	for _, sample := range t.speeds {
+		total += sample.speedKmh
+	}
+	return total / float64(len(t.speeds))
+}
+
+// forgetRoute drops the route state of a closed stream
+func (h *Hub) forgetRoute(streamID string) {
+	h.routesMu.Lock()
+	defer h.routesMu.Unlock()
+
+	delete(h.routes, streamID)
+}
+
+// computeProgress snaps the current location to the navigation polyline and estimates
+// the remaining distance and arrival time. Without a polyline the straight-line distance
+// to the destination is used. Returns nil when there's nothing to measure against.
+func (h *Hub) computeProgress(streamID string, data models.StreamData, timestamp time.Time) *models.RouteProgress {
+	latitude := data.CurrentLocation.Latitude
+	longitude := data.CurrentLocation.Longitude
+	if latitude == 0 && longitude == 0 {
+		return nil // No GPS fix
+	}
+
+	var progress models.RouteProgress
+
+	var snap geo.Snap
+	snapped := false
+	if data.NavigationData != nil {
+		snap, snapped = geo.SnapToPolyline(data.NavigationData.Polyline, latitude, longitude)
+	}
+
+	switch {
+	case snapped:
+		progress.Method = "route"
+		progress.RemainingDistanceKm = snap.RemainingMeters() / 1000
+		progress.SnappedLatitude = snap.Latitude
+		progress.SnappedLongitude = snap.Longitude
+		progress.DistanceFromRouteMeters = snap.DistanceMeters
+	case data.DestinationLatitude != 0 || data.DestinationLongitude != 0:
+		progress.Method = "straight_line"
+		progress.RemainingDistanceKm = geo.DistanceMeters(latitude, longitude, data.DestinationLatitude, data.DestinationLongitude) / 1000
+	default:
+		return nil
+	}
+
+	h.routesMu.Lock()
+	tracker, exists := h.routes[streamID]
+	if !exists {
+		tracker = &routeTracker{}
+		h.routes[streamID] = tracker
+	}
+	progress.AverageSpeedKmh = tracker.averageSpeed(timestamp, data.CurrentSpeedKmh, data.IsPaused)
+	h.routesMu.Unlock()
+
+	if progress.AverageSpeedKmh >= MinETASpeedKmh {
+		etaSeconds := progress.RemainingDistanceKm / progress.AverageSpeedKmh * 3600
+		arrival := timestamp.Add(time.Duration(etaSeconds * float64(time.Second)))
+		progress.EtaSeconds = &etaSeconds
+		progress.EstimatedArrival = &arrival
+	}
+
+	return &progress
+}
+
+// evaluateOffRoute compares the distance from the route against OffRouteDistanceMeters and
+// returns an off_route event once the deviation has lasted OffRouteDuration, or a
+// back_on_route event when a stream reported off route is within the distance again
+func (h *Hub) evaluateOffRoute(streamID string, data models.StreamData, progress *models.RouteProgress, timestamp time.Time) []models.StreamEvent {
+	h.routesMu.Lock()
+	defer h.routesMu.Unlock()
+
+	tracker, exists := h.routes[streamID]
+	if !exists {
+		return nil
+	}
+
+	if progress == nil || progress.Method != "route" {
+		// Navigation ended, there's no route to be off anymore
+		tracker.offRoute = false
+		tracker.deviatedSince = time.Time{}
+		return nil
+	}
+
+	newEvent := func(eventType string) []models.StreamEvent {
+		return []models.StreamEvent{{
+			ID:                      primitive.NewObjectID(),
+			StreamID:                streamID,
+			Type:                    eventType,
+			Timestamp:               timestamp,
+			Latitude:                data.CurrentLocation.Latitude,
+			Longitude:               data.CurrentLocation.Longitude,
+			DistanceFromRouteMeters: progress.DistanceFromRouteMeters,
+		}}
+	}
+
+	if progress.DistanceFromRouteMeters <= h.OffRouteDistanceMeters {
+		tracker.deviatedSince = time.Time{}
+		if tracker.offRoute {
+			tracker.offRoute = false
+			return newEvent("back_on_route")
+		}
+		return nil
+	}
+
+	if tracker.deviatedSince.IsZero() {
+		tracker.deviatedSince = timestamp
+	}
+	if !tracker.offRoute && timestamp.Sub(tracker.deviatedSince) >= h.OffRouteDuration {
+		tracker.offRoute = true
+		return newEvent("off_route")
+	}
+	return nil
+}
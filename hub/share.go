@@ -0,0 +1,38 @@
+package hub
+
+import (
+	"time"
+
+	"velocity-be/models"
+)
+
+// RevokeShareToken disconnects the viewers that joined with a share token, on this and every other instance
+func (h *Hub) RevokeShareToken(streamID, tokenID string) {
+	h.revokeShareToken(streamID, tokenID)
+	h.publish(eventRevokeShareToken, streamID, []byte(tokenID))
+}
+
+// revokeShareToken disconnects the viewers connected to this instance with a share token
+func (h *Hub) revokeShareToken(streamID, tokenID string) {
+	h.disconnectShareViewers(streamID, tokenID, "access_revoked", func(c *Client) bool {
+		return c.ShareTokenID == tokenID
+	})
+}
+
+// scheduleShareTokenExpiry disconnects a viewer once the share token it joined with expires
+func (h *Hub) scheduleShareTokenExpiry(client *Client) {
+	time.AfterFunc(time.Until(client.ShareTokenExpiresAt), func() {
+		h.disconnectShareViewers(client.StreamID, client.ShareTokenID, "access_expired", func(c *Client) bool {
+			return c == client
+		})
+	})
+}
+
+// disconnectShareViewers tells the matching viewers of a stream why their share access ended and closes them
+func (h *Hub) disconnectShareViewers(streamID, tokenID, messageType string, match func(c *Client) bool) {
+	payload := models.ShareAccessEnded{
+		StreamID:     streamID,
+		ShareTokenID: tokenID,
+	}
+	h.disconnectViewers(streamID, messageType, payload, "", match)
+}
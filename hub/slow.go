@@ -0,0 +1,85 @@
+package hub
+
+import (
+	"encoding/json"
+	"time"
+
+	"velocity-be/models"
+
+	"github.com/gorilla/websocket"
+)
+
+// DefaultMaxConsecutiveDrops is how many messages in a row a viewer may miss before it is
+// evicted by default, about 10 seconds of stream_data at the usual frame rate
+const DefaultMaxConsecutiveDrops = 100
+
+// recordDrop counts a message dropped because the client's send buffer was full, and
+// evicts viewers that stay behind for more than MaxConsecutiveDrops messages
+func (c *Client) recordDrop() {
+	c.recordDroppedSend()
+
+	dropped := c.consecutiveDrops.Add(1)
+	if c.IsMobile || c.Hub == nil || c.Hub.MaxConsecutiveDrops <= 0 || dropped <= c.Hub.MaxConsecutiveDrops {
+		return
+	}
+
+	// Callers may hold the stream hub's lock, which unregistering needs
+	if c.evicted.CompareAndSwap(false, true) {
+		go c.Hub.evictSlowConsumer(c)
+	}
+}
+
+// recordDelivery resets the client's drop count after a message was queued, telling the
+// client how many messages it missed if it was lagging
+func (c *Client) recordDelivery() {
+	if dropped := c.consecutiveDrops.Swap(0); dropped > 0 {
+		c.sendLagging(dropped)
+	}
+}
+
+// lagging reports whether the client missed the last messages queued for it
+func (c *Client) lagging() bool {
+	return c.consecutiveDrops.Load() > 0
+}
+
+// sendLagging queues a lagging notice if there is room, without counting it as a drop otherwise
+func (c *Client) sendLagging(dropped int64) {
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type: "lagging",
+		Payload: models.LaggingNotice{
+			StreamID:        c.StreamID,
+			DroppedMessages: dropped,
+		},
+	})
+	if err != nil {
+		c.logger().Error("Error marshaling lagging message", "error", err)
+		return
+	}
+
+	data, err = newEncodedMessage(data).forClient(c)
+	if err != nil {
+		c.logger().Error("Error encoding lagging message for client", "error", err)
+		return
+	}
+
+	select {
+	case c.Send <- data:
+	default:
+	}
+}
+
+// evictSlowConsumer disconnects a viewer that stopped keeping up with the stream,
+// recording the reason in its join log
+func (h *Hub) evictSlowConsumer(c *Client) {
+	c.logger().Warn("Evicting slow viewer", "droppedMessages", c.consecutiveDrops.Load())
+
+	c.leaveReason.Store(models.LeaveReasonSlowConsumer)
+
+	// The close frame skips the backlog still queued for the viewer
+	if c.Conn != nil {
+		message := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "too slow to keep up with the stream")
+		c.Conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	}
+
+	h.Unregister <- c
+}
@@ -0,0 +1,62 @@
+package hub
+
+import (
+	"encoding/json"
+
+	"velocity-be/models"
+)
+
+// RecentTrackLimit is the number of track points kept in memory per stream for late joiners
+const RecentTrackLimit = 500
+
+// recordFrame remembers the latest stream_data payload and track point of a stream
+// so they can be replayed to viewers that join later. It returns the previous payload.
+func (h *Hub) recordFrame(streamID string, payload json.RawMessage, point *models.TrackPoint) json.RawMessage {
+	h.mu.RLock()
+	streamHub, exists := h.Streams[streamID]
+	h.mu.RUnlock()
+
+	if !exists {
+		return nil
+	}
+
+	streamHub.mu.Lock()
+	defer streamHub.mu.Unlock()
+
+	previous := streamHub.LatestData
+	streamHub.LatestData = payload
+
+	if point != nil {
+		streamHub.RecentTrack = append(streamHub.RecentTrack, *point)
+		if overflow := len(streamHub.RecentTrack) - RecentTrackLimit; overflow > 0 {
+			// Copy into a fresh slice so the dropped points can be garbage collected
+			streamHub.RecentTrack = append([]models.TrackPoint(nil), streamHub.RecentTrack[overflow:]...)
+		}
+	}
+	return previous
+}
+
+// sendSnapshot sends the current state of a stream to a newly registered viewer
+func (h *Hub) sendSnapshot(streamHub *StreamHub, client *Client) {
+	streamHub.mu.RLock()
+	snapshot := models.StreamSnapshot{
+		StreamID:             streamHub.StreamID,
+		LatestData:           streamHub.LatestData,
+		RecentTrack:          append([]models.TrackPoint{}, streamHub.RecentTrack...),
+		BroadcasterConnected: streamHub.Broadcaster != nil,
+	}
+	streamHub.mu.RUnlock()
+
+	data, err := json.Marshal(models.WebSocketMessage{
+		Type:    "snapshot",
+		Payload: snapshot,
+	})
+	if err != nil {
+		client.logger().Error("Error marshaling snapshot", "error", err)
+		return
+	}
+
+	if !client.queue(newEncodedMessage(data)) {
+		client.logger().Warn("Failed to send snapshot to viewer")
+	}
+}
@@ -0,0 +1,153 @@
+package hub
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"velocity-be/geo"
+	"velocity-be/models"
+	"velocity-be/store"
+)
+
+// TripGapThreshold is how long without frames counts as a pause in the trip summary,
+// e.g. while the broadcaster was disconnected, rather than as time spent moving
+const TripGapThreshold = 5 * time.Minute
+
+// SaveTripSummary computes the summary of a stream that has just been marked deleted and
+// stores it on the stream. Call it before CloseStream, so the frames and track points not
+// yet written to the store are flushed first.
+func (h *Hub) SaveTripSummary(ctx context.Context, streamID string) (*models.TripSummary, error) {
+	h.flushPendingWrites(streamID)
+
+	stream, err := h.Store.Streams().Get(ctx, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	points, err := h.Store.TrackPoints().List(ctx, store.TrackQuery{StreamID: streamID})
+	if err != nil {
+		return nil, err
+	}
+
+	joinLogs, err := h.Store.JoinLogs().List(ctx, streamID)
+	if err != nil {
+		return nil, err
+	}
+
+	endedAt := time.Now()
+	if stream.DeletedAt != nil {
+		endedAt = *stream.DeletedAt
+	}
+
+	summary := summarizeTrip(streamID, points, stream.LatestData, joinLogs, endedAt)
+	summary.AutoCancelled = stream.AutoCancelled
+
+	if err := h.Store.Streams().SaveSummary(ctx, streamID, summary); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// summarizeTrip measures a drive from its track points, oldest first, and the last frame sent by the app
+func summarizeTrip(streamID string, points []models.TrackPoint, latest *models.StreamData, joinLogs []models.StreamJoinLog, endedAt time.Time) models.TripSummary {
+	summary := models.TripSummary{
+		StreamID: streamID,
+		EndedAt:  endedAt,
+	}
+
+	for i, point := range points {
+		summary.MaxSpeedKmh = max(summary.MaxSpeedKmh, point.SpeedKmh)
+		if i == 0 {
+			startedAt := point.Timestamp
+			summary.StartedAt = &startedAt
+			continue
+		}
+
+		elapsed, distanceKm, moving := tripSegment(points[i-1], point)
+		if !moving {
+			summary.PausedSeconds += elapsed.Seconds()
+			continue
+		}
+
+		summary.MovingSeconds += elapsed.Seconds()
+		summary.DistanceKm += distanceKm
+	}
+
+	if latest != nil {
+		// The app measures from every GPS fix, not only the frames it sent
+		if latest.DistanceKm > 0 {
+			summary.DistanceKm = latest.DistanceKm
+		}
+		summary.MaxSpeedKmh = max(summary.MaxSpeedKmh, latest.MaxSpeedKmh)
+
+		summary.StartAddress = tripAddress(latest.StartAddressLine, latest.StartPostalCode, latest.StartCity)
+		summary.EndAddress = tripAddress(latest.EndAddressLine, latest.EndPostalCode, latest.EndCity)
+	}
+
+	if summary.MovingSeconds > 0 {
+		summary.AverageSpeedKmh = summary.DistanceKm / (summary.MovingSeconds / 3600)
+	}
+
+	summary.PeakViewerCount, summary.TotalViewers = summarizeViewers(joinLogs, endedAt)
+	return summary
+}
+
+// tripSegment measures the time and distance between two consecutive track points, and
+// whether the vehicle was moving. Distance is only counted while moving.
+func tripSegment(previous, point models.TrackPoint) (elapsed time.Duration, distanceKm float64, moving bool) {
+	elapsed = point.Timestamp.Sub(previous.Timestamp)
+	if previous.IsPaused || elapsed > TripGapThreshold {
+		return elapsed, 0, false
+	}
+	return elapsed, geo.DistanceMeters(previous.Latitude, previous.Longitude, point.Latitude, point.Longitude) / 1000, true
+}
+
+// tripAddress returns nil if the app did not report the address
+func tripAddress(addressLine, postalCode, city string) *models.TripAddress {
+	if addressLine == "" && postalCode == "" && city == "" {
+		return nil
+	}
+	return &models.TripAddress{AddressLine: addressLine, PostalCode: postalCode, City: city}
+}
+
+type viewerChange struct {
+	at    time.Time
+	delta int
+}
+
+// summarizeViewers returns the most viewers watching at once, and the number of distinct
+// viewers by IP address and user agent. Viewers still connected count until endedAt.
+func summarizeViewers(joinLogs []models.StreamJoinLog, endedAt time.Time) (peak, total int) {
+	changes := make([]viewerChange, 0, 2*len(joinLogs))
+	distinct := make(map[string]bool)
+
+	for _, joinLog := range joinLogs {
+		identity := joinLog.IPAddress + "|" + joinLog.UserAgent
+		if joinLog.IPAddress == "" && joinLog.UserAgent == "" {
+			identity = joinLog.ID.Hex()
+		}
+		distinct[identity] = true
+
+		leftAt := endedAt
+		if joinLog.LeftAt != nil && joinLog.LeftAt.Before(endedAt) {
+			leftAt = *joinLog.LeftAt
+		}
+		changes = append(changes, viewerChange{at: joinLog.JoinedAt, delta: 1}, viewerChange{at: leftAt, delta: -1})
+	}
+
+	// Leaves sort before joins at the same instant, so a reconnecting viewer isn't counted twice
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].at.Equal(changes[j].at) {
+			return changes[i].delta < changes[j].delta
+		}
+		return changes[i].at.Before(changes[j].at)
+	})
+
+	watching := 0
+	for _, change := range changes {
+		watching += change.delta
+		peak = max(peak, watching)
+	}
+	return peak, len(distinct)
+}
@@ -0,0 +1,100 @@
+// Package logging configures structured logging with log/slog and correlates the
+// log records of an HTTP request through a request ID.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// RequestIDHeader carries the request ID, accepted from clients and proxies or generated
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs accepted from clients
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// Setup makes a handler writing to stderr the default logger, in "json" or text format,
+// at the given level ("debug", "info", "warn" or "error"). The standard log package
+// is redirected to it too.
+func Setup(format, level string) {
+	options := &slog.HandlerOptions{Level: parseLevel(level)}
+
+	var handler slog.Handler
+	if strings.EqualFold(format, "json") {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+func parseLevel(level string) slog.Level {
+	var parsed slog.Level
+	if err := parsed.UnmarshalText([]byte(level)); err != nil {
+		return slog.LevelInfo
+	}
+	return parsed
+}
+
+// Middleware assigns each request an ID, echoed in the X-Request-ID response header
+// and available through RequestID, and logs the request once it is handled
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" || len(requestID) > maxRequestIDLength {
+			requestID = uuid.New().String()
+		}
+		c.Header(RequestIDHeader, requestID)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), requestIDKey{}, requestID))
+
+		start := time.Now()
+		c.Next()
+
+		attrs := []any{
+			"requestId", requestID,
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"clientIp", c.ClientIP(),
+		}
+		if streamID := c.Param("streamId"); streamID != "" {
+			attrs = append(attrs, "streamId", streamID)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+
+		level := slog.LevelInfo
+		if c.Writer.Status() >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(c.Request.Context(), level, "HTTP request", attrs...)
+	}
+}
+
+// RequestID returns the ID assigned to the request by Middleware, or an empty string
+func RequestID(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// FromRequest returns a logger carrying the request's ID and stream ID, if any
+func FromRequest(c *gin.Context) *slog.Logger {
+	logger := slog.Default()
+	if requestID := RequestID(c.Request.Context()); requestID != "" {
+		logger = logger.With("requestId", requestID)
+	}
+	if streamID := c.Param("streamId"); streamID != "" {
+		logger = logger.With("streamId", streamID)
+	}
+	return logger
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -11,62 +11,159 @@ import (
 	"velocity-be/db"
 	"velocity-be/handlers"
 	"velocity-be/hub"
+	"velocity-be/logging"
+	"velocity-be/metrics"
+	"velocity-be/pubsub"
+	"velocity-be/store"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 func main() {
 	// Load configuration
 	config.Load()
 
+	// Structured logging, as JSON in production log pipelines
+	logging.Setup(config.AppConfig.LogFormat, config.AppConfig.LogLevel)
+
 	// Set Gin mode
 	gin.SetMode(config.AppConfig.GinMode)
 
-	// Connect to MongoDB
-	if err := db.Connect(); err != nil {
-		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	// Connect to MongoDB if a backend needs it
+	if config.AppConfig.StorageBackend == "mongodb" || config.AppConfig.PubSubBackend == "mongodb" {
+		if err := db.Connect(); err != nil {
+			fatal("Failed to connect to MongoDB", err)
+		}
+		defer db.Disconnect()
+	}
+
+	// Create storage backend for streams, logs and feature flags
+	var dataStore store.Store
+	switch config.AppConfig.StorageBackend {
+	case "mongodb":
+		dataStore = store.NewMongoDB()
+	case "memory":
+		dataStore = store.NewMemory()
+	case "bolt":
+		boltStore, err := store.NewBolt(config.AppConfig.StoragePath)
+		if err != nil {
+			fatal("Failed to open BoltDB file", err, "path", config.AppConfig.StoragePath)
+		}
+		dataStore = boltStore
+	default:
+		fatal("Unknown storage backend", nil, "backend", config.AppConfig.StorageBackend)
+	}
+	defer dataStore.Close()
+
+	// Record write latencies and errors
+	dataStore = metrics.InstrumentStore(dataStore)
+
+	// Create pub/sub backend for fanning out broadcasts between instances
+	var bus pubsub.PubSub
+	switch config.AppConfig.PubSubBackend {
+	case "memory":
+		bus = pubsub.NewMemory()
+	case "mongodb":
+		bus = pubsub.NewMongoDB(db.HubEventsCollection())
+	default:
+		fatal("Unknown pub/sub backend", nil, "backend", config.AppConfig.PubSubBackend)
+	}
+	defer bus.Close()
+
+	// Share tokens signed with a random key are lost on restart and rejected by other instances
+	if config.AppConfig.ShareTokenSecret != "" {
+		handlers.SetShareTokenSecret(config.AppConfig.ShareTokenSecret)
+	} else {
+		slog.Warn("SHARE_TOKEN_SECRET not set, share tokens will only be valid until this instance restarts")
 	}
-	defer db.Disconnect()
 
 	// Create WebSocket hub
-	wsHub := hub.NewHub()
+	wsHub := hub.NewHubWithPubSub(dataStore, bus)
+	wsHub.BroadcasterGracePeriod = config.AppConfig.BroadcasterGracePeriod
+	wsHub.ViewerCountHeartbeat = config.AppConfig.ViewerCountHeartbeat
+	wsHub.OffRouteDistanceMeters = config.AppConfig.OffRouteDistanceMeters
+	wsHub.OffRouteDuration = config.AppConfig.OffRouteDuration
+	wsHub.MaxMessageBytes = config.AppConfig.WSMaxMessageBytes
+	wsHub.MessageRate = config.AppConfig.WSMessageRate
+	wsHub.MessageBurst = config.AppConfig.WSMessageBurst
+	wsHub.MaxRateLimitedMessages = config.AppConfig.WSMaxRateLimitedMessages
+	wsHub.StreamDataWritesPerSecond = config.AppConfig.StreamDataWritesPerSecond
+	wsHub.MaxConsecutiveDrops = int64(config.AppConfig.ViewerMaxConsecutiveDrops)
 	go wsHub.Run()
 
+	// Export connection gauges and broadcast counters
+	prometheus.MustRegister(wsHub)
+
 	// Start inactive stream cleanup job
 	cleanupCtx, cleanupCancel := context.WithCancel(context.Background())
 	defer cleanupCancel()
 	go wsHub.StartInactiveStreamCleanup(cleanupCtx)
 
 	// Setup router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery())
+
+	// Request IDs and access logs
+	router.Use(logging.Middleware())
+
+	// Client IPs, used by the creation quotas, are only read from X-Forwarded-For behind trusted proxies
+	if err := router.SetTrustedProxies(config.AppConfig.TrustedProxies); err != nil {
+		fatal("Invalid TRUSTED_PROXIES", err)
+	}
 
 	// CORS middleware
 	router.Use(corsMiddleware())
 
+	// Request duration histograms
+	router.Use(metrics.Middleware())
+
 	// Health check
 	router.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{"status": "healthy"})
 	})
 
+	// Prometheus metrics
+	router.GET("/metrics", metrics.Handler())
+
+	// Abuse controls for the unauthenticated stream creation endpoint
+	creationLimits := handlers.CreationLimits{
+		PerIP:            handlers.Quota{Requests: config.AppConfig.StreamCreateIPQuota, Window: config.AppConfig.StreamCreateQuotaWindow},
+		PerToken:         handlers.Quota{Requests: config.AppConfig.StreamCreateTokenQuota, Window: config.AppConfig.StreamCreateQuotaWindow},
+		MaxActiveStreams: config.AppConfig.MaxActiveStreamsPerCreator,
+	}
+
 	// API routes
 	api := router.Group("/api")
 	{
 		// Stream management
-		api.POST("/streams", handlers.CreateStreamHandler)
-		api.GET("/streams/:streamId", handlers.GetStreamHandler)
-		api.DELETE("/streams/:streamId", handlers.DeleteStreamHandler(wsHub))
+		api.POST("/streams", handlers.StreamCreationLimits(dataStore, creationLimits), handlers.CreateStreamHandler(dataStore))
+		api.GET("/streams/:streamId", handlers.GetStreamHandler(dataStore))
+		api.DELETE("/streams/:streamId", handlers.DeleteStreamHandler(dataStore, wsHub))
+		api.GET("/streams/:streamId/track", handlers.GetStreamTrackHandler(dataStore))
+		api.GET("/streams/:streamId/export", handlers.ExportStreamHandler(dataStore))
+		api.PUT("/streams/:streamId/geofences", handlers.UpdateGeofencesHandler(dataStore, wsHub))
+		api.GET("/streams/:streamId/event-log", handlers.GetStreamEventLogHandler(dataStore))
+		api.GET("/streams/:streamId/summary", handlers.GetStreamSummaryHandler(dataStore))
+		api.GET("/streams/:streamId/chat", handlers.GetChatHistoryHandler(dataStore))
+		api.GET("/streams/:streamId/events", handlers.ViewerEventsHandler(dataStore, wsHub))
+		api.POST("/streams/:streamId/share-tokens", handlers.CreateShareTokenHandler(dataStore))
+		api.GET("/streams/:streamId/share-tokens", handlers.ListShareTokensHandler(dataStore))
+		api.DELETE("/streams/:streamId/share-tokens/:tokenId", handlers.RevokeShareTokenHandler(dataStore, wsHub))
 
 		// Feature flags
-		api.GET("/feature-flags", handlers.GetFeatureFlagsHandler)
+		api.GET("/feature-flags", handlers.GetFeatureFlagsHandler(dataStore))
 	}
 
 	// WebSocket routes
 	ws := router.Group("/ws")
 	{
 		// Mobile app connects here to broadcast
-		ws.GET("/mobile/:streamId", handlers.MobileWebSocketHandler(wsHub))
+		ws.GET("/mobile/:streamId", handlers.MobileWebSocketHandler(dataStore, wsHub))
 		// Web viewers connect here to receive
-		ws.GET("/viewer/:streamId", handlers.ViewerWebSocketHandler(wsHub))
+		ws.GET("/viewer/:streamId", handlers.ViewerWebSocketHandler(dataStore, wsHub))
+		// Viewers replay finished drives here
+		ws.GET("/replay/:streamId", handlers.ReplayWebSocketHandler(dataStore, wsHub))
 	}
 
 	// Serve static frontend files in production
@@ -86,18 +183,29 @@ func main() {
 		quit := make(chan os.Signal, 1)
 		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 		<-quit
-		log.Println("Shutting down server...")
+		slog.Info("Shutting down server...")
 		cleanupCancel() // Stop the inactive stream cleanup job
+		bus.Close()
+		dataStore.Close()
 		db.Disconnect()
 		os.Exit(0)
 	}()
 
 	// Start server
 	port := config.AppConfig.Port
-	log.Printf("Server starting on port %s", port)
+	slog.Info("Server starting", "port", port)
 	if err := router.Run(":" + port); err != nil {
-		log.Fatalf("Failed to start server: %v", err)
+		fatal("Failed to start server", err)
+	}
+}
+
+// fatal logs an error that prevents the server from running and exits
+func fatal(msg string, err error, args ...any) {
+	if err != nil {
+		args = append(args, "error", err)
 	}
+	slog.Error(msg, args...)
+	os.Exit(1)
 }
 
 func corsMiddleware() gin.HandlerFunc {
@@ -118,7 +226,7 @@ func corsMiddleware() gin.HandlerFunc {
 		}
 
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Client-Token, X-Request-ID")
 		c.Header("Access-Control-Allow-Credentials", "true")
 
 		if c.Request.Method == "OPTIONS" {
@@ -0,0 +1,73 @@
+// Package metrics exposes Prometheus metrics for the HTTP API and storage backend.
+// Hub metrics are collected by the hub itself, see hub.Hub.Collect.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "velocity_http_request_duration_seconds",
+		Help:    "Duration of HTTP requests by route, method and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	dbWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "velocity_db_write_duration_seconds",
+		Help:    "Duration of storage backend writes by operation.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"operation"})
+
+	dbWriteErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "velocity_db_write_errors_total",
+		Help: "Failed storage backend writes by operation.",
+	}, []string{"operation"})
+)
+
+// Handler serves the metrics of the default Prometheus registry
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware records the duration of HTTP requests. WebSocket connections and
+// Server-Sent Events streams are skipped, as their duration is the session's.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.IsWebsocket() {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		c.Next()
+
+		if c.Writer.Header().Get("Content-Type") == "text/event-stream" {
+			return
+		}
+
+		// Unmatched routes are grouped to keep the number of series bounded
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		httpRequestDuration.
+			WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// observeWrite records the duration and outcome of a storage write
+func observeWrite(operation string, start time.Time, err error) {
+	dbWriteDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		dbWriteErrors.WithLabelValues(operation).Inc()
+	}
+}
@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// InstrumentStore wraps a Store to record the latency and errors of its writes.
+// Reads are passed through unchanged.
+func InstrumentStore(s store.Store) store.Store {
+	return instrumentedStore{s}
+}
+
+type instrumentedStore struct{ store.Store }
+
+func (s instrumentedStore) Streams() store.StreamRepository {
+	return instrumentedStreams{s.Store.Streams()}
+}
+
+func (s instrumentedStore) JoinLogs() store.JoinLogRepository {
+	return instrumentedJoinLogs{s.Store.JoinLogs()}
+}
+
+func (s instrumentedStore) FeatureFlags() store.FeatureFlagRepository {
+	return instrumentedFeatureFlags{s.Store.FeatureFlags()}
+}
+
+func (s instrumentedStore) TrackPoints() store.TrackPointRepository {
+	return instrumentedTrackPoints{s.Store.TrackPoints()}
+}
+
+func (s instrumentedStore) StreamEvents() store.StreamEventRepository {
+	return instrumentedStreamEvents{s.Store.StreamEvents()}
+}
+
+func (s instrumentedStore) ChatMessages() store.ChatMessageRepository {
+	return instrumentedChatMessages{s.Store.ChatMessages()}
+}
+
+type instrumentedStreams struct{ store.StreamRepository }
+
+func (r instrumentedStreams) Create(ctx context.Context, stream *models.Stream) error {
+	start := time.Now()
+	err := r.StreamRepository.Create(ctx, stream)
+	observeWrite("stream_create", start, err)
+	return err
+}
+
+func (r instrumentedStreams) MarkDeleted(ctx context.Context, streamID string, deletedAt time.Time, autoCancelled bool) error {
+	start := time.Now()
+	err := r.StreamRepository.MarkDeleted(ctx, streamID, deletedAt, autoCancelled)
+	observeWrite("stream_mark_deleted", start, err)
+	return err
+}
+
+func (r instrumentedStreams) UpdateLatestData(ctx context.Context, streamID string, data models.StreamData) error {
+	start := time.Now()
+	err := r.StreamRepository.UpdateLatestData(ctx, streamID, data)
+	observeWrite("stream_update_latest_data", start, err)
+	return err
+}
+
+func (r instrumentedStreams) UpdateLastConnection(ctx context.Context, streamID string, connectedAt time.Time) error {
+	start := time.Now()
+	err := r.StreamRepository.UpdateLastConnection(ctx, streamID, connectedAt)
+	observeWrite("stream_update_last_connection", start, err)
+	return err
+}
+
+func (r instrumentedStreams) UpdateGeofences(ctx context.Context, streamID string, fences []models.Geofence) error {
+	start := time.Now()
+	err := r.StreamRepository.UpdateGeofences(ctx, streamID, fences)
+	observeWrite("stream_update_geofences", start, err)
+	return err
+}
+
+func (r instrumentedStreams) AddShareToken(ctx context.Context, streamID string, token models.ShareToken) error {
+	start := time.Now()
+	err := r.StreamRepository.AddShareToken(ctx, streamID, token)
+	observeWrite("stream_add_share_token", start, err)
+	return err
+}
+
+func (r instrumentedStreams) AddBan(ctx context.Context, streamID string, ban models.ViewerBan) error {
+	start := time.Now()
+	err := r.StreamRepository.AddBan(ctx, streamID, ban)
+	observeWrite("stream_add_ban", start, err)
+	return err
+}
+
+func (r instrumentedStreams) AddMute(ctx context.Context, streamID string, mute models.ViewerMute) error {
+	start := time.Now()
+	err := r.StreamRepository.AddMute(ctx, streamID, mute)
+	observeWrite("stream_add_mute", start, err)
+	return err
+}
+
+func (r instrumentedStreams) RemoveMute(ctx context.Context, streamID, ipAddress string) error {
+	start := time.Now()
+	err := r.StreamRepository.RemoveMute(ctx, streamID, ipAddress)
+	observeWrite("stream_remove_mute", start, err)
+	return err
+}
+
+func (r instrumentedStreams) RevokeShareToken(ctx context.Context, streamID, tokenID string, revokedAt time.Time) error {
+	start := time.Now()
+	err := r.StreamRepository.RevokeShareToken(ctx, streamID, tokenID, revokedAt)
+	observeWrite("stream_revoke_share_token", start, err)
+	return err
+}
+
+func (r instrumentedStreams) SaveSummary(ctx context.Context, streamID string, summary models.TripSummary) error {
+	start := time.Now()
+	err := r.StreamRepository.SaveSummary(ctx, streamID, summary)
+	observeWrite("stream_save_summary", start, err)
+	return err
+}
+
+type instrumentedJoinLogs struct{ store.JoinLogRepository }
+
+func (r instrumentedJoinLogs) Create(ctx context.Context, joinLog *models.StreamJoinLog) error {
+	start := time.Now()
+	err := r.JoinLogRepository.Create(ctx, joinLog)
+	observeWrite("join_log_create", start, err)
+	return err
+}
+
+func (r instrumentedJoinLogs) MarkLeft(ctx context.Context, id primitive.ObjectID, leftAt time.Time, reason string) error {
+	start := time.Now()
+	err := r.JoinLogRepository.MarkLeft(ctx, id, leftAt, reason)
+	observeWrite("join_log_mark_left", start, err)
+	return err
+}
+
+type instrumentedFeatureFlags struct{ store.FeatureFlagRepository }
+
+func (r instrumentedFeatureFlags) Save(ctx context.Context, flags *models.FeatureFlags) error {
+	start := time.Now()
+	err := r.FeatureFlagRepository.Save(ctx, flags)
+	observeWrite("feature_flags_save", start, err)
+	return err
+}
+
+type instrumentedTrackPoints struct{ store.TrackPointRepository }
+
+func (r instrumentedTrackPoints) Insert(ctx context.Context, point models.TrackPoint) error {
+	start := time.Now()
+	err := r.TrackPointRepository.Insert(ctx, point)
+	observeWrite("track_point_insert", start, err)
+	return err
+}
+
+func (r instrumentedTrackPoints) InsertMany(ctx context.Context, points []models.TrackPoint) error {
+	start := time.Now()
+	err := r.TrackPointRepository.InsertMany(ctx, points)
+	observeWrite("track_point_insert_many", start, err)
+	return err
+}
+
+type instrumentedStreamEvents struct{ store.StreamEventRepository }
+
+func (r instrumentedStreamEvents) Insert(ctx context.Context, event models.StreamEvent) error {
+	start := time.Now()
+	err := r.StreamEventRepository.Insert(ctx, event)
+	observeWrite("stream_event_insert", start, err)
+	return err
+}
+
+type instrumentedChatMessages struct{ store.ChatMessageRepository }
+
+func (r instrumentedChatMessages) Insert(ctx context.Context, message models.ChatMessage) error {
+	start := time.Now()
+	err := r.ChatMessageRepository.Insert(ctx, message)
+	observeWrite("chat_message_insert", start, err)
+	return err
+}
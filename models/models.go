@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -54,20 +55,151 @@ type StreamData struct {
 	ExpectedDistanceKm     *float64        `json:"expectedDistanceKm,omitempty" bson:"expectedDistanceKm,omitempty"`
 	Car                    Car             `json:"car" bson:"car"`
 	IsPaused               bool            `json:"isPaused" bson:"isPaused"`
+	Progress               *RouteProgress  `json:"progress,omitempty" bson:"progress,omitempty"` // Computed by the server, ignored if sent by the app
+}
+
+// RouteProgress represents the server-computed remaining distance and ETA of a stream
+type RouteProgress struct {
+	Method                  string     `json:"method" bson:"method"`                                                       // "route" when snapped to NavigationData.Polyline, "straight_line" when measured to the destination
+	RemainingDistanceKm     float64    `json:"remainingDistanceKm" bson:"remainingDistanceKm"`                             // Distance left to the end of the route
+	AverageSpeedKmh         float64    `json:"averageSpeedKmh" bson:"averageSpeedKmh"`                                     // Recent average moving speed used for the ETA
+	EtaSeconds              *float64   `json:"etaSeconds,omitempty" bson:"etaSeconds,omitempty"`                           // Omitted until the vehicle has been moving
+	EstimatedArrival        *time.Time `json:"estimatedArrival,omitempty" bson:"estimatedArrival,omitempty"`               // Current time plus etaSeconds
+	SnappedLatitude         float64    `json:"snappedLatitude,omitempty" bson:"snappedLatitude,omitempty"`                 // Closest position on the route
+	SnappedLongitude        float64    `json:"snappedLongitude,omitempty" bson:"snappedLongitude,omitempty"`               // Closest position on the route
+	DistanceFromRouteMeters float64    `json:"distanceFromRouteMeters,omitempty" bson:"distanceFromRouteMeters,omitempty"` // Distance between the current location and the route
 }
 
 // Stream represents an active streaming session
 type Stream struct {
-	ID               primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	StreamID         string             `json:"streamId" bson:"streamId"`
-	CreatedAt        time.Time          `json:"createdAt" bson:"createdAt"`
-	UpdatedAt        time.Time          `json:"updatedAt" bson:"updatedAt"`
-	DeletedAt        *time.Time         `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
-	IsActive         bool               `json:"isActive" bson:"isActive"`
-	LatestData       *StreamData        `json:"latestData,omitempty" bson:"latestData,omitempty"`
-	ViewerCount      int                `json:"viewerCount" bson:"viewerCount"`
-	LastConnectionAt *time.Time         `json:"lastConnectionAt,omitempty" bson:"lastConnectionAt,omitempty"` // Tracks when the last client was connected
-	AutoCancelled    bool               `json:"autoCancelled" bson:"autoCancelled"`                           // True if stream was auto-cancelled due to inactivity
+	ID                   primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	StreamID             string             `json:"streamId" bson:"streamId"`
+	CreatedAt            time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt            time.Time          `json:"updatedAt" bson:"updatedAt"`
+	DeletedAt            *time.Time         `json:"deletedAt,omitempty" bson:"deletedAt,omitempty"`
+	IsActive             bool               `json:"isActive" bson:"isActive"`
+	LatestData           *StreamData        `json:"latestData,omitempty" bson:"latestData,omitempty"`
+	ViewerCount          int                `json:"viewerCount" bson:"viewerCount"`
+	LastConnectionAt     *time.Time         `json:"lastConnectionAt,omitempty" bson:"lastConnectionAt,omitempty"` // Tracks when the last client was connected
+	AutoCancelled        bool               `json:"autoCancelled" bson:"autoCancelled"`                           // True if stream was auto-cancelled due to inactivity
+	BroadcasterTokenHash string             `json:"-" bson:"broadcasterTokenHash"`                                // SHA-256 hash of the broadcaster token, never exposed
+	Geofences            []Geofence         `json:"geofences,omitempty" bson:"geofences,omitempty"`               // User-defined zones that trigger entered_zone/left_zone events
+	Visibility           string             `json:"visibility" bson:"visibility,omitempty"`                       // Who may watch the stream, empty for streams created before visibility existed (public)
+	PasscodeHash         string             `json:"-" bson:"passcodeHash,omitempty"`                              // bcrypt hash of the viewer passcode, never exposed
+	Invites              []StreamInvite     `json:"-" bson:"invites,omitempty"`                                   // Invite codes accepted for invite_list streams, never exposed
+	ShareTokens          []ShareToken       `json:"-" bson:"shareTokens,omitempty"`                               // Share links minted by the broadcaster, listed through their own endpoint
+	CreatorKey           string             `json:"-" bson:"creatorKey,omitempty"`                                // Hash of the IP address that created the stream, for the active stream cap
+	CreatorTokenKey      string             `json:"-" bson:"creatorTokenKey,omitempty"`                           // Hash of the client token that created the stream, if one was sent, for the active stream cap
+	Summary              *TripSummary       `json:"-" bson:"summary,omitempty"`                                   // Computed when the stream ends, served by its own endpoint as it includes history
+	Bans                 []ViewerBan        `json:"-" bson:"bans,omitempty"`                                      // Viewers the broadcaster banned, never exposed
+	Mutes                []ViewerMute       `json:"-" bson:"mutes,omitempty"`                                     // Viewers the broadcaster muted, never exposed
+}
+
+// Stream visibility modes
+const (
+	VisibilityPublic     = "public"      // Anyone with the stream ID can watch
+	VisibilityPasscode   = "passcode"    // Viewers must present the stream's passcode
+	VisibilityInviteList = "invite_list" // Viewers must present one of the stream's invite codes
+)
+
+// StreamInvite is an invite code issued to a single viewer of an invite_list stream
+type StreamInvite struct {
+	ID       string `bson:"id"`
+	Name     string `bson:"name"`
+	CodeHash string `bson:"codeHash"` // SHA-256 hash of the invite code
+}
+
+// InviteCode is an invite code returned once when the stream is created
+type InviteCode struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Code string `json:"code"`
+}
+
+// Share token scopes
+const (
+	ShareScopeLive        = "live"         // Watch the live stream only
+	ShareScopeLiveHistory = "live_history" // Also read the recorded track, export and event log
+)
+
+// ShareToken is a time-limited, revocable share link of a stream. The signed token
+// itself is not stored and only returned once, when it is minted.
+type ShareToken struct {
+	ID        string     `json:"id" bson:"id"`
+	Name      string     `json:"name,omitempty" bson:"name,omitempty"` // Who the link was given to
+	Scope     string     `json:"scope" bson:"scope"`
+	CreatedAt time.Time  `json:"createdAt" bson:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+}
+
+// ViewerBan bars an IP address from watching a stream for the rest of the stream
+type ViewerBan struct {
+	IPAddress string    `json:"ipAddress" bson:"ipAddress"`
+	ClientID  string    `json:"clientId,omitempty" bson:"clientId,omitempty"` // Connection the ban was issued against, if banned by client ID
+	BannedAt  time.Time `json:"bannedAt" bson:"bannedAt"`
+}
+
+// ViewerMute rejects the chat and reactions of an IP address until the broadcaster unmutes it
+type ViewerMute struct {
+	IPAddress string    `json:"ipAddress" bson:"ipAddress"`
+	ClientID  string    `json:"clientId,omitempty" bson:"clientId,omitempty"` // Connection the mute was issued against, if muted by client ID
+	MutedAt   time.Time `json:"mutedAt" bson:"mutedAt"`
+}
+
+// CreateShareTokenRequest represents the optional body when minting a share token
+type CreateShareTokenRequest struct {
+	Name             string `json:"name,omitempty"`
+	Scope            string `json:"scope,omitempty"`            // Defaults to live
+	ExpiresInSeconds int64  `json:"expiresInSeconds,omitempty"` // Defaults to DefaultShareTokenTTL
+}
+
+// ShareTokenResponse represents a newly minted share token
+type ShareTokenResponse struct {
+	ShareToken
+	Token string `json:"token"` // Signed token to pass as ?share= in the viewer link, only returned once
+}
+
+// ShareTokensResponse represents the share tokens of a stream
+type ShareTokensResponse struct {
+	StreamID    string       `json:"streamId"`
+	ShareTokens []ShareToken `json:"shareTokens"`
+}
+
+// Geofence represents a circular zone around a point
+type Geofence struct {
+	ID           string  `json:"id" bson:"id"`
+	Name         string  `json:"name" bson:"name"`
+	Latitude     float64 `json:"latitude" bson:"latitude"`
+	Longitude    float64 `json:"longitude" bson:"longitude"`
+	RadiusMeters float64 `json:"radiusMeters" bson:"radiusMeters"`
+}
+
+// StreamEvent represents an entry in a stream's event log (e.g. "arrived", "entered_zone", "left_zone", "off_route")
+type StreamEvent struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	StreamID  string             `json:"streamId" bson:"streamId"`
+	Type      string             `json:"type" bson:"type"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+	Latitude  float64            `json:"latitude" bson:"latitude"`
+	Longitude float64            `json:"longitude" bson:"longitude"`
+	ZoneID    string             `json:"zoneId,omitempty" bson:"zoneId,omitempty"`
+	ZoneName  string             `json:"zoneName,omitempty" bson:"zoneName,omitempty"`
+
+	DistanceFromRouteMeters float64 `json:"distanceFromRouteMeters,omitempty" bson:"distanceFromRouteMeters,omitempty"` // Set on off_route and back_on_route
+}
+
+// CreateStreamRequest represents the optional body when creating a new stream
+type CreateStreamRequest struct {
+	Geofences  []Geofence `json:"geofences,omitempty"`
+	Visibility string     `json:"visibility,omitempty"` // Defaults to public
+	Passcode   string     `json:"passcode,omitempty"`   // Required for passcode streams
+	Invitees   []string   `json:"invitees,omitempty"`   // Names to issue invite codes for, required for invite_list streams
+}
+
+// GeofencesRequest represents the body when replacing a stream's geofences
+type GeofencesRequest struct {
+	Geofences []Geofence `json:"geofences"`
 }
 
 // StreamJoinLog represents a log entry when someone joins a stream
@@ -78,6 +210,65 @@ type StreamJoinLog struct {
 	LeftAt    *time.Time         `json:"leftAt,omitempty" bson:"leftAt,omitempty"`
 	UserAgent string             `json:"userAgent,omitempty" bson:"userAgent,omitempty"`
 	IPAddress string             `json:"ipAddress,omitempty" bson:"ipAddress,omitempty"`
+
+	// Connection and display name of the viewer, for the broadcaster's viewer list
+	ClientID    string `json:"clientId,omitempty" bson:"clientId,omitempty"`
+	DisplayName string `json:"displayName,omitempty" bson:"displayName,omitempty"`
+
+	// Why the server disconnected the viewer, empty if the viewer left or the stream ended
+	LeaveReason string `json:"leaveReason,omitempty" bson:"leaveReason,omitempty"`
+}
+
+// Join log leave reasons
+const (
+	LeaveReasonSlowConsumer = "slow_consumer" // Evicted for not keeping up with the stream
+	LeaveReasonKicked       = "kicked"        // Kicked by the broadcaster
+	LeaveReasonBanned       = "banned"        // Banned by the broadcaster
+)
+
+// TrackPoint represents a single recorded location of a stream's route
+type TrackPoint struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	StreamID  string             `json:"streamId" bson:"streamId"`
+	Timestamp time.Time          `json:"timestamp" bson:"timestamp"`
+	Latitude  float64            `json:"latitude" bson:"latitude"`
+	Longitude float64            `json:"longitude" bson:"longitude"`
+	SpeedKmh  float64            `json:"speedKmh" bson:"speedKmh"`
+	IsPaused  bool               `json:"isPaused" bson:"isPaused"`
+}
+
+// TripSummary summarises a drive, computed when its stream is deleted or auto-cancelled
+type TripSummary struct {
+	StreamID        string       `json:"streamId" bson:"streamId"`
+	StartedAt       *time.Time   `json:"startedAt,omitempty" bson:"startedAt,omitempty"` // First recorded track point, omitted if nothing was recorded
+	EndedAt         time.Time    `json:"endedAt" bson:"endedAt"`                         // When the stream was deleted or auto-cancelled
+	AutoCancelled   bool         `json:"autoCancelled" bson:"autoCancelled"`
+	DistanceKm      float64      `json:"distanceKm" bson:"distanceKm"`
+	MovingSeconds   float64      `json:"movingSeconds" bson:"movingSeconds"`
+	PausedSeconds   float64      `json:"pausedSeconds" bson:"pausedSeconds"`     // Paused by the broadcaster, or no frames received for a while
+	AverageSpeedKmh float64      `json:"averageSpeedKmh" bson:"averageSpeedKmh"` // Over the moving time
+	MaxSpeedKmh     float64      `json:"maxSpeedKmh" bson:"maxSpeedKmh"`
+	StartAddress    *TripAddress `json:"startAddress,omitempty" bson:"startAddress,omitempty"`
+	EndAddress      *TripAddress `json:"endAddress,omitempty" bson:"endAddress,omitempty"`
+	PeakViewerCount int          `json:"peakViewerCount" bson:"peakViewerCount"` // Most viewers watching at the same time
+	TotalViewers    int          `json:"totalViewers" bson:"totalViewers"`       // Distinct viewers, by IP address and user agent
+}
+
+// TripAddress is a start or end address reported by the mobile app
+type TripAddress struct {
+	AddressLine string `json:"addressLine,omitempty" bson:"addressLine,omitempty"`
+	PostalCode  string `json:"postalCode,omitempty" bson:"postalCode,omitempty"`
+	City        string `json:"city,omitempty" bson:"city,omitempty"`
+}
+
+// TrackResponse represents a page of recorded track points
+type TrackResponse struct {
+	StreamID string       `json:"streamId"`
+	Points   []TrackPoint `json:"points"`
+	Total    int64        `json:"total"` // Total number of points matching the time range
+	Limit    int64        `json:"limit"`
+	Offset   int64        `json:"offset"`
+	HasMore  bool         `json:"hasMore"`
 }
 
 // WebSocketMessage represents messages sent over WebSocket
@@ -86,6 +277,160 @@ type WebSocketMessage struct {
 	Payload interface{} `json:"payload"`
 }
 
+// StreamSnapshot is sent to a viewer as soon as it joins so it can render the
+// stream without waiting for the next stream_data frame
+type StreamSnapshot struct {
+	StreamID             string          `json:"streamId"`
+	LatestData           json.RawMessage `json:"latestData,omitempty"` // Payload of the last stream_data frame
+	RecentTrack          []TrackPoint    `json:"recentTrack"`          // Most recent track points, oldest first
+	BroadcasterConnected bool            `json:"broadcasterConnected"`
+}
+
+// BroadcasterStatusUpdate is sent to viewers when the broadcaster drops ("broadcaster_reconnecting")
+// and when it comes back ("broadcaster_reconnected")
+type BroadcasterStatusUpdate struct {
+	StreamID           string     `json:"streamId"`
+	GracePeriodSeconds float64    `json:"gracePeriodSeconds,omitempty"`
+	ReconnectDeadline  *time.Time `json:"reconnectDeadline,omitempty"` // Viewers are disconnected if the broadcaster has not reconnected by then
+}
+
+// ShareAccessEnded is sent to a viewer before it is disconnected because its share
+// token was revoked ("access_revoked") or expired ("access_expired")
+type ShareAccessEnded struct {
+	StreamID     string `json:"streamId"`
+	ShareTokenID string `json:"shareTokenId"`
+}
+
+// KeyframeRequired is sent to the broadcaster when a stream_data_delta can't be applied
+// because the server has no previous frame, asking it to send a full stream_data frame
+type KeyframeRequired struct {
+	StreamID string `json:"streamId"`
+}
+
+// LaggingNotice is sent to a viewer that missed messages because it wasn't reading fast enough
+type LaggingNotice struct {
+	StreamID        string `json:"streamId"`
+	DroppedMessages int64  `json:"droppedMessages"`
+}
+
+// RateLimitNotice is sent to a client when its messages start being dropped for exceeding the message rate
+type RateLimitNotice struct {
+	MessagesPerSecond float64 `json:"messagesPerSecond"`
+	Burst             int     `json:"burst"`
+}
+
+// Replay control actions
+const (
+	ReplayActionPlay  = "play"
+	ReplayActionPause = "pause"
+	ReplayActionSeek  = "seek"  // Jump to PositionSeconds
+	ReplayActionSpeed = "speed" // Change the playback speed to Speed
+)
+
+// ReplayControl is sent by a replay viewer ("replay_control") to steer the playback
+type ReplayControl struct {
+	Action          string  `json:"action"`
+	PositionSeconds float64 `json:"positionSeconds,omitempty"` // Offset from the start of the drive, for seek
+	Speed           float64 `json:"speed,omitempty"`           // Playback speed, for speed
+}
+
+// ReplayState is sent to a replay viewer ("replay_state") when it connects, after every
+// control and when the playback reaches the end of the drive
+type ReplayState struct {
+	StreamID        string    `json:"streamId"`
+	StartedAt       time.Time `json:"startedAt"`
+	DurationSeconds float64   `json:"durationSeconds"`
+	PositionSeconds float64   `json:"positionSeconds"`
+	Speed           float64   `json:"speed"`
+	Paused          bool      `json:"paused"`
+	Finished        bool      `json:"finished"` // Every frame was played, seek back to replay again
+}
+
+// ChatMessage is a viewer's chat message, sent to the stream's viewers and broadcaster
+// ("chat") and kept in the stream's chat history
+type ChatMessage struct {
+	ID          primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	StreamID    string             `json:"streamId" bson:"streamId"`
+	ClientID    string             `json:"clientId" bson:"clientId"` // Connection of the sender, the target of moderation
+	DisplayName string             `json:"displayName" bson:"displayName"`
+	Text        string             `json:"text" bson:"text"`
+	SentAt      time.Time          `json:"sentAt" bson:"sentAt"`
+}
+
+// Reaction is a viewer's emoji reaction, sent to the stream's viewers and broadcaster
+// ("reaction") but not persisted
+type Reaction struct {
+	StreamID    string    `json:"streamId"`
+	ClientID    string    `json:"clientId"`
+	DisplayName string    `json:"displayName"`
+	Emoji       string    `json:"emoji"`
+	SentAt      time.Time `json:"sentAt"`
+}
+
+// ChatRequest is the payload of a "chat" message from a viewer
+type ChatRequest struct {
+	Text string `json:"text"`
+}
+
+// ReactionRequest is the payload of a "reaction" message from a viewer
+type ReactionRequest struct {
+	Emoji string `json:"emoji"`
+}
+
+// ChatSettings is sent by the broadcaster ("chat_settings") to change how it receives chat,
+// and echoed back once applied
+type ChatSettings struct {
+	Muted bool `json:"muted"` // Stop forwarding chat and reactions to the broadcaster, e.g. while driving
+}
+
+// Moderation actions the broadcaster can take against a viewer
+const (
+	ModerationMute   = "mute" // The viewer's chat and reactions are rejected
+	ModerationUnmute = "unmute"
+	ModerationKick   = "kick" // The viewer is disconnected
+	ModerationBan    = "ban"  // The viewer is disconnected and its IP address can't watch again
+)
+
+// ModerationRequest is sent by the broadcaster ("moderate") to act on a viewer, identified by
+// the client ID of its messages or viewer list entry, or on every viewer of an IP address
+type ModerationRequest struct {
+	Action    string `json:"action"`
+	ClientID  string `json:"clientId,omitempty"`
+	IPAddress string `json:"ipAddress,omitempty"`
+}
+
+// ViewerInfo describes a connected viewer in a "viewer_list" message
+type ViewerInfo struct {
+	ClientID    string    `json:"clientId"`
+	DisplayName string    `json:"displayName,omitempty"`
+	IPAddress   string    `json:"ipAddress"`
+	UserAgent   string    `json:"userAgent,omitempty"`
+	JoinedAt    time.Time `json:"joinedAt"`
+}
+
+// ViewerList is sent to the broadcaster ("viewer_list") in answer to a "list_viewers" message
+type ViewerList struct {
+	StreamID string       `json:"streamId"`
+	Viewers  []ViewerInfo `json:"viewers"` // Oldest first
+}
+
+// ViewerModerated is sent to a viewer the broadcaster muted ("chat_muted"), unmuted
+// ("chat_unmuted"), kicked ("kicked") or banned ("banned")
+type ViewerModerated struct {
+	StreamID string `json:"streamId"`
+}
+
+// ChatHistoryResponse represents the latest chat messages of a stream
+type ChatHistoryResponse struct {
+	StreamID string        `json:"streamId"`
+	Messages []ChatMessage `json:"messages"` // Oldest first
+}
+
+// ErrorMessage is the payload of an "error" message
+type ErrorMessage struct {
+	Message string `json:"message"`
+}
+
 // ViewerCountUpdate represents the viewer count update sent to mobile app
 type ViewerCountUpdate struct {
 	StreamID    string `json:"streamId"`
@@ -95,8 +440,11 @@ type ViewerCountUpdate struct {
 
 // StreamIDResponse represents the response when creating a new stream
 type StreamIDResponse struct {
-	StreamID string `json:"streamId"`
-	Message  string `json:"message"`
+	StreamID         string       `json:"streamId"`
+	BroadcasterToken string       `json:"broadcasterToken"` // Secret required to broadcast to or delete the stream, only returned once
+	Visibility       string       `json:"visibility"`
+	Invites          []InviteCode `json:"invites,omitempty"` // Invite codes for invite_list streams, only returned once
+	Message          string       `json:"message"`
 }
 
 // FeatureFlags represents the feature flags configuration
@@ -0,0 +1,352 @@
+package models
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits applied to stream_data payloads received from the mobile app
+const (
+	MaxStringLength   = 256    // Maximum length of address, city and car fields
+	MaxPolylinePoints = 10000  // Maximum number of points in NavigationData.Polyline
+	MaxSpeedKmh       = 1000.0 // Upper bound for any reported speed
+)
+
+// Limits applied to user-defined geofences
+const (
+	MaxGeofences            = 50
+	MaxGeofenceRadiusMeters = 100000.0
+)
+
+// Limits applied to stream access settings
+const (
+	MinPasscodeLength = 4
+	MaxPasscodeLength = 64
+	MaxPasscodeBytes  = 72 // bcrypt rejects longer passwords, which multibyte passcodes can reach within MaxPasscodeLength
+	MaxInvitees       = 50
+)
+
+// Limits applied to share tokens
+const (
+	DefaultShareTokenTTL = 24 * time.Hour
+	MinShareTokenTTL     = time.Minute
+	MaxShareTokenTTL     = 30 * 24 * time.Hour
+	MaxShareTokens       = 100 // Per stream, including revoked and expired ones
+)
+
+// Limits applied to viewer chat
+const (
+	MaxDisplayNameLength = 32
+	MaxChatMessageLength = 280
+	MaxReactionLength    = 8 // Runes, enough for emoji sequences such as flags and skin tones
+)
+
+// Code points that turn a preceding text character into an emoji
+const (
+	emojiPresentationSelector = '\uFE0F' // Variation selector-16
+	combiningEnclosingKeycap  = '\u20E3'
+)
+
+// Sanitize trims whitespace and strips control characters from all string fields
+func (d *StreamData) Sanitize() {
+	for _, field := range d.stringFields() {
+		*field.value = sanitizeString(*field.value)
+	}
+}
+
+// Validate checks that the stream data is within sane bounds
+func (d *StreamData) Validate() error {
+	if err := validateCoordinate("currentLocation", d.CurrentLocation.Latitude, d.CurrentLocation.Longitude); err != nil {
+		return err
+	}
+	if err := validateCoordinate("start", d.StartLatitude, d.StartLongitude); err != nil {
+		return err
+	}
+	if err := validateCoordinate("end", d.EndLatitude, d.EndLongitude); err != nil {
+		return err
+	}
+	if err := validateCoordinate("destination", d.DestinationLatitude, d.DestinationLongitude); err != nil {
+		return err
+	}
+
+	if err := validateSpeed("currentSpeedKmh", d.CurrentSpeedKmh); err != nil {
+		return err
+	}
+	if err := validateSpeed("maxSpeedKmh", d.MaxSpeedKmh); err != nil {
+		return err
+	}
+
+	nonNegative := []numberField{
+		{"duration", d.Duration},
+		{"distanceKm", d.DistanceKm},
+		{"expectedDuration", d.ExpectedDuration},
+		{"car.horsePower", float64(d.Car.HorsePower)},
+	}
+	if d.ExpectedDistanceKm != nil {
+		nonNegative = append(nonNegative, numberField{"expectedDistanceKm", *d.ExpectedDistanceKm})
+	}
+	if d.NavigationData != nil {
+		nonNegative = append(nonNegative,
+			numberField{"navigationData.distance", d.NavigationData.Distance},
+			numberField{"navigationData.expectedTravelTime", d.NavigationData.ExpectedTravelTime},
+		)
+	}
+	for _, field := range nonNegative {
+		if field.value < 0 {
+			return fmt.Errorf("%s must not be negative", field.name)
+		}
+	}
+
+	for _, field := range d.stringFields() {
+		if utf8.RuneCountInString(*field.value) > MaxStringLength {
+			return fmt.Errorf("%s exceeds %d characters", field.name, MaxStringLength)
+		}
+	}
+
+	if d.NavigationData != nil {
+		if len(d.NavigationData.Polyline) > MaxPolylinePoints {
+			return fmt.Errorf("navigationData.polyline exceeds %d points", MaxPolylinePoints)
+		}
+		for i, point := range d.NavigationData.Polyline {
+			if len(point) != 2 {
+				return fmt.Errorf("navigationData.polyline[%d] must be a [lat, long] pair", i)
+			}
+			if err := validateCoordinate(fmt.Sprintf("navigationData.polyline[%d]", i), point[0], point[1]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// ValidateGeofences sanitizes and checks a list of geofences
+func ValidateGeofences(fences []Geofence) error {
+	if len(fences) > MaxGeofences {
+		return fmt.Errorf("at most %d geofences are allowed", MaxGeofences)
+	}
+
+	for i := range fences {
+		fence := &fences[i]
+		fence.ID = sanitizeString(fence.ID)
+		fence.Name = sanitizeString(fence.Name)
+
+		name := fmt.Sprintf("geofences[%d]", i)
+		if err := validateCoordinate(name, fence.Latitude, fence.Longitude); err != nil {
+			return err
+		}
+		if fence.RadiusMeters <= 0 || fence.RadiusMeters > MaxGeofenceRadiusMeters {
+			return fmt.Errorf("%s radiusMeters must be between 0 and %.0f", name, MaxGeofenceRadiusMeters)
+		}
+		if utf8.RuneCountInString(fence.ID) > MaxStringLength || utf8.RuneCountInString(fence.Name) > MaxStringLength {
+			return fmt.Errorf("%s id and name must not exceed %d characters", name, MaxStringLength)
+		}
+	}
+
+	return nil
+}
+
+// ValidateAccess sanitizes and checks the visibility settings, defaulting to a public stream
+func (r *CreateStreamRequest) ValidateAccess() error {
+	if r.Visibility == "" {
+		r.Visibility = VisibilityPublic
+	}
+
+	switch r.Visibility {
+	case VisibilityPublic:
+		if r.Passcode != "" || len(r.Invitees) > 0 {
+			return fmt.Errorf("public streams take no passcode or invitees")
+		}
+	case VisibilityPasscode:
+		length := utf8.RuneCountInString(r.Passcode)
+		if length < MinPasscodeLength || length > MaxPasscodeLength {
+			return fmt.Errorf("passcode must be between %d and %d characters", MinPasscodeLength, MaxPasscodeLength)
+		}
+		if len(r.Passcode) > MaxPasscodeBytes {
+			return fmt.Errorf("passcode must be at most %d bytes", MaxPasscodeBytes)
+		}
+		if len(r.Invitees) > 0 {
+			return fmt.Errorf("passcode streams take no invitees")
+		}
+	case VisibilityInviteList:
+		if len(r.Invitees) == 0 || len(r.Invitees) > MaxInvitees {
+			return fmt.Errorf("invite_list streams need between 1 and %d invitees", MaxInvitees)
+		}
+		if r.Passcode != "" {
+			return fmt.Errorf("invite_list streams take no passcode")
+		}
+		for i := range r.Invitees {
+			r.Invitees[i] = sanitizeString(r.Invitees[i])
+			if utf8.RuneCountInString(r.Invitees[i]) > MaxStringLength {
+				return fmt.Errorf("invitees[%d] exceeds %d characters", i, MaxStringLength)
+			}
+		}
+	default:
+		return fmt.Errorf("visibility must be one of %s, %s, %s", VisibilityPublic, VisibilityPasscode, VisibilityInviteList)
+	}
+
+	return nil
+}
+
+// Validate sanitizes and checks a share token request, defaulting to a live-only token
+// valid for DefaultShareTokenTTL
+func (r *CreateShareTokenRequest) Validate() error {
+	r.Name = sanitizeString(r.Name)
+	if utf8.RuneCountInString(r.Name) > MaxStringLength {
+		return fmt.Errorf("name exceeds %d characters", MaxStringLength)
+	}
+
+	if r.Scope == "" {
+		r.Scope = ShareScopeLive
+	}
+	if r.Scope != ShareScopeLive && r.Scope != ShareScopeLiveHistory {
+		return fmt.Errorf("scope must be one of %s, %s", ShareScopeLive, ShareScopeLiveHistory)
+	}
+
+	if r.ExpiresInSeconds == 0 {
+		r.ExpiresInSeconds = int64(DefaultShareTokenTTL.Seconds())
+	}
+	if r.ExpiresInSeconds < int64(MinShareTokenTTL.Seconds()) || r.ExpiresInSeconds > int64(MaxShareTokenTTL.Seconds()) {
+		return fmt.Errorf("expiresInSeconds must be between %d and %d", int64(MinShareTokenTTL.Seconds()), int64(MaxShareTokenTTL.Seconds()))
+	}
+
+	return nil
+}
+
+// Validate sanitizes and checks a chat message
+func (r *ChatRequest) Validate() error {
+	r.Text = sanitizeString(r.Text)
+	if r.Text == "" {
+		return fmt.Errorf("text must not be empty")
+	}
+	if utf8.RuneCountInString(r.Text) > MaxChatMessageLength {
+		return fmt.Errorf("text exceeds %d characters", MaxChatMessageLength)
+	}
+	return nil
+}
+
+// Validate sanitizes and checks that a reaction is a single short emoji sequence
+func (r *ReactionRequest) Validate() error {
+	r.Emoji = sanitizeString(r.Emoji)
+
+	length := utf8.RuneCountInString(r.Emoji)
+	if length == 0 || length > MaxReactionLength {
+		return fmt.Errorf("emoji must be between 1 and %d characters", MaxReactionLength)
+	}
+	runes := []rune(r.Emoji)
+	for i, char := range runes {
+		if unicode.IsSpace(char) {
+			return fmt.Errorf("emoji must only contain emoji")
+		}
+		if char > unicode.MaxASCII && !unicode.IsLetter(char) && !unicode.IsNumber(char) {
+			continue
+		}
+
+		// Text characters only count as emoji when followed by the emoji presentation selector
+		// or the keycap mark, as in 1️⃣, #️⃣ or ℹ️. Only digits, # and * have keycaps.
+		var next rune
+		if i+1 < len(runes) {
+			next = runes[i+1]
+		}
+		isKeycapBase := char >= '0' && char <= '9' || char == '#' || char == '*'
+		switch {
+		case next == emojiPresentationSelector && (char > unicode.MaxASCII || isKeycapBase):
+		case next == combiningEnclosingKeycap && isKeycapBase:
+		default:
+			return fmt.Errorf("emoji must only contain emoji")
+		}
+	}
+	return nil
+}
+
+// Validate sanitizes and checks a moderation request, normalizing its IP address
+func (r *ModerationRequest) Validate() error {
+	switch r.Action {
+	case ModerationMute, ModerationUnmute, ModerationKick, ModerationBan:
+	default:
+		return fmt.Errorf("action must be one of %s, %s, %s, %s", ModerationMute, ModerationUnmute, ModerationKick, ModerationBan)
+	}
+
+	r.ClientID = sanitizeString(r.ClientID)
+	r.IPAddress = sanitizeString(r.IPAddress)
+	if r.ClientID == "" && r.IPAddress == "" {
+		return fmt.Errorf("clientId or ipAddress is required")
+	}
+
+	if r.IPAddress != "" {
+		ip := net.ParseIP(r.IPAddress)
+		if ip == nil {
+			return fmt.Errorf("ipAddress is not a valid IP address")
+		}
+		r.IPAddress = ip.String()
+	}
+
+	return nil
+}
+
+// SanitizeDisplayName cleans a viewer's display name, shortening it to MaxDisplayNameLength
+func SanitizeDisplayName(name string) string {
+	name = sanitizeString(name)
+	if runes := []rune(name); len(runes) > MaxDisplayNameLength {
+		name = strings.TrimSpace(string(runes[:MaxDisplayNameLength]))
+	}
+	return name
+}
+
+type numberField struct {
+	name  string
+	value float64
+}
+
+type stringField struct {
+	name  string
+	value *string
+}
+
+func (d *StreamData) stringFields() []stringField {
+	return []stringField{
+		{"startAddressLine", &d.StartAddressLine},
+		{"startPostalCode", &d.StartPostalCode},
+		{"startCity", &d.StartCity},
+		{"endAddressLine", &d.EndAddressLine},
+		{"endPostalCode", &d.EndPostalCode},
+		{"endCity", &d.EndCity},
+		{"destinationAddressLine", &d.DestinationAddressLine},
+		{"destinationPostalCode", &d.DestinationPostalCode},
+		{"destinationCity", &d.DestinationCity},
+		{"destinationName", &d.DestinationName},
+		{"car.name", &d.Car.Name},
+		{"car.model", &d.Car.Model},
+	}
+}
+
+func validateCoordinate(name string, latitude, longitude float64) error {
+	if latitude < -90 || latitude > 90 {
+		return fmt.Errorf("%s latitude must be between -90 and 90", name)
+	}
+	if longitude < -180 || longitude > 180 {
+		return fmt.Errorf("%s longitude must be between -180 and 180", name)
+	}
+	return nil
+}
+
+func validateSpeed(name string, speed float64) error {
+	if speed < 0 || speed > MaxSpeedKmh {
+		return fmt.Errorf("%s must be between 0 and %.0f", name, MaxSpeedKmh)
+	}
+	return nil
+}
+
+func sanitizeString(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value)
+	return strings.TrimSpace(value)
+}
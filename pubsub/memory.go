@@ -0,0 +1,93 @@
+package pubsub
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process PubSub. Hubs sharing the same Memory instance behave
+// like separate velocity-be instances behind a load balancer.
+type Memory struct {
+	subscribers map[chan Event]bool
+	closed      bool
+	mu          sync.RWMutex
+}
+
+// NewMemory creates a new in-memory PubSub
+func NewMemory() *Memory {
+	return &Memory{
+		subscribers: make(map[chan Event]bool),
+	}
+}
+
+// Publish delivers the event to every subscriber, dropping it for subscribers that are too far behind
+func (m *Memory) Publish(event Event) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.closed {
+		return ErrClosed
+	}
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	for subscriber := range m.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			slog.Warn("Dropping pub/sub event: subscriber buffer full", "streamId", event.StreamID, "kind", event.Kind)
+		}
+	}
+	return nil
+}
+
+// Subscribe registers a new subscriber
+func (m *Memory) Subscribe(ctx context.Context) (<-chan Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, ErrClosed
+	}
+
+	events := make(chan Event, subscriberBufferSize)
+	m.subscribers[events] = true
+
+	go func() {
+		<-ctx.Done()
+		m.unsubscribe(events)
+	}()
+
+	return events, nil
+}
+
+func (m *Memory) unsubscribe(events chan Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.subscribers[events]; ok {
+		delete(m.subscribers, events)
+		close(events)
+	}
+}
+
+// Close closes all subscriber channels
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	for subscriber := range m.subscribers {
+		close(subscriber)
+		delete(m.subscribers, subscriber)
+	}
+	return nil
+}
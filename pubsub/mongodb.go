@@ -0,0 +1,188 @@
+package pubsub
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mongoEventTTL is how long published events are kept in the events collection
+const mongoEventTTL = 60 * time.Second
+
+// mongoPublishBufferSize is the number of events queued for insertion
+const mongoPublishBufferSize = 4096
+
+// MongoDB is a PubSub backed by a MongoDB collection and change streams.
+// Every instance inserts its events into the collection and watches it for
+// events inserted by any instance. Change streams require a replica set.
+type MongoDB struct {
+	collection *mongo.Collection
+	outgoing   chan Event
+	done       chan struct{}
+	closeOnce  sync.Once
+	wg         sync.WaitGroup
+}
+
+// NewMongoDB creates a PubSub using the given collection for event exchange
+func NewMongoDB(collection *mongo.Collection) *MongoDB {
+	m := &MongoDB{
+		collection: collection,
+		outgoing:   make(chan Event, mongoPublishBufferSize),
+		done:       make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Expire old events so the collection does not grow without bound
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "createdAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(int32(mongoEventTTL.Seconds())),
+	})
+	if err != nil {
+		slog.Error("Error creating pub/sub events TTL index", "error", err)
+	}
+
+	m.wg.Add(1)
+	go m.writeLoop()
+
+	return m
+}
+
+// Publish queues the event for insertion. Events are inserted in order by a single writer.
+func (m *MongoDB) Publish(event Event) error {
+	select {
+	case <-m.done:
+		return ErrClosed
+	default:
+	}
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	select {
+	case m.outgoing <- event:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+func (m *MongoDB) writeLoop() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		case event := <-m.outgoing:
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err := m.collection.InsertOne(ctx, event)
+			cancel()
+			if err != nil {
+				slog.Error("Error publishing pub/sub event", "streamId", event.StreamID, "kind", event.Kind, "error", err)
+			}
+		}
+	}
+}
+
+// Subscribe watches the events collection for newly inserted events
+func (m *MongoDB) Subscribe(ctx context.Context) (<-chan Event, error) {
+	pipeline := mongo.Pipeline{{{Key: "$match", Value: bson.D{{Key: "operationType", Value: "insert"}}}}}
+
+	// Stop watching when either the caller cancels or the PubSub is closed
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-m.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	stream, err := m.collection.Watch(ctx, pipeline)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	events := make(chan Event, subscriberBufferSize)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		defer close(events)
+		defer cancel()
+
+		for {
+			m.watch(ctx, stream, events)
+
+			// Resume after transient errors unless we're shutting down
+			select {
+			case <-ctx.Done():
+				stream.Close(context.Background())
+				return
+			case <-time.After(time.Second):
+			}
+
+			resumeToken := stream.ResumeToken()
+			stream.Close(context.Background())
+
+			watchOptions := options.ChangeStream()
+			if resumeToken != nil {
+				watchOptions.SetResumeAfter(resumeToken)
+			}
+
+			stream, err = m.collection.Watch(ctx, pipeline, watchOptions)
+			if err != nil {
+				slog.Error("Error resuming pub/sub change stream", "error", err)
+				// Retry with a fresh stream on the next iteration
+				stream, err = m.collection.Watch(ctx, pipeline)
+				if err != nil {
+					slog.Error("Error restarting pub/sub change stream", "error", err)
+					return
+				}
+			}
+		}
+	}()
+
+	return events, nil
+}
+
+// watch forwards events from the change stream until it fails or is stopped
+func (m *MongoDB) watch(ctx context.Context, stream *mongo.ChangeStream, events chan<- Event) {
+	for stream.Next(ctx) {
+		var change struct {
+			FullDocument Event `bson:"fullDocument"`
+		}
+		if err := stream.Decode(&change); err != nil {
+			slog.Error("Error decoding pub/sub event", "error", err)
+			continue
+		}
+
+		select {
+		case events <- change.FullDocument:
+		default:
+			slog.Warn("Dropping pub/sub event: subscriber buffer full", "streamId", change.FullDocument.StreamID, "kind", change.FullDocument.Kind)
+		}
+	}
+
+	if err := stream.Err(); err != nil && ctx.Err() == nil {
+		slog.Error("Pub/sub change stream error", "error", err)
+	}
+}
+
+// Close stops publishing and watching, and waits for background goroutines to exit
+func (m *MongoDB) Close() error {
+	m.closeOnce.Do(func() {
+		close(m.done)
+	})
+	m.wg.Wait()
+	return nil
+}
@@ -0,0 +1,39 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrBufferFull is returned when an event cannot be queued for publishing
+var ErrBufferFull = errors.New("pubsub: publish buffer full")
+
+// ErrClosed is returned when publishing to or subscribing on a closed PubSub
+var ErrClosed = errors.New("pubsub: closed")
+
+// Event is a message exchanged between hub instances
+type Event struct {
+	Origin    string    `bson:"origin"`    // Instance ID of the publishing hub
+	Kind      string    `bson:"kind"`      // Event kind, interpreted by the hub
+	StreamID  string    `bson:"streamId"`  // Stream the event belongs to
+	Data      []byte    `bson:"data"`      // Opaque event payload
+	CreatedAt time.Time `bson:"createdAt"` // When the event was published
+}
+
+// PubSub fans out hub events to every velocity-be instance sharing the same backend
+type PubSub interface {
+	// Publish queues an event for delivery to all subscribers, including the publisher.
+	// It must not block the caller.
+	Publish(event Event) error
+
+	// Subscribe returns a channel receiving every published event until ctx is
+	// cancelled or the PubSub is closed.
+	Subscribe(ctx context.Context) (<-chan Event, error)
+
+	// Close stops delivery and releases resources
+	Close() error
+}
+
+// subscriberBufferSize is the number of events buffered per subscriber
+const subscriberBufferSize = 1024
@@ -0,0 +1,481 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"slices"
+	"time"
+
+	"velocity-be/models"
+
+	bolt "go.etcd.io/bbolt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Bolt bucket names. Track points, stream events and chat messages are kept in a nested bucket
+// per stream, keyed by timestamp so they can be read back in order.
+var (
+	boltStreamsBucket      = []byte("streams")
+	boltJoinLogsBucket     = []byte("stream_join_logs")
+	boltFeatureFlagsBucket = []byte("feature_flags")
+	boltTrackPointsBucket  = []byte("track_points")
+	boltStreamEventsBucket = []byte("stream_events")
+	boltChatMessagesBucket = []byte("chat_messages")
+
+	boltFeatureFlagsKey = []byte("flags")
+)
+
+// Bolt is a Store backed by an embedded BoltDB file, for single-instance
+// deployments without MongoDB. Records are encoded as BSON like in MongoDB.
+type Bolt struct {
+	db *bolt.DB
+}
+
+// NewBolt opens or creates the BoltDB file at path
+func NewBolt(path string) (*Bolt, error) {
+	boltDB, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = boltDB.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltStreamsBucket, boltJoinLogsBucket, boltFeatureFlagsBucket, boltTrackPointsBucket, boltStreamEventsBucket, boltChatMessagesBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		boltDB.Close()
+		return nil, err
+	}
+
+	return &Bolt{db: boltDB}, nil
+}
+
+func (b *Bolt) Streams() StreamRepository           { return boltStreams{b.db} }
+func (b *Bolt) JoinLogs() JoinLogRepository         { return boltJoinLogs{b.db} }
+func (b *Bolt) FeatureFlags() FeatureFlagRepository { return boltFeatureFlags{b.db} }
+func (b *Bolt) TrackPoints() TrackPointRepository   { return boltTrackPoints{b.db} }
+func (b *Bolt) StreamEvents() StreamEventRepository { return boltStreamEvents{b.db} }
+func (b *Bolt) ChatMessages() ChatMessageRepository { return boltChatMessages{b.db} }
+
+// Close closes the BoltDB file
+func (b *Bolt) Close() error {
+	return b.db.Close()
+}
+
+type boltStreams struct{ db *bolt.DB }
+
+func (r boltStreams) Create(ctx context.Context, stream *models.Stream) error {
+	if stream.ID.IsZero() {
+		stream.ID = primitive.NewObjectID()
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return putBSON(tx.Bucket(boltStreamsBucket), []byte(stream.StreamID), stream)
+	})
+}
+
+func (r boltStreams) Get(ctx context.Context, streamID string) (*models.Stream, error) {
+	var stream models.Stream
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return getBSON(tx.Bucket(boltStreamsBucket), []byte(streamID), &stream)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stream, nil
+}
+
+func (r boltStreams) MarkDeleted(ctx context.Context, streamID string, deletedAt time.Time, autoCancelled bool) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		markDeleted(stream, deletedAt, autoCancelled)
+	})
+}
+
+func (r boltStreams) UpdateLatestData(ctx context.Context, streamID string, data models.StreamData) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.LatestData = &data
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r boltStreams) UpdateLastConnection(ctx context.Context, streamID string, connectedAt time.Time) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.LastConnectionAt = &connectedAt
+	})
+}
+
+func (r boltStreams) UpdateGeofences(ctx context.Context, streamID string, fences []models.Geofence) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.Geofences = fences
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r boltStreams) AddShareToken(ctx context.Context, streamID string, token models.ShareToken) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.ShareTokens = append(stream.ShareTokens, token)
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r boltStreams) RevokeShareToken(ctx context.Context, streamID, tokenID string, revokedAt time.Time) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		revokeShareToken(stream, tokenID, revokedAt)
+	})
+}
+
+func (r boltStreams) AddBan(ctx context.Context, streamID string, ban models.ViewerBan) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.Bans = append(stream.Bans, ban)
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r boltStreams) AddMute(ctx context.Context, streamID string, mute models.ViewerMute) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.Mutes = append(stream.Mutes, mute)
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r boltStreams) RemoveMute(ctx context.Context, streamID, ipAddress string) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		removeMute(stream, ipAddress)
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r boltStreams) SaveSummary(ctx context.Context, streamID string, summary models.TripSummary) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.Summary = &summary
+	})
+}
+
+func (r boltStreams) CountActiveByCreator(ctx context.Context, creatorKey string) (int64, error) {
+	var count int64
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltStreamsBucket).ForEach(func(key, value []byte) error {
+			var stream models.Stream
+			if err := bson.Unmarshal(value, &stream); err != nil {
+				return err
+			}
+			if isActiveByCreator(&stream, creatorKey) {
+				count++
+			}
+			return nil
+		})
+	})
+	return count, err
+}
+
+func (r boltStreams) ListInactive(ctx context.Context, cutoff time.Time) ([]models.Stream, error) {
+	var streams []models.Stream
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltStreamsBucket).ForEach(func(key, value []byte) error {
+			var stream models.Stream
+			if err := bson.Unmarshal(value, &stream); err != nil {
+				return err
+			}
+			if isInactive(&stream, cutoff) {
+				streams = append(streams, stream)
+			}
+			return nil
+		})
+	})
+	return streams, err
+}
+
+// update applies fn to a stored stream. Like a MongoDB update, a missing stream is not an error.
+func (r boltStreams) update(streamID string, fn func(stream *models.Stream)) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltStreamsBucket)
+
+		var stream models.Stream
+		err := getBSON(bucket, []byte(streamID), &stream)
+		if err == ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		fn(&stream)
+		return putBSON(bucket, []byte(streamID), &stream)
+	})
+}
+
+type boltJoinLogs struct{ db *bolt.DB }
+
+func (r boltJoinLogs) Create(ctx context.Context, joinLog *models.StreamJoinLog) error {
+	if joinLog.ID.IsZero() {
+		joinLog.ID = primitive.NewObjectID()
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return putBSON(tx.Bucket(boltJoinLogsBucket), joinLog.ID[:], joinLog)
+	})
+}
+
+func (r boltJoinLogs) MarkLeft(ctx context.Context, id primitive.ObjectID, leftAt time.Time, reason string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltJoinLogsBucket)
+
+		var joinLog models.StreamJoinLog
+		err := getBSON(bucket, id[:], &joinLog)
+		if err == ErrNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		joinLog.LeftAt = &leftAt
+		joinLog.LeaveReason = reason
+		return putBSON(bucket, id[:], &joinLog)
+	})
+}
+
+// List scans every join log, which is fine for the single instance deployments the bolt backend targets
+func (r boltJoinLogs) List(ctx context.Context, streamID string) ([]models.StreamJoinLog, error) {
+	joinLogs := []models.StreamJoinLog{}
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltJoinLogsBucket).ForEach(func(key, value []byte) error {
+			var joinLog models.StreamJoinLog
+			if err := bson.Unmarshal(value, &joinLog); err != nil {
+				return err
+			}
+			if joinLog.StreamID == streamID {
+				joinLogs = append(joinLogs, joinLog)
+			}
+			return nil
+		})
+	})
+	return joinLogs, err
+}
+
+type boltFeatureFlags struct{ db *bolt.DB }
+
+func (r boltFeatureFlags) Get(ctx context.Context) (*models.FeatureFlags, error) {
+	var flags models.FeatureFlags
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return getBSON(tx.Bucket(boltFeatureFlagsBucket), boltFeatureFlagsKey, &flags)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &flags, nil
+}
+
+func (r boltFeatureFlags) Save(ctx context.Context, flags *models.FeatureFlags) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return putBSON(tx.Bucket(boltFeatureFlagsBucket), boltFeatureFlagsKey, flags)
+	})
+}
+
+type boltTrackPoints struct{ db *bolt.DB }
+
+func (r boltTrackPoints) Insert(ctx context.Context, point models.TrackPoint) error {
+	if point.ID.IsZero() {
+		point.ID = primitive.NewObjectID()
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltTrackPointsBucket).CreateBucketIfNotExists([]byte(point.StreamID))
+		if err != nil {
+			return err
+		}
+		return putBSON(bucket, timelineKey(point.Timestamp, point.ID), &point)
+	})
+}
+
+func (r boltTrackPoints) InsertMany(ctx context.Context, points []models.TrackPoint) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		for _, point := range points {
+			if point.ID.IsZero() {
+				point.ID = primitive.NewObjectID()
+			}
+
+			bucket, err := tx.Bucket(boltTrackPointsBucket).CreateBucketIfNotExists([]byte(point.StreamID))
+			if err != nil {
+				return err
+			}
+			if err := putBSON(bucket, timelineKey(point.Timestamp, point.ID), &point); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r boltTrackPoints) List(ctx context.Context, query TrackQuery) ([]models.TrackPoint, error) {
+	points := []models.TrackPoint{}
+	var skipped, taken int64
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return scanTimeline(tx.Bucket(boltTrackPointsBucket), query.StreamID, query.From, query.To, func(value []byte) (bool, error) {
+			if skipped < query.Offset {
+				skipped++
+				return true, nil
+			}
+
+			var point models.TrackPoint
+			if err := bson.Unmarshal(value, &point); err != nil {
+				return false, err
+			}
+			points = append(points, point)
+
+			taken++
+			return query.Limit == 0 || taken < query.Limit, nil
+		})
+	})
+	return points, err
+}
+
+func (r boltTrackPoints) Count(ctx context.Context, query TrackQuery) (int64, error) {
+	var count int64
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return scanTimeline(tx.Bucket(boltTrackPointsBucket), query.StreamID, query.From, query.To, func([]byte) (bool, error) {
+			count++
+			return true, nil
+		})
+	})
+	return count, err
+}
+
+type boltStreamEvents struct{ db *bolt.DB }
+
+func (r boltStreamEvents) Insert(ctx context.Context, event models.StreamEvent) error {
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltStreamEventsBucket).CreateBucketIfNotExists([]byte(event.StreamID))
+		if err != nil {
+			return err
+		}
+		return putBSON(bucket, timelineKey(event.Timestamp, event.ID), &event)
+	})
+}
+
+func (r boltStreamEvents) List(ctx context.Context, streamID string, limit int64) ([]models.StreamEvent, error) {
+	events := []models.StreamEvent{}
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return scanTimeline(tx.Bucket(boltStreamEventsBucket), streamID, time.Time{}, time.Time{}, func(value []byte) (bool, error) {
+			var event models.StreamEvent
+			if err := bson.Unmarshal(value, &event); err != nil {
+				return false, err
+			}
+			events = append(events, event)
+			return limit == 0 || int64(len(events)) < limit, nil
+		})
+	})
+	return events, err
+}
+
+type boltChatMessages struct{ db *bolt.DB }
+
+func (r boltChatMessages) Insert(ctx context.Context, message models.ChatMessage) error {
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
+
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.Bucket(boltChatMessagesBucket).CreateBucketIfNotExists([]byte(message.StreamID))
+		if err != nil {
+			return err
+		}
+		return putBSON(bucket, timelineKey(message.SentAt, message.ID), &message)
+	})
+}
+
+func (r boltChatMessages) List(ctx context.Context, streamID string, limit int64) ([]models.ChatMessage, error) {
+	messages := []models.ChatMessage{}
+
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltChatMessagesBucket).Bucket([]byte(streamID))
+		if bucket == nil {
+			return nil
+		}
+
+		// Walk back from the latest message, then restore chronological order
+		cursor := bucket.Cursor()
+		for key, value := cursor.Last(); key != nil && (limit == 0 || int64(len(messages)) < limit); key, value = cursor.Prev() {
+			var message models.ChatMessage
+			if err := bson.Unmarshal(value, &message); err != nil {
+				return err
+			}
+			messages = append(messages, message)
+		}
+		slices.Reverse(messages)
+		return nil
+	})
+	return messages, err
+}
+
+// timelineKey orders records by timestamp, with the ID keeping keys unique
+func timelineKey(timestamp time.Time, id primitive.ObjectID) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(timestamp.UnixNano()))
+	return append(key, id[:]...)
+}
+
+// scanTimeline calls fn with each value of a stream's nested bucket between from and to
+// (inclusive, zero for unbounded) in timestamp order, until fn returns false
+func scanTimeline(parent *bolt.Bucket, streamID string, from, to time.Time, fn func(value []byte) (bool, error)) error {
+	bucket := parent.Bucket([]byte(streamID))
+	if bucket == nil {
+		return nil
+	}
+
+	cursor := bucket.Cursor()
+
+	var key, value []byte
+	if from.IsZero() {
+		key, value = cursor.First()
+	} else {
+		key, value = cursor.Seek(timelineKey(from, primitive.NilObjectID))
+	}
+
+	var upper []byte
+	if !to.IsZero() {
+		// Sorts after every key with the same timestamp
+		upper = timelineKey(to, primitive.ObjectID{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	}
+
+	for ; key != nil; key, value = cursor.Next() {
+		if upper != nil && bytes.Compare(key, upper) > 0 {
+			return nil
+		}
+
+		more, err := fn(value)
+		if err != nil || !more {
+			return err
+		}
+	}
+	return nil
+}
+
+func putBSON(bucket *bolt.Bucket, key []byte, value interface{}) error {
+	data, err := bson.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// getBSON decodes the value stored at key, returning ErrNotFound if there is none
+func getBSON(bucket *bolt.Bucket, key []byte, value interface{}) error {
+	data := bucket.Get(key)
+	if data == nil {
+		return ErrNotFound
+	}
+	return bson.Unmarshal(data, value)
+}
@@ -0,0 +1,369 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"velocity-be/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Memory is an in-process Store. Data is lost when the process exits, which makes
+// it suited to lightweight deployments and tests.
+type Memory struct {
+	streams      map[string]models.Stream
+	joinLogs     map[primitive.ObjectID]models.StreamJoinLog
+	featureFlags *models.FeatureFlags
+	trackPoints  map[string][]models.TrackPoint  // By stream ID, sorted by timestamp
+	streamEvents map[string][]models.StreamEvent // By stream ID, sorted by timestamp
+	chatMessages map[string][]models.ChatMessage // By stream ID, sorted by sent time
+	mu           sync.RWMutex
+}
+
+// NewMemory creates a new empty in-memory Store
+func NewMemory() *Memory {
+	return &Memory{
+		streams:      make(map[string]models.Stream),
+		joinLogs:     make(map[primitive.ObjectID]models.StreamJoinLog),
+		trackPoints:  make(map[string][]models.TrackPoint),
+		streamEvents: make(map[string][]models.StreamEvent),
+		chatMessages: make(map[string][]models.ChatMessage),
+	}
+}
+
+func (m *Memory) Streams() StreamRepository           { return memoryStreams{m} }
+func (m *Memory) JoinLogs() JoinLogRepository         { return memoryJoinLogs{m} }
+func (m *Memory) FeatureFlags() FeatureFlagRepository { return memoryFeatureFlags{m} }
+func (m *Memory) TrackPoints() TrackPointRepository   { return memoryTrackPoints{m} }
+func (m *Memory) StreamEvents() StreamEventRepository { return memoryStreamEvents{m} }
+func (m *Memory) ChatMessages() ChatMessageRepository { return memoryChatMessages{m} }
+
+// Close is a no-op
+func (m *Memory) Close() error {
+	return nil
+}
+
+type memoryStreams struct{ m *Memory }
+
+func (r memoryStreams) Create(ctx context.Context, stream *models.Stream) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if stream.ID.IsZero() {
+		stream.ID = primitive.NewObjectID()
+	}
+	r.m.streams[stream.StreamID] = *stream
+	return nil
+}
+
+func (r memoryStreams) Get(ctx context.Context, streamID string) (*models.Stream, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+
+	stream, exists := r.m.streams[streamID]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return &stream, nil
+}
+
+func (r memoryStreams) MarkDeleted(ctx context.Context, streamID string, deletedAt time.Time, autoCancelled bool) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		markDeleted(stream, deletedAt, autoCancelled)
+	})
+}
+
+func (r memoryStreams) UpdateLatestData(ctx context.Context, streamID string, data models.StreamData) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.LatestData = &data
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r memoryStreams) UpdateLastConnection(ctx context.Context, streamID string, connectedAt time.Time) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.LastConnectionAt = &connectedAt
+	})
+}
+
+func (r memoryStreams) UpdateGeofences(ctx context.Context, streamID string, fences []models.Geofence) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.Geofences = fences
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r memoryStreams) AddShareToken(ctx context.Context, streamID string, token models.ShareToken) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.ShareTokens = append(stream.ShareTokens, token)
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r memoryStreams) RevokeShareToken(ctx context.Context, streamID, tokenID string, revokedAt time.Time) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		revokeShareToken(stream, tokenID, revokedAt)
+	})
+}
+
+func (r memoryStreams) AddBan(ctx context.Context, streamID string, ban models.ViewerBan) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.Bans = append(stream.Bans, ban)
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r memoryStreams) AddMute(ctx context.Context, streamID string, mute models.ViewerMute) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.Mutes = append(stream.Mutes, mute)
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r memoryStreams) RemoveMute(ctx context.Context, streamID, ipAddress string) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		removeMute(stream, ipAddress)
+		stream.UpdatedAt = time.Now()
+	})
+}
+
+func (r memoryStreams) SaveSummary(ctx context.Context, streamID string, summary models.TripSummary) error {
+	return r.update(streamID, func(stream *models.Stream) {
+		stream.Summary = &summary
+	})
+}
+
+func (r memoryStreams) CountActiveByCreator(ctx context.Context, creatorKey string) (int64, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+
+	var count int64
+	for _, stream := range r.m.streams {
+		if isActiveByCreator(&stream, creatorKey) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r memoryStreams) ListInactive(ctx context.Context, cutoff time.Time) ([]models.Stream, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+
+	var streams []models.Stream
+	for _, stream := range r.m.streams {
+		if isInactive(&stream, cutoff) {
+			streams = append(streams, stream)
+		}
+	}
+	return streams, nil
+}
+
+// update applies fn to a stored stream. Like a MongoDB update, a missing stream is not an error.
+func (r memoryStreams) update(streamID string, fn func(stream *models.Stream)) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	stream, exists := r.m.streams[streamID]
+	if !exists {
+		return nil
+	}
+	fn(&stream)
+	r.m.streams[streamID] = stream
+	return nil
+}
+
+type memoryJoinLogs struct{ m *Memory }
+
+func (r memoryJoinLogs) Create(ctx context.Context, joinLog *models.StreamJoinLog) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if joinLog.ID.IsZero() {
+		joinLog.ID = primitive.NewObjectID()
+	}
+	r.m.joinLogs[joinLog.ID] = *joinLog
+	return nil
+}
+
+func (r memoryJoinLogs) MarkLeft(ctx context.Context, id primitive.ObjectID, leftAt time.Time, reason string) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	joinLog, exists := r.m.joinLogs[id]
+	if !exists {
+		return nil
+	}
+	joinLog.LeftAt = &leftAt
+	joinLog.LeaveReason = reason
+	r.m.joinLogs[id] = joinLog
+	return nil
+}
+
+func (r memoryJoinLogs) List(ctx context.Context, streamID string) ([]models.StreamJoinLog, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+
+	joinLogs := []models.StreamJoinLog{}
+	for _, joinLog := range r.m.joinLogs {
+		if joinLog.StreamID == streamID {
+			joinLogs = append(joinLogs, joinLog)
+		}
+	}
+	return joinLogs, nil
+}
+
+type memoryFeatureFlags struct{ m *Memory }
+
+func (r memoryFeatureFlags) Get(ctx context.Context) (*models.FeatureFlags, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+
+	if r.m.featureFlags == nil {
+		return nil, ErrNotFound
+	}
+	flags := *r.m.featureFlags
+	return &flags, nil
+}
+
+func (r memoryFeatureFlags) Save(ctx context.Context, flags *models.FeatureFlags) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	saved := *flags
+	r.m.featureFlags = &saved
+	return nil
+}
+
+type memoryTrackPoints struct{ m *Memory }
+
+func (r memoryTrackPoints) Insert(ctx context.Context, point models.TrackPoint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.insert(point)
+	return nil
+}
+
+func (r memoryTrackPoints) InsertMany(ctx context.Context, points []models.TrackPoint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, point := range points {
+		r.insert(point)
+	}
+	return nil
+}
+
+// insert adds a point to its stream's sorted points. Callers must hold r.m.mu.
+func (r memoryTrackPoints) insert(point models.TrackPoint) {
+	if point.ID.IsZero() {
+		point.ID = primitive.NewObjectID()
+	}
+
+	// Keep points sorted, frames usually arrive in order so this appends
+	points := r.m.trackPoints[point.StreamID]
+	i := sort.Search(len(points), func(i int) bool {
+		return points[i].Timestamp.After(point.Timestamp)
+	})
+	points = append(points, models.TrackPoint{})
+	copy(points[i+1:], points[i:])
+	points[i] = point
+	r.m.trackPoints[point.StreamID] = points
+}
+
+func (r memoryTrackPoints) List(ctx context.Context, query TrackQuery) ([]models.TrackPoint, error) {
+	matching := r.matching(query)
+	start, end := query.page(len(matching))
+	return matching[start:end], nil
+}
+
+func (r memoryTrackPoints) Count(ctx context.Context, query TrackQuery) (int64, error) {
+	return int64(len(r.matching(query))), nil
+}
+
+func (r memoryTrackPoints) matching(query TrackQuery) []models.TrackPoint {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+
+	points := []models.TrackPoint{}
+	for _, point := range r.m.trackPoints[query.StreamID] {
+		if query.matches(point.Timestamp) {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+type memoryStreamEvents struct{ m *Memory }
+
+func (r memoryStreamEvents) Insert(ctx context.Context, event models.StreamEvent) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if event.ID.IsZero() {
+		event.ID = primitive.NewObjectID()
+	}
+
+	events := r.m.streamEvents[event.StreamID]
+	i := sort.Search(len(events), func(i int) bool {
+		return events[i].Timestamp.After(event.Timestamp)
+	})
+	events = append(events, models.StreamEvent{})
+	copy(events[i+1:], events[i:])
+	events[i] = event
+	r.m.streamEvents[event.StreamID] = events
+	return nil
+}
+
+func (r memoryStreamEvents) List(ctx context.Context, streamID string, limit int64) ([]models.StreamEvent, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+
+	stored := r.m.streamEvents[streamID]
+	if limit > 0 && int64(len(stored)) > limit {
+		stored = stored[:limit]
+	}
+
+	events := make([]models.StreamEvent, len(stored))
+	copy(events, stored)
+	return events, nil
+}
+
+type memoryChatMessages struct{ m *Memory }
+
+func (r memoryChatMessages) Insert(ctx context.Context, message models.ChatMessage) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if message.ID.IsZero() {
+		message.ID = primitive.NewObjectID()
+	}
+
+	messages := r.m.chatMessages[message.StreamID]
+	i := sort.Search(len(messages), func(i int) bool {
+		return messages[i].SentAt.After(message.SentAt)
+	})
+	messages = append(messages, models.ChatMessage{})
+	copy(messages[i+1:], messages[i:])
+	messages[i] = message
+	r.m.chatMessages[message.StreamID] = messages
+	return nil
+}
+
+func (r memoryChatMessages) List(ctx context.Context, streamID string, limit int64) ([]models.ChatMessage, error) {
+	r.m.mu.RLock()
+	defer r.m.mu.RUnlock()
+
+	stored := r.m.chatMessages[streamID]
+	if limit > 0 && int64(len(stored)) > limit {
+		stored = stored[int64(len(stored))-limit:]
+	}
+
+	messages := make([]models.ChatMessage, len(stored))
+	copy(messages, stored)
+	return messages, nil
+}
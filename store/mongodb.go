@@ -0,0 +1,368 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"velocity-be/db"
+	"velocity-be/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoDB is a Store backed by the MongoDB collections of the db package.
+// db.Connect must be called before use.
+type MongoDB struct{}
+
+// NewMongoDB creates a Store using the connected MongoDB database
+func NewMongoDB() *MongoDB {
+	return &MongoDB{}
+}
+
+func (m *MongoDB) Streams() StreamRepository           { return mongoStreams{} }
+func (m *MongoDB) JoinLogs() JoinLogRepository         { return mongoJoinLogs{} }
+func (m *MongoDB) FeatureFlags() FeatureFlagRepository { return mongoFeatureFlags{} }
+func (m *MongoDB) TrackPoints() TrackPointRepository   { return mongoTrackPoints{} }
+func (m *MongoDB) StreamEvents() StreamEventRepository { return mongoStreamEvents{} }
+func (m *MongoDB) ChatMessages() ChatMessageRepository { return mongoChatMessages{} }
+
+// Close is a no-op, the connection is owned by the db package
+func (m *MongoDB) Close() error {
+	return nil
+}
+
+type mongoStreams struct{}
+
+func (mongoStreams) Create(ctx context.Context, stream *models.Stream) error {
+	_, err := db.StreamsCollection().InsertOne(ctx, stream)
+	return err
+}
+
+func (mongoStreams) Get(ctx context.Context, streamID string) (*models.Stream, error) {
+	var stream models.Stream
+	err := db.StreamsCollection().FindOne(ctx, bson.M{"streamId": streamID}).Decode(&stream)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stream, nil
+}
+
+func (mongoStreams) MarkDeleted(ctx context.Context, streamID string, deletedAt time.Time, autoCancelled bool) error {
+	set := bson.M{
+		"deletedAt": deletedAt,
+		"isActive":  false,
+		"updatedAt": deletedAt,
+	}
+	if autoCancelled {
+		set["autoCancelled"] = true
+	}
+	return updateStream(ctx, streamID, set)
+}
+
+func (mongoStreams) UpdateLatestData(ctx context.Context, streamID string, data models.StreamData) error {
+	return updateStream(ctx, streamID, bson.M{
+		"latestData": data,
+		"updatedAt":  time.Now(),
+	})
+}
+
+func (mongoStreams) UpdateLastConnection(ctx context.Context, streamID string, connectedAt time.Time) error {
+	return updateStream(ctx, streamID, bson.M{"lastConnectionAt": connectedAt})
+}
+
+func (mongoStreams) UpdateGeofences(ctx context.Context, streamID string, fences []models.Geofence) error {
+	return updateStream(ctx, streamID, bson.M{
+		"geofences": fences,
+		"updatedAt": time.Now(),
+	})
+}
+
+func (mongoStreams) AddShareToken(ctx context.Context, streamID string, token models.ShareToken) error {
+	_, err := db.StreamsCollection().UpdateOne(
+		ctx,
+		bson.M{"streamId": streamID},
+		bson.M{
+			"$push": bson.M{"shareTokens": token},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+	)
+	return err
+}
+
+func (mongoStreams) RevokeShareToken(ctx context.Context, streamID, tokenID string, revokedAt time.Time) error {
+	_, err := db.StreamsCollection().UpdateOne(
+		ctx,
+		bson.M{
+			"streamId":    streamID,
+			"shareTokens": bson.M{"$elemMatch": bson.M{"id": tokenID, "revokedAt": nil}},
+		},
+		bson.M{"$set": bson.M{"shareTokens.$.revokedAt": revokedAt}},
+	)
+	return err
+}
+
+func (mongoStreams) AddBan(ctx context.Context, streamID string, ban models.ViewerBan) error {
+	_, err := db.StreamsCollection().UpdateOne(
+		ctx,
+		bson.M{"streamId": streamID},
+		bson.M{
+			"$push": bson.M{"bans": ban},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+	)
+	return err
+}
+
+func (mongoStreams) AddMute(ctx context.Context, streamID string, mute models.ViewerMute) error {
+	_, err := db.StreamsCollection().UpdateOne(
+		ctx,
+		bson.M{"streamId": streamID},
+		bson.M{
+			"$push": bson.M{"mutes": mute},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+	)
+	return err
+}
+
+func (mongoStreams) RemoveMute(ctx context.Context, streamID, ipAddress string) error {
+	_, err := db.StreamsCollection().UpdateOne(
+		ctx,
+		bson.M{"streamId": streamID},
+		bson.M{
+			"$pull": bson.M{"mutes": bson.M{"ipAddress": ipAddress}},
+			"$set":  bson.M{"updatedAt": time.Now()},
+		},
+	)
+	return err
+}
+
+func (mongoStreams) SaveSummary(ctx context.Context, streamID string, summary models.TripSummary) error {
+	return updateStream(ctx, streamID, bson.M{"summary": summary})
+}
+
+func (mongoStreams) CountActiveByCreator(ctx context.Context, creatorKey string) (int64, error) {
+	return db.StreamsCollection().CountDocuments(ctx, bson.M{
+		"$or":       bson.A{bson.M{"creatorKey": creatorKey}, bson.M{"creatorTokenKey": creatorKey}},
+		"isActive":  true,
+		"deletedAt": nil,
+	})
+}
+
+func (mongoStreams) ListInactive(ctx context.Context, cutoff time.Time) ([]models.Stream, error) {
+	// Find streams that:
+	// 1. Are still active (isActive: true)
+	// 2. Haven't been manually deleted (deletedAt: null)
+	// 3. Have lastConnectionAt older than the cutoff OR lastConnectionAt is null and createdAt is older than the cutoff
+	filter := bson.M{
+		"isActive":  true,
+		"deletedAt": nil,
+		"$or": []bson.M{
+			{"lastConnectionAt": bson.M{"$lt": cutoff}},
+			{
+				"lastConnectionAt": nil,
+				"createdAt":        bson.M{"$lt": cutoff},
+			},
+		},
+	}
+
+	cursor, err := db.StreamsCollection().Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var streams []models.Stream
+	if err := cursor.All(ctx, &streams); err != nil {
+		return nil, err
+	}
+	return streams, nil
+}
+
+func updateStream(ctx context.Context, streamID string, set bson.M) error {
+	_, err := db.StreamsCollection().UpdateOne(
+		ctx,
+		bson.M{"streamId": streamID},
+		bson.M{"$set": set},
+	)
+	return err
+}
+
+type mongoJoinLogs struct{}
+
+func (mongoJoinLogs) Create(ctx context.Context, joinLog *models.StreamJoinLog) error {
+	if joinLog.ID.IsZero() {
+		joinLog.ID = primitive.NewObjectID()
+	}
+	_, err := db.StreamJoinLogsCollection().InsertOne(ctx, joinLog)
+	return err
+}
+
+func (mongoJoinLogs) MarkLeft(ctx context.Context, id primitive.ObjectID, leftAt time.Time, reason string) error {
+	set := bson.M{"leftAt": leftAt}
+	if reason != "" {
+		set["leaveReason"] = reason
+	}
+	_, err := db.StreamJoinLogsCollection().UpdateOne(
+		ctx,
+		bson.M{"_id": id},
+		bson.M{"$set": set},
+	)
+	return err
+}
+
+func (mongoJoinLogs) List(ctx context.Context, streamID string) ([]models.StreamJoinLog, error) {
+	cursor, err := db.StreamJoinLogsCollection().Find(ctx, bson.M{"streamId": streamID})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	joinLogs := []models.StreamJoinLog{}
+	if err := cursor.All(ctx, &joinLogs); err != nil {
+		return nil, err
+	}
+	return joinLogs, nil
+}
+
+type mongoFeatureFlags struct{}
+
+func (mongoFeatureFlags) Get(ctx context.Context) (*models.FeatureFlags, error) {
+	var flags models.FeatureFlags
+	err := db.FeatureFlagsCollection().FindOne(ctx, bson.M{}).Decode(&flags)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &flags, nil
+}
+
+func (mongoFeatureFlags) Save(ctx context.Context, flags *models.FeatureFlags) error {
+	// There is a single feature flags document
+	_, err := db.FeatureFlagsCollection().ReplaceOne(ctx, bson.M{}, flags, options.Replace().SetUpsert(true))
+	return err
+}
+
+type mongoTrackPoints struct{}
+
+func (mongoTrackPoints) Insert(ctx context.Context, point models.TrackPoint) error {
+	_, err := db.TrackPointsCollection().InsertOne(ctx, point)
+	return err
+}
+
+func (mongoTrackPoints) InsertMany(ctx context.Context, points []models.TrackPoint) error {
+	if len(points) == 0 {
+		return nil
+	}
+
+	documents := make([]interface{}, len(points))
+	for i := range points {
+		documents[i] = points[i]
+	}
+	_, err := db.TrackPointsCollection().InsertMany(ctx, documents)
+	return err
+}
+
+func (mongoTrackPoints) List(ctx context.Context, query TrackQuery) ([]models.TrackPoint, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}}).
+		SetSkip(query.Offset)
+	if query.Limit > 0 {
+		findOptions.SetLimit(query.Limit)
+	}
+
+	cursor, err := db.TrackPointsCollection().Find(ctx, trackFilter(query), findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	points := []models.TrackPoint{}
+	if err := cursor.All(ctx, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+func (mongoTrackPoints) Count(ctx context.Context, query TrackQuery) (int64, error) {
+	return db.TrackPointsCollection().CountDocuments(ctx, trackFilter(query))
+}
+
+func trackFilter(query TrackQuery) bson.M {
+	filter := bson.M{"streamId": query.StreamID}
+
+	timeRange := bson.M{}
+	if !query.From.IsZero() {
+		timeRange["$gte"] = query.From
+	}
+	if !query.To.IsZero() {
+		timeRange["$lte"] = query.To
+	}
+	if len(timeRange) > 0 {
+		filter["timestamp"] = timeRange
+	}
+
+	return filter
+}
+
+type mongoStreamEvents struct{}
+
+func (mongoStreamEvents) Insert(ctx context.Context, event models.StreamEvent) error {
+	_, err := db.StreamEventsCollection().InsertOne(ctx, event)
+	return err
+}
+
+func (mongoStreamEvents) List(ctx context.Context, streamID string, limit int64) ([]models.StreamEvent, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "timestamp", Value: 1}}).
+		SetLimit(limit)
+
+	cursor, err := db.StreamEventsCollection().Find(ctx, bson.M{"streamId": streamID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	events := []models.StreamEvent{}
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+type mongoChatMessages struct{}
+
+func (mongoChatMessages) Insert(ctx context.Context, message models.ChatMessage) error {
+	_, err := db.ChatMessagesCollection().InsertOne(ctx, message)
+	return err
+}
+
+func (mongoChatMessages) List(ctx context.Context, streamID string, limit int64) ([]models.ChatMessage, error) {
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "sentAt", Value: -1}}).
+		SetLimit(limit)
+
+	cursor, err := db.ChatMessagesCollection().Find(ctx, bson.M{"streamId": streamID}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	messages := []models.ChatMessage{}
+	if err := cursor.All(ctx, &messages); err != nil {
+		return nil, err
+	}
+
+	// Fetched latest first to apply the limit
+	slices.Reverse(messages)
+	return messages, nil
+}
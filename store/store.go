@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"velocity-be/models"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNotFound is returned when the requested record does not exist
+var ErrNotFound = errors.New("store: not found")
+
+// Store gives access to the repositories of a storage backend
+type Store interface {
+	Streams() StreamRepository
+	JoinLogs() JoinLogRepository
+	FeatureFlags() FeatureFlagRepository
+	TrackPoints() TrackPointRepository
+	StreamEvents() StreamEventRepository
+	ChatMessages() ChatMessageRepository
+
+	// Close releases the resources held by the backend
+	Close() error
+}
+
+// StreamRepository persists streams
+type StreamRepository interface {
+	Create(ctx context.Context, stream *models.Stream) error
+
+	// Get returns ErrNotFound if no stream has the given ID
+	Get(ctx context.Context, streamID string) (*models.Stream, error)
+
+	// MarkDeleted soft deletes a stream, flagging it as auto-cancelled if requested
+	MarkDeleted(ctx context.Context, streamID string, deletedAt time.Time, autoCancelled bool) error
+
+	UpdateLatestData(ctx context.Context, streamID string, data models.StreamData) error
+	UpdateLastConnection(ctx context.Context, streamID string, connectedAt time.Time) error
+	UpdateGeofences(ctx context.Context, streamID string, fences []models.Geofence) error
+
+	AddShareToken(ctx context.Context, streamID string, token models.ShareToken) error
+
+	// RevokeShareToken marks a share token of the stream as revoked, if it is not already
+	RevokeShareToken(ctx context.Context, streamID, tokenID string, revokedAt time.Time) error
+
+	// AddBan records a viewer the broadcaster banned from the stream
+	AddBan(ctx context.Context, streamID string, ban models.ViewerBan) error
+
+	// AddMute records a viewer the broadcaster muted, RemoveMute lifts every mute of an IP address
+	AddMute(ctx context.Context, streamID string, mute models.ViewerMute) error
+	RemoveMute(ctx context.Context, streamID, ipAddress string) error
+
+	// SaveSummary stores the trip summary of an ended stream
+	SaveSummary(ctx context.Context, streamID string, summary models.TripSummary) error
+
+	// CountActiveByCreator returns the number of active, non-deleted streams whose CreatorKey or CreatorTokenKey is creatorKey
+	CountActiveByCreator(ctx context.Context, creatorKey string) (int64, error)
+
+	// ListInactive returns active, non-deleted streams whose last connection (or
+	// creation, if nobody ever connected) is older than cutoff
+	ListInactive(ctx context.Context, cutoff time.Time) ([]models.Stream, error)
+}
+
+// JoinLogRepository persists viewer joins
+type JoinLogRepository interface {
+	// Create stores the entry and sets its ID
+	Create(ctx context.Context, joinLog *models.StreamJoinLog) error
+
+	// MarkLeft records when a viewer left, and why it was disconnected if reason is not empty
+	MarkLeft(ctx context.Context, id primitive.ObjectID, leftAt time.Time, reason string) error
+
+	// List returns the join logs of a stream, in no particular order
+	List(ctx context.Context, streamID string) ([]models.StreamJoinLog, error)
+}
+
+// FeatureFlagRepository persists the app's feature flags
+type FeatureFlagRepository interface {
+	// Get returns ErrNotFound if no feature flags were saved
+	Get(ctx context.Context) (*models.FeatureFlags, error)
+
+	Save(ctx context.Context, flags *models.FeatureFlags) error
+}
+
+// TrackPointRepository persists the recorded route of streams
+type TrackPointRepository interface {
+	Insert(ctx context.Context, point models.TrackPoint) error
+
+	// InsertMany inserts a batch of points in a single write
+	InsertMany(ctx context.Context, points []models.TrackPoint) error
+
+	// List returns the points matching the query, oldest first
+	List(ctx context.Context, query TrackQuery) ([]models.TrackPoint, error)
+
+	// Count returns the number of points matching the query, ignoring Offset and Limit
+	Count(ctx context.Context, query TrackQuery) (int64, error)
+}
+
+// TrackQuery selects the track points of a stream
+type TrackQuery struct {
+	StreamID string
+	From     time.Time // Inclusive, zero for no lower bound
+	To       time.Time // Inclusive, zero for no upper bound
+	Offset   int64
+	Limit    int64 // 0 for no limit
+}
+
+// StreamEventRepository persists the event log of streams
+type StreamEventRepository interface {
+	Insert(ctx context.Context, event models.StreamEvent) error
+
+	// List returns up to limit events of a stream, oldest first
+	List(ctx context.Context, streamID string, limit int64) ([]models.StreamEvent, error)
+}
+
+// ChatMessageRepository persists the chat history of streams
+type ChatMessageRepository interface {
+	Insert(ctx context.Context, message models.ChatMessage) error
+
+	// List returns the latest limit messages of a stream, oldest first
+	List(ctx context.Context, streamID string, limit int64) ([]models.ChatMessage, error)
+}
+
+// matches reports whether a point at timestamp falls within the query's time range
+func (q TrackQuery) matches(timestamp time.Time) bool {
+	if !q.From.IsZero() && timestamp.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && timestamp.After(q.To) {
+		return false
+	}
+	return true
+}
+
+// page applies Offset and Limit to the number of matching items
+func (q TrackQuery) page(total int) (start, end int) {
+	start = int(q.Offset)
+	if start > total {
+		start = total
+	}
+	end = total
+	if q.Limit > 0 && start+int(q.Limit) < end {
+		end = start + int(q.Limit)
+	}
+	return start, end
+}
+
+// isInactive reports whether a stream should be auto-cancelled for the given cutoff
+func isInactive(stream *models.Stream, cutoff time.Time) bool {
+	if !stream.IsActive || stream.DeletedAt != nil {
+		return false
+	}
+	if stream.LastConnectionAt != nil {
+		return stream.LastConnectionAt.Before(cutoff)
+	}
+	return stream.CreatedAt.Before(cutoff)
+}
+
+// isActiveByCreator reports whether a stream counts towards the active stream cap of creatorKey
+func isActiveByCreator(stream *models.Stream, creatorKey string) bool {
+	return (stream.CreatorKey == creatorKey || stream.CreatorTokenKey == creatorKey) && stream.IsActive && stream.DeletedAt == nil
+}
+
+// removeMute lifts the mutes of an IP address. The slice is copied as it may be shared
+// with streams previously returned by the memory store.
+func removeMute(stream *models.Stream, ipAddress string) {
+	mutes := make([]models.ViewerMute, 0, len(stream.Mutes))
+	for _, mute := range stream.Mutes {
+		if mute.IPAddress != ipAddress {
+			mutes = append(mutes, mute)
+		}
+	}
+	stream.Mutes = mutes
+}
+
+// revokeShareToken applies a revocation to a stream's share tokens. The slice is copied
+// as it may be shared with streams previously returned by the memory store.
+func revokeShareToken(stream *models.Stream, tokenID string, revokedAt time.Time) {
+	tokens := make([]models.ShareToken, len(stream.ShareTokens))
+	copy(tokens, stream.ShareTokens)
+
+	for i := range tokens {
+		if tokens[i].ID == tokenID && tokens[i].RevokedAt == nil {
+			tokens[i].RevokedAt = &revokedAt
+		}
+	}
+	stream.ShareTokens = tokens
+}
+
+// markDeleted applies a soft delete to a stream
+func markDeleted(stream *models.Stream, deletedAt time.Time, autoCancelled bool) {
+	stream.DeletedAt = &deletedAt
+	stream.IsActive = false
+	stream.UpdatedAt = deletedAt
+	if autoCancelled {
+		stream.AutoCancelled = true
+	}
+}
@@ -1,31 +1,45 @@
 package tests
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"velocity-be/config"
-	"velocity-be/db"
+	"velocity-be/geo"
 	"velocity-be/handlers"
 	"velocity-be/hub"
+	"velocity-be/logging"
+	"velocity-be/metrics"
 	"velocity-be/models"
+	"velocity-be/pubsub"
+	"velocity-be/store"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
-	"github.com/testcontainers/testcontainers-go/modules/mongodb"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vmihailenco/msgpack/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 var (
 	testRouter *gin.Engine
 	testHub    *hub.Hub
+	testStore  store.Store
 )
 
 // TestMain sets up and tears down the test environment
@@ -34,28 +48,13 @@ func TestMain(m *testing.M) {
 	m.Run()
 }
 
-// setupTestEnvironment creates a test MongoDB container and initializes the app
+// setupTestEnvironment initializes the app on a fresh in-memory store, so handler and hub
+// tests run without external services. The MongoDB repositories are tested in mongodb_test.go.
 func setupTestEnvironment(t *testing.T) func() {
-	ctx := context.Background()
-
-	// Start MongoDB container
-	mongoContainer, err := mongodb.Run(ctx, "mongo:7.0")
-	if err != nil {
-		t.Fatalf("Failed to start MongoDB container: %v", err)
-	}
-
-	// Get connection string
-	connectionString, err := mongoContainer.ConnectionString(ctx)
-	if err != nil {
-		t.Fatalf("Failed to get MongoDB connection string: %v", err)
-	}
-
 	// Configure the app
 	config.AppConfig = &config.Config{
 		Port:               "8080",
 		GinMode:            "test",
-		MongoDBURI:         connectionString,
-		MongoDBDatabase:    "velocity_test",
 		CorsAllowedOrigins: []string{"http://localhost:3000"},
 		Env:                "test",
 	}
@@ -63,29 +62,22 @@ func setupTestEnvironment(t *testing.T) func() {
 	// Set Gin to test mode
 	gin.SetMode(gin.TestMode)
 
-	// Connect to MongoDB
-	if err := db.Connect(); err != nil {
-		t.Fatalf("Failed to connect to MongoDB: %v", err)
-	}
-
 	// Create hub
-	testHub = hub.NewHub()
+	testStore = store.NewMemory()
+	testHub = hub.NewHub(testStore)
 	go testHub.Run()
 
 	// Setup router
-	testRouter = setupRouter(testHub)
+	testRouter = setupRouter(testStore, testHub)
 
 	// Return cleanup function
 	return func() {
-		db.Disconnect()
-		if err := mongoContainer.Terminate(ctx); err != nil {
-			t.Logf("Failed to terminate MongoDB container: %v", err)
-		}
+		testStore.Close()
 	}
 }
 
 // setupRouter creates the test router with all routes
-func setupRouter(h *hub.Hub) *gin.Engine {
+func setupRouter(s store.Store, h *hub.Hub) *gin.Engine {
 	router := gin.New()
 	router.Use(gin.Recovery())
 
@@ -97,43 +89,33 @@ func setupRouter(h *hub.Hub) *gin.Engine {
 	// API routes
 	api := router.Group("/api")
 	{
-		api.POST("/streams", handlers.CreateStreamHandler)
-		api.GET("/streams/:streamId", handlers.GetStreamHandler)
-		api.DELETE("/streams/:streamId", handlers.DeleteStreamHandler(h))
-		api.GET("/feature-flags", handlers.GetFeatureFlagsHandler)
+		api.POST("/streams", handlers.CreateStreamHandler(s))
+		api.GET("/streams/:streamId", handlers.GetStreamHandler(s))
+		api.DELETE("/streams/:streamId", handlers.DeleteStreamHandler(s, h))
+		api.GET("/streams/:streamId/track", handlers.GetStreamTrackHandler(s))
+		api.GET("/streams/:streamId/export", handlers.ExportStreamHandler(s))
+		api.PUT("/streams/:streamId/geofences", handlers.UpdateGeofencesHandler(s, h))
+		api.GET("/streams/:streamId/event-log", handlers.GetStreamEventLogHandler(s))
+		api.GET("/streams/:streamId/summary", handlers.GetStreamSummaryHandler(s))
+		api.GET("/streams/:streamId/chat", handlers.GetChatHistoryHandler(s))
+		api.GET("/streams/:streamId/events", handlers.ViewerEventsHandler(s, h))
+		api.POST("/streams/:streamId/share-tokens", handlers.CreateShareTokenHandler(s))
+		api.GET("/streams/:streamId/share-tokens", handlers.ListShareTokensHandler(s))
+		api.DELETE("/streams/:streamId/share-tokens/:tokenId", handlers.RevokeShareTokenHandler(s, h))
+		api.GET("/feature-flags", handlers.GetFeatureFlagsHandler(s))
 	}
 
 	// WebSocket routes
 	ws := router.Group("/ws")
 	{
-		ws.GET("/mobile/:streamId", handlers.MobileWebSocketHandler(h))
-		ws.GET("/viewer/:streamId", handlers.ViewerWebSocketHandler(h))
+		ws.GET("/mobile/:streamId", handlers.MobileWebSocketHandler(s, h))
+		ws.GET("/viewer/:streamId", handlers.ViewerWebSocketHandler(s, h))
+		ws.GET("/replay/:streamId", handlers.ReplayWebSocketHandler(s, h))
 	}
 
 	return router
 }
 
-// cleanupStreams removes all streams from the test database
-func cleanupStreams(t *testing.T) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := db.StreamsCollection().DeleteMany(ctx, bson.M{})
-	if err != nil {
-		t.Logf("Failed to cleanup streams: %v", err)
-	}
-
-	_, err = db.StreamJoinLogsCollection().DeleteMany(ctx, bson.M{})
-	if err != nil {
-		t.Logf("Failed to cleanup stream join logs: %v", err)
-	}
-
-	_, err = db.FeatureFlagsCollection().DeleteMany(ctx, bson.M{})
-	if err != nil {
-		t.Logf("Failed to cleanup feature flags: %v", err)
-	}
-}
-
 // ==================== Health Check Tests ====================
 
 func TestHealthEndpoint(t *testing.T) {
@@ -163,7 +145,6 @@ func TestHealthEndpoint(t *testing.T) {
 func TestCreateStream(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	req, _ := http.NewRequest("POST", "/api/streams", nil)
 	w := httptest.NewRecorder()
@@ -189,12 +170,15 @@ func TestCreateStream(t *testing.T) {
 	if response.Message != "Stream created successfully" {
 		t.Errorf("Expected message 'Stream created successfully', got '%s'", response.Message)
 	}
+
+	if len(response.BroadcasterToken) != 64 {
+		t.Errorf("Expected broadcaster token length of 64, got %d", len(response.BroadcasterToken))
+	}
 }
 
 func TestGetStream(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// First create a stream
 	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
@@ -234,7 +218,6 @@ func TestGetStream(t *testing.T) {
 func TestGetStreamNotFound(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	req, _ := http.NewRequest("GET", "/api/streams/nonexistent-stream-id", nil)
 	w := httptest.NewRecorder()
@@ -255,7 +238,6 @@ func TestGetStreamNotFound(t *testing.T) {
 func TestDeleteStream(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// First create a stream
 	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
@@ -267,6 +249,7 @@ func TestDeleteStream(t *testing.T) {
 
 	// Now delete the stream
 	deleteReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+	deleteReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
 	deleteW := httptest.NewRecorder()
 	testRouter.ServeHTTP(deleteW, deleteReq)
 
@@ -301,7 +284,6 @@ func TestDeleteStream(t *testing.T) {
 func TestDeleteStreamNotFound(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	req, _ := http.NewRequest("DELETE", "/api/streams/nonexistent-stream-id", nil)
 	w := httptest.NewRecorder()
@@ -315,7 +297,6 @@ func TestDeleteStreamNotFound(t *testing.T) {
 func TestDeleteStreamAlreadyDeleted(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// Create a stream
 	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
@@ -327,11 +308,13 @@ func TestDeleteStreamAlreadyDeleted(t *testing.T) {
 
 	// Delete the stream
 	deleteReq1, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+	deleteReq1.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
 	deleteW1 := httptest.NewRecorder()
 	testRouter.ServeHTTP(deleteW1, deleteReq1)
 
 	// Try to delete again
 	deleteReq2, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+	deleteReq2.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
 	deleteW2 := httptest.NewRecorder()
 	testRouter.ServeHTTP(deleteW2, deleteReq2)
 
@@ -347,12 +330,59 @@ func TestDeleteStreamAlreadyDeleted(t *testing.T) {
 	}
 }
 
+func TestDeleteStreamRequiresBroadcasterToken(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	// Create a stream
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Delete without a token
+	noTokenReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+	noTokenW := httptest.NewRecorder()
+	testRouter.ServeHTTP(noTokenW, noTokenReq)
+
+	if noTokenW.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, noTokenW.Code)
+	}
+
+	// Delete with the wrong token
+	wrongTokenReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+	wrongTokenReq.Header.Set("Authorization", "Bearer "+strings.Repeat("0", 64))
+	wrongTokenW := httptest.NewRecorder()
+	testRouter.ServeHTTP(wrongTokenW, wrongTokenReq)
+
+	if wrongTokenW.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, wrongTokenW.Code)
+	}
+
+	// Stream should still be active
+	getReq, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID, nil)
+	getW := httptest.NewRecorder()
+	testRouter.ServeHTTP(getW, getReq)
+
+	var stream models.Stream
+	json.Unmarshal(getW.Body.Bytes(), &stream)
+
+	if !stream.IsActive || stream.DeletedAt != nil {
+		t.Error("Expected stream to remain active after unauthorized delete")
+	}
+
+	if strings.Contains(getW.Body.String(), "broadcasterToken") {
+		t.Error("Expected stream response not to expose broadcaster token data")
+	}
+}
+
 // ==================== Feature Flags Tests ====================
 
 func TestGetFeatureFlagsDefault(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	req, _ := http.NewRequest("GET", "/api/feature-flags", nil)
 	w := httptest.NewRecorder()
@@ -382,7 +412,6 @@ func TestGetFeatureFlagsDefault(t *testing.T) {
 func TestGetFeatureFlagsFromDB(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// Insert feature flags into the database
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -394,7 +423,7 @@ func TestGetFeatureFlagsFromDB(t *testing.T) {
 		EnableCarPlay:       false,
 	}
 
-	_, err := db.FeatureFlagsCollection().InsertOne(ctx, featureFlags)
+	err := testStore.FeatureFlags().Save(ctx, &featureFlags)
 	if err != nil {
 		t.Fatalf("Failed to insert feature flags: %v", err)
 	}
@@ -426,7 +455,6 @@ func TestGetFeatureFlagsFromDB(t *testing.T) {
 func TestMobileWebSocketConnection(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// Create a stream first
 	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
@@ -441,7 +469,7 @@ func TestMobileWebSocketConnection(t *testing.T) {
 	defer server.Close()
 
 	// Connect via WebSocket
-	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
 	ws, resp, err := websocket.DefaultDialer.Dial(wsURL, nil)
 	if err != nil {
 		t.Fatalf("Failed to connect WebSocket: %v", err)
@@ -465,7 +493,6 @@ func TestMobileWebSocketConnection(t *testing.T) {
 func TestViewerWebSocketConnection(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// Create a stream first
 	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
@@ -504,7 +531,6 @@ func TestViewerWebSocketConnection(t *testing.T) {
 func TestWebSocketStreamNotFound(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// Start a test HTTP server
 	server := httptest.NewServer(testRouter)
@@ -527,7 +553,6 @@ func TestWebSocketStreamNotFound(t *testing.T) {
 func TestWebSocketBroadcast(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// Create a stream first
 	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
@@ -542,7 +567,7 @@ func TestWebSocketBroadcast(t *testing.T) {
 	defer server.Close()
 
 	// Connect mobile broadcaster
-	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
 	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
 	if err != nil {
 		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
@@ -560,6 +585,19 @@ func TestWebSocketBroadcast(t *testing.T) {
 	// Give the hub time to register clients
 	time.Sleep(100 * time.Millisecond)
 
+	// Viewer first receives the late-join snapshot
+	viewerWS.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, snapshotMsg, err := viewerWS.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to receive snapshot: %v", err)
+	}
+
+	var snapshot models.WebSocketMessage
+	json.Unmarshal(snapshotMsg, &snapshot)
+	if snapshot.Type != "snapshot" {
+		t.Errorf("Expected message type 'snapshot', got '%s'", snapshot.Type)
+	}
+
 	// Mobile sends stream data
 	streamData := models.WebSocketMessage{
 		Type: "stream_data",
@@ -602,10 +640,165 @@ func TestWebSocketBroadcast(t *testing.T) {
 	}
 }
 
+func TestStreamDataValidation(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	// Create a stream first
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Start a test HTTP server
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer viewerWS.Close()
+
+	readMessageOfType(t, viewerWS, "snapshot")
+
+	// Out-of-range latitude is rejected back to the broadcaster
+	invalid := `{"type":"stream_data","payload":{"currentLocation":{"latitude":200,"longitude":0},"currentSpeedKmh":50}}`
+	if err := mobileWS.WriteMessage(websocket.TextMessage, []byte(invalid)); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	msg := readMessageOfType(t, mobileWS, "error")
+	if !strings.Contains(string(msg), "latitude") {
+		t.Errorf("Expected latitude validation error, got %s", msg)
+	}
+
+	// Valid frames are sanitized before reaching viewers
+	valid := `{"type":"stream_data","payload":{"currentLocation":{"latitude":51.5,"longitude":-0.12},"car":{"name":"  Tesla\u0007 "},"injected":"<script>"}}`
+	if err := mobileWS.WriteMessage(websocket.TextMessage, []byte(valid)); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	// The invalid frame must not have been broadcast, so the next frame is the valid one
+	msg = readMessageOfType(t, viewerWS, "stream_data")
+	var received struct {
+		Payload models.StreamData `json:"payload"`
+	}
+	json.Unmarshal(msg, &received)
+
+	if received.Payload.CurrentLocation.Latitude != 51.5 {
+		t.Errorf("Expected the valid frame, got latitude %v", received.Payload.CurrentLocation.Latitude)
+	}
+
+	if received.Payload.Car.Name != "Tesla" {
+		t.Errorf("Expected sanitized car name 'Tesla', got %q", received.Payload.Car.Name)
+	}
+
+	if strings.Contains(string(msg), "injected") {
+		t.Error("Expected unknown fields to be stripped from broadcast frames")
+	}
+}
+
+func TestViewerSnapshotOnJoin(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	// Create a stream first
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Start a test HTTP server
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	// Connect mobile broadcaster
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Broadcaster sends two frames and then pauses before anyone is watching
+	for _, isPaused := range []bool{false, true} {
+		msgBytes, _ := json.Marshal(models.WebSocketMessage{
+			Type: "stream_data",
+			Payload: models.StreamData{
+				CurrentLocation: models.CurrentLocation{Latitude: 37.7749, Longitude: -122.4194},
+				CurrentSpeedKmh: 30,
+				IsPaused:        isPaused,
+			},
+		})
+		if err := mobileWS.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// A late viewer joins and should immediately receive the snapshot
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer viewerWS.Close()
+
+	viewerWS.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, msg, err := viewerWS.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to receive snapshot: %v", err)
+	}
+
+	var received struct {
+		Type    string                `json:"type"`
+		Payload models.StreamSnapshot `json:"payload"`
+	}
+	if err := json.Unmarshal(msg, &received); err != nil {
+		t.Fatalf("Failed to parse snapshot: %v", err)
+	}
+
+	if received.Type != "snapshot" {
+		t.Fatalf("Expected message type 'snapshot', got '%s'", received.Type)
+	}
+
+	if !received.Payload.BroadcasterConnected {
+		t.Error("Expected snapshot to report the broadcaster as connected")
+	}
+
+	if len(received.Payload.RecentTrack) != 2 {
+		t.Errorf("Expected 2 recent track points, got %d", len(received.Payload.RecentTrack))
+	}
+
+	var latest models.StreamData
+	if err := json.Unmarshal(received.Payload.LatestData, &latest); err != nil {
+		t.Fatalf("Failed to parse snapshot latest data: %v", err)
+	}
+
+	if !latest.IsPaused {
+		t.Error("Expected snapshot latest data to be the last (paused) frame")
+	}
+}
+
 func TestMultipleViewers(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// Create a stream first
 	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
@@ -654,7 +847,6 @@ func TestMultipleViewers(t *testing.T) {
 func TestViewerCountNotification(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// Create a stream first
 	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
@@ -669,7 +861,7 @@ func TestViewerCountNotification(t *testing.T) {
 	defer server.Close()
 
 	// Connect mobile broadcaster
-	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
 	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
 	if err != nil {
 		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
@@ -707,7 +899,6 @@ func TestViewerCountNotification(t *testing.T) {
 func TestDeletedStreamWebSocketRejection(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
 	// Create and delete a stream
 	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
@@ -718,6 +909,7 @@ func TestDeletedStreamWebSocketRejection(t *testing.T) {
 	json.Unmarshal(createW.Body.Bytes(), &createResponse)
 
 	deleteReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+	deleteReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
 	deleteW := httptest.NewRecorder()
 	testRouter.ServeHTTP(deleteW, deleteReq)
 
@@ -739,84 +931,3117 @@ func TestDeletedStreamWebSocketRejection(t *testing.T) {
 	}
 }
 
-// ==================== Stream Uniqueness Tests ====================
-
-func TestStreamIDsAreUnique(t *testing.T) {
+func TestMobileWebSocketRequiresBroadcasterToken(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
 
-	streamIDs := make(map[string]bool)
-	numStreams := 10
+	// Create a stream first
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
 
-	for i := 0; i < numStreams; i++ {
-		createReq, _ := http.NewRequest("POST", "/api/streams", nil)
-		createW := httptest.NewRecorder()
-		testRouter.ServeHTTP(createW, createReq)
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
 
-		var createResponse models.StreamIDResponse
-		json.Unmarshal(createW.Body.Bytes(), &createResponse)
+	// Start a test HTTP server
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
 
-		if streamIDs[createResponse.StreamID] {
-			t.Errorf("Duplicate stream ID generated: %s", createResponse.StreamID)
-		}
-		streamIDs[createResponse.StreamID] = true
+	// Connecting with only the stream ID (e.g. from a share link) must fail
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID
+	_, resp, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err == nil {
+		t.Error("Expected error connecting as broadcaster without a token")
 	}
 
-	if len(streamIDs) != numStreams {
-		t.Errorf("Expected %d unique stream IDs, got %d", numStreams, len(streamIDs))
+	if resp != nil && resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, resp.StatusCode)
 	}
-}
 
-// ==================== Concurrent Access Tests ====================
+	// Connecting with the wrong token must fail
+	_, resp, err = websocket.DefaultDialer.Dial(mobileURL+"?token="+strings.Repeat("0", 64), nil)
+	if err == nil {
+		t.Error("Expected error connecting as broadcaster with an invalid token")
+	}
 
-func TestConcurrentStreamCreation(t *testing.T) {
+	if resp != nil && resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, resp.StatusCode)
+	}
+
+	// The Authorization header is accepted as well
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	ws, _, err := websocket.DefaultDialer.Dial(mobileURL, header)
+	if err != nil {
+		t.Fatalf("Failed to connect with broadcaster token header: %v", err)
+	}
+	ws.Close()
+}
+
+func TestBroadcasterReconnectGracePeriod(t *testing.T) {
 	cleanup := setupTestEnvironment(t)
 	defer cleanup()
-	defer cleanupStreams(t)
-
-	numGoroutines := 20
-	results := make(chan string, numGoroutines)
-	errors := make(chan error, numGoroutines)
 
-	for i := 0; i < numGoroutines; i++ {
-		go func() {
-			createReq, _ := http.NewRequest("POST", "/api/streams", nil)
-			createW := httptest.NewRecorder()
-			testRouter.ServeHTTP(createW, createReq)
+	// Create a stream first
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
 
-			if createW.Code != http.StatusOK {
-				errors <- fmt.Errorf("expected status %d, got %d", http.StatusOK, createW.Code)
-				return
-			}
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
 
-			var createResponse models.StreamIDResponse
-			if err := json.Unmarshal(createW.Body.Bytes(), &createResponse); err != nil {
-				errors <- err
-				return
-			}
+	// Start a test HTTP server
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
 
-			results <- createResponse.StreamID
-		}()
+	// Connect mobile broadcaster and a viewer
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
 	}
 
-	streamIDs := make(map[string]bool)
-	for i := 0; i < numGoroutines; i++ {
-		select {
-		case streamID := <-results:
-			if streamIDs[streamID] {
-				t.Errorf("Duplicate stream ID in concurrent creation: %s", streamID)
-			}
-			streamIDs[streamID] = true
-		case err := <-errors:
-			t.Errorf("Error in concurrent stream creation: %v", err)
-		case <-time.After(10 * time.Second):
-			t.Fatal("Timeout waiting for concurrent stream creation")
-		}
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
 	}
-}
+	defer viewerWS.Close()
 
-// ==================== Helper Functions ====================
+	time.Sleep(100 * time.Millisecond)
+
+	// Broadcaster drops (e.g. entering a tunnel)
+	mobileWS.Close()
+
+	readMessageOfType(t, viewerWS, "broadcaster_reconnecting")
+
+	if count := testHub.GetViewerCount(createResponse.StreamID); count != 1 {
+		t.Errorf("Expected viewer to stay connected during grace period, got %d viewers", count)
+	}
+
+	// Broadcaster comes back and resumes streaming
+	mobileWS, _, err = websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to reconnect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	readMessageOfType(t, viewerWS, "broadcaster_reconnected")
+
+	msgBytes, _ := json.Marshal(models.WebSocketMessage{
+		Type: "stream_data",
+		Payload: models.StreamData{
+			CurrentLocation: models.CurrentLocation{Latitude: 37.7749, Longitude: -122.4194},
+		},
+	})
+	if err := mobileWS.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	readMessageOfType(t, viewerWS, "stream_data")
+}
+
+func TestBroadcasterGracePeriodExpiry(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	testHub.BroadcasterGracePeriod = 300 * time.Millisecond
+
+	// Create a stream first
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Start a test HTTP server
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer viewerWS.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Broadcaster drops and never comes back
+	mobileWS.Close()
+	readMessageOfType(t, viewerWS, "broadcaster_reconnecting")
+
+	time.Sleep(500 * time.Millisecond)
+
+	if count := testHub.GetViewerCount(createResponse.StreamID); count != 0 {
+		t.Errorf("Expected viewers to be disconnected after grace period, got %d", count)
+	}
+}
+
+// ==================== Multi-Instance Tests ====================
+
+func TestMultiInstanceFanOut(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	// Two hubs sharing a pub/sub backend behave like two instances behind a load balancer
+	bus := pubsub.NewMemory()
+	defer bus.Close()
+
+	hubA := hub.NewHubWithPubSub(testStore, bus)
+	go hubA.Run()
+	hubB := hub.NewHubWithPubSub(testStore, bus)
+	go hubB.Run()
+
+	routerA := setupRouter(testStore, hubA)
+	serverA := httptest.NewServer(routerA)
+	defer serverA.Close()
+	serverB := httptest.NewServer(setupRouter(testStore, hubB))
+	defer serverB.Close()
+
+	// Create a stream
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	routerA.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Broadcaster lands on instance A
+	mobileURL := "ws" + strings.TrimPrefix(serverA.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Viewer lands on instance B
+	viewerURL := "ws" + strings.TrimPrefix(serverB.URL, "http") + "/ws/viewer/" + createResponse.StreamID + "?name=Carol"
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer viewerWS.Close()
+
+	// Broadcaster on A is told about the viewer on B
+	msg := readMessageOfType(t, mobileWS, "viewer_count")
+	var countMessage struct {
+		Payload models.ViewerCountUpdate `json:"payload"`
+	}
+	json.Unmarshal(msg, &countMessage)
+	if countMessage.Payload.ViewerCount != 1 || !countMessage.Payload.NewUser {
+		t.Errorf("Expected viewer count 1 with newUser, got %+v", countMessage.Payload)
+	}
+
+	// The broadcaster on A lists the viewer on B, answered by B rather than the join logs
+	listBytes, _ := json.Marshal(models.WebSocketMessage{Type: "list_viewers"})
+	requestedAt := time.Now()
+	if err := mobileWS.WriteMessage(websocket.TextMessage, listBytes); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	var listMessage struct {
+		Payload models.ViewerList `json:"payload"`
+	}
+	json.Unmarshal(readMessageOfType(t, mobileWS, "viewer_list"), &listMessage)
+	if viewers := listMessage.Payload.Viewers; len(viewers) != 1 || viewers[0].DisplayName != "Carol" || viewers[0].ClientID == "" {
+		t.Errorf("Expected Carol from instance B, got %+v", viewers)
+	}
+	if elapsed := time.Since(requestedAt); elapsed > time.Second {
+		t.Errorf("Expected instance B to answer the viewer list promptly, took %v", elapsed)
+	}
+
+	// Frames sent to A reach the viewer on B
+	msgBytes, _ := json.Marshal(models.WebSocketMessage{
+		Type: "stream_data",
+		Payload: models.StreamData{
+			CurrentLocation: models.CurrentLocation{Latitude: 37.7749, Longitude: -122.4194},
+		},
+	})
+	if err := mobileWS.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	readMessageOfType(t, viewerWS, "stream_data")
+
+	// Deleting the stream through A disconnects the viewer on B
+	deleteReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+	deleteReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	routerA.ServeHTTP(httptest.NewRecorder(), deleteReq)
+
+	time.Sleep(200 * time.Millisecond)
+
+	if count := hubB.GetViewerCount(createResponse.StreamID); count != 0 {
+		t.Errorf("Expected viewer on instance B to be disconnected, got %d viewers", count)
+	}
+}
+
+func TestRemoteViewerCountExpires(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	bus := pubsub.NewMemory()
+	defer bus.Close()
+
+	hubA := hub.NewHubWithPubSub(testStore, bus)
+	hubA.ViewerCountHeartbeat = 100 * time.Millisecond
+	go hubA.Run()
+	hubB := hub.NewHubWithPubSub(testStore, bus)
+	hubB.ViewerCountHeartbeat = 100 * time.Millisecond
+	go hubB.Run()
+
+	routerA := setupRouter(testStore, hubA)
+	serverA := httptest.NewServer(routerA)
+	defer serverA.Close()
+	serverB := httptest.NewServer(setupRouter(testStore, hubB))
+	defer serverB.Close()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	routerA.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	mobileURL := "ws" + strings.TrimPrefix(serverA.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// A viewer on the healthy instance B
+	viewerURL := "ws" + strings.TrimPrefix(serverB.URL, "http") + "/ws/viewer/" + createResponse.StreamID + "?name=Carol"
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer viewerWS.Close()
+
+	readViewerCount := func() int {
+		var countMessage struct {
+			Payload models.ViewerCountUpdate `json:"payload"`
+		}
+		json.Unmarshal(readMessageOfType(t, mobileWS, "viewer_count"), &countMessage)
+		return countMessage.Payload.ViewerCount
+	}
+	if count := readViewerCount(); count != 1 {
+		t.Fatalf("Expected viewer count 1, got %d", count)
+	}
+
+	// An instance reports two viewers, then crashes without reporting zero
+	data, _ := json.Marshal(models.ViewerCountUpdate{StreamID: createResponse.StreamID, ViewerCount: 2})
+	bus.Publish(pubsub.Event{Origin: "crashed-instance", Kind: "viewer_count", StreamID: createResponse.StreamID, Data: data})
+
+	if count := readViewerCount(); count != 3 {
+		t.Fatalf("Expected viewer count 3, got %d", count)
+	}
+
+	// After three missed heartbeats only the viewer on B, kept alive by its heartbeats, is counted
+	if count := readViewerCount(); count != 1 {
+		t.Errorf("Expected the crashed instance's viewers to expire, got %d", count)
+	}
+
+	time.Sleep(500 * time.Millisecond)
+
+	// The viewer list no longer waits for the crashed instance to answer
+	listBytes, _ := json.Marshal(models.WebSocketMessage{Type: "list_viewers"})
+	requestedAt := time.Now()
+	if err := mobileWS.WriteMessage(websocket.TextMessage, listBytes); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	var listMessage struct {
+		Payload models.ViewerList `json:"payload"`
+	}
+	json.Unmarshal(readMessageOfType(t, mobileWS, "viewer_list"), &listMessage)
+	if viewers := listMessage.Payload.Viewers; len(viewers) != 1 || viewers[0].DisplayName != "Carol" {
+		t.Errorf("Expected Carol from instance B, got %+v", viewers)
+	}
+	if elapsed := time.Since(requestedAt); elapsed > time.Second {
+		t.Errorf("Expected the viewer list promptly, took %v", elapsed)
+	}
+}
+
+// ==================== Track History Tests ====================
+
+func TestStreamTrackHistory(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	// Create a stream first
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Start a test HTTP server
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	// Connect mobile broadcaster
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Send a short drive
+	locations := []models.CurrentLocation{
+		{Latitude: 51.5074, Longitude: -0.1278},
+		{Latitude: 51.5080, Longitude: -0.1290},
+		{Latitude: 51.5090, Longitude: -0.1300},
+	}
+	for _, location := range locations {
+		msgBytes, _ := json.Marshal(models.WebSocketMessage{
+			Type: "stream_data",
+			Payload: models.StreamData{
+				CurrentLocation: location,
+				CurrentSpeedKmh: 42.0,
+			},
+		})
+		if err := mobileWS.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// Fetch the full track, once the batched track point writes are flushed
+	var track models.TrackResponse
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		req, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/track", nil)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+
+		if err := json.Unmarshal(w.Body.Bytes(), &track); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if track.Total == int64(len(locations)) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if track.Total != int64(len(locations)) || len(track.Points) != len(locations) {
+		t.Fatalf("Expected %d track points, got total=%d points=%d", len(locations), track.Total, len(track.Points))
+	}
+
+	for i, point := range track.Points {
+		if point.Latitude != locations[i].Latitude || point.Longitude != locations[i].Longitude {
+			t.Errorf("Point %d out of order or incorrect: %+v", i, point)
+		}
+	}
+
+	// Fetch a page
+	pageReq, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/track?limit=2&offset=2", nil)
+	pageW := httptest.NewRecorder()
+	testRouter.ServeHTTP(pageW, pageReq)
+
+	var page models.TrackResponse
+	json.Unmarshal(pageW.Body.Bytes(), &page)
+
+	if len(page.Points) != 1 || page.HasMore {
+		t.Errorf("Expected last page with 1 point and no more, got %d points (hasMore=%v)", len(page.Points), page.HasMore)
+	}
+}
+
+func TestStreamTrackInvalidParams(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	for _, query := range []string{"?from=yesterday", "?limit=0", "?offset=-1"} {
+		req, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/track"+query, nil)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %q, got %d", http.StatusBadRequest, query, w.Code)
+		}
+	}
+
+	// Unknown stream
+	req, _ := http.NewRequest("GET", "/api/streams/nonexistent-stream-id/track", nil)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+}
+
+func TestStreamExportFormats(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Seed the stream with latest data and a recorded track
+	updateStreamData(t, createResponse.StreamID, models.StreamData{
+		NavigationData: &models.NavigationData{
+			Polyline: [][]float64{{51.5074, -0.1278}, {51.7520, -1.2577}},
+		},
+		Duration:               1800,
+		MaxSpeedKmh:            120,
+		StartLatitude:          51.5074,
+		StartLongitude:         -0.1278,
+		StartAddressLine:       "123 Main St",
+		StartCity:              "London",
+		DestinationLatitude:    51.7520,
+		DestinationLongitude:   -1.2577,
+		DestinationAddressLine: "456 High St",
+		DestinationCity:        "Oxford",
+		Car:                    models.Car{Name: "Tesla", Model: "Model S"},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now().Add(-time.Minute)
+	for i := 0; i < 3; i++ {
+		err := testStore.TrackPoints().Insert(ctx, models.TrackPoint{
+			StreamID:  createResponse.StreamID,
+			Timestamp: start.Add(time.Duration(i) * 10 * time.Second),
+			Latitude:  51.5074 + float64(i)*0.01,
+			Longitude: -0.1278 - float64(i)*0.01,
+			SpeedKmh:  90,
+		})
+		if err != nil {
+			t.Fatalf("Failed to insert track point: %v", err)
+		}
+	}
+
+	expectations := map[string][]string{
+		"gpx":     {"<gpx", "<trkpt", "<rtept", "Tesla Model S", "123 Main St, London"},
+		"kml":     {"<kml", "<LineString>", "-0.1278,51.5074", "456 High St, Oxford"},
+		"geojson": {`"FeatureCollection"`, `"LineString"`, `"maxSpeedKmh": 120`},
+		"csv": {
+			"# car,Tesla Model S", "# durationSeconds,1800", "# maxSpeedKmh,120",
+			`# start,"123 Main St, London",51.5074,-0.1278`, `# destination,"456 High St, Oxford",51.752,-1.2577`,
+			"# route,51.5074 -0.1278;51.752 -1.2577",
+			"timestamp,latitude,longitude,speedKmh,isPaused", "51.5074,-0.1278,90,false",
+		},
+	}
+
+	for format, fragments := range expectations {
+		req, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/export?format="+format, nil)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusOK, format, w.Code)
+			continue
+		}
+
+		if !strings.Contains(w.Header().Get("Content-Disposition"), "."+format) {
+			t.Errorf("Expected %s attachment, got %q", format, w.Header().Get("Content-Disposition"))
+		}
+
+		for _, fragment := range fragments {
+			if !strings.Contains(w.Body.String(), fragment) {
+				t.Errorf("Expected %s export to contain %q", format, fragment)
+			}
+		}
+	}
+
+	// Free text that spreadsheets would evaluate as a formula is exported as text
+	updateStreamData(t, createResponse.StreamID, models.StreamData{
+		DestinationLatitude:    51.7520,
+		DestinationLongitude:   -1.2577,
+		DestinationAddressLine: "@SUM(A1)",
+		DestinationCity:        "Oxford",
+		Car:                    models.Car{Name: "=HYPERLINK(\"http://evil.example\")", Model: "S"},
+	})
+
+	csvReq, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/export?format=csv", nil)
+	csvW := httptest.NewRecorder()
+	testRouter.ServeHTTP(csvW, csvReq)
+
+	for _, fragment := range []string{
+		`# car,"'=HYPERLINK(""http://evil.example"") S"`,
+		`# destination,"'@SUM(A1), Oxford",51.752,-1.2577`,
+		"51.5074,-0.1278,90,false",
+	} {
+		if !strings.Contains(csvW.Body.String(), fragment) {
+			t.Errorf("Expected csv export to contain %q, got %s", fragment, csvW.Body.String())
+		}
+	}
+
+	// Unsupported format
+	req, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/export?format=shp", nil)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+}
+
+// ==================== Stream Uniqueness Tests ====================
+
+func TestGeofenceEvents(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	// Create a stream with a 500m zone around a waypoint
+	createReq := createJSONRequest(t, "POST", "/api/streams", models.CreateStreamRequest{
+		Geofences: []models.Geofence{
+			{ID: "depot", Name: "Depot", Latitude: 51.5, Longitude: -0.12, RadiusMeters: 500},
+		},
+	})
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	if createW.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, createW.Code, createW.Body.String())
+	}
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Start a test HTTP server
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer viewerWS.Close()
+
+	readMessageOfType(t, viewerWS, "snapshot")
+
+	sendLocation := func(latitude, longitude float64) {
+		message := models.WebSocketMessage{
+			Type: "stream_data",
+			Payload: models.StreamData{
+				CurrentLocation:      models.CurrentLocation{Latitude: latitude, Longitude: longitude},
+				DestinationLatitude:  51.52,
+				DestinationLongitude: -0.12,
+				DestinationName:      "Office",
+			},
+		}
+		if err := mobileWS.WriteJSON(message); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+
+	// Entering the zone
+	sendLocation(51.5, -0.12)
+	msg := readMessageOfType(t, viewerWS, "entered_zone")
+
+	var entered struct {
+		Payload models.StreamEvent `json:"payload"`
+	}
+	json.Unmarshal(msg, &entered)
+	if entered.Payload.ZoneID != "depot" || entered.Payload.ZoneName != "Depot" {
+		t.Errorf("Expected entered_zone for depot, got %+v", entered.Payload)
+	}
+
+	// Leaving the zone, then reaching the destination
+	sendLocation(51.51, -0.12)
+	readMessageOfType(t, viewerWS, "left_zone")
+
+	sendLocation(51.5201, -0.12)
+	msg = readMessageOfType(t, viewerWS, "arrived")
+	if !strings.Contains(string(msg), "Office") {
+		t.Errorf("Expected arrival at Office, got %s", msg)
+	}
+
+	// Events are persisted asynchronously
+	var eventLog struct {
+		Events []models.StreamEvent `json:"events"`
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		req, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/event-log", nil)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+
+		json.Unmarshal(w.Body.Bytes(), &eventLog)
+		if len(eventLog.Events) == 3 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if len(eventLog.Events) != 3 {
+		t.Fatalf("Expected 3 logged events, got %d", len(eventLog.Events))
+	}
+
+	expected := []string{"entered_zone", "left_zone", "arrived"}
+	for i, event := range eventLog.Events {
+		if event.Type != expected[i] {
+			t.Errorf("Expected event %d to be %s, got %s", i, expected[i], event.Type)
+		}
+	}
+}
+
+func TestUpdateGeofences(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	url := "/api/streams/" + createResponse.StreamID + "/geofences"
+	body := models.GeofencesRequest{
+		Geofences: []models.Geofence{
+			{Name: "School", Latitude: 51.5, Longitude: -0.12, RadiusMeters: 200},
+		},
+	}
+
+	// Without the broadcaster token
+	req := createJSONRequest(t, "PUT", url, body)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+
+	// Invalid radius
+	req = createJSONRequest(t, "PUT", url, models.GeofencesRequest{
+		Geofences: []models.Geofence{{Name: "Bad", Latitude: 51.5, Longitude: -0.12, RadiusMeters: -1}},
+	})
+	req.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+
+	// Valid update
+	req = createJSONRequest(t, "PUT", url, body)
+	req.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	w = httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var stream models.Stream
+	getReq, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID, nil)
+	getW := httptest.NewRecorder()
+	testRouter.ServeHTTP(getW, getReq)
+	json.Unmarshal(getW.Body.Bytes(), &stream)
+
+	if len(stream.Geofences) != 1 || stream.Geofences[0].Name != "School" {
+		t.Fatalf("Expected the School geofence to be stored, got %+v", stream.Geofences)
+	}
+
+	if stream.Geofences[0].ID == "" {
+		t.Error("Expected an ID to be assigned to the geofence")
+	}
+}
+
+func TestStreamDataProgress(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Start a test HTTP server
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer viewerWS.Close()
+
+	readMessageOfType(t, viewerWS, "snapshot")
+
+	// Halfway along a ~2.2km route heading north, slightly off to the west.
+	// A progress value sent by the app must be replaced by the server's.
+	bogusEta := 1.0
+	message := models.WebSocketMessage{
+		Type: "stream_data",
+		Payload: models.StreamData{
+			CurrentLocation: models.CurrentLocation{Latitude: 51.51, Longitude: -0.1201},
+			CurrentSpeedKmh: 60,
+			NavigationData: &models.NavigationData{
+				Polyline: [][]float64{{51.5, -0.12}, {51.52, -0.12}},
+			},
+			Progress: &models.RouteProgress{Method: "bogus", EtaSeconds: &bogusEta},
+		},
+	}
+	if err := mobileWS.WriteJSON(message); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	msg := readMessageOfType(t, viewerWS, "stream_data")
+	var received struct {
+		Payload models.StreamData `json:"payload"`
+	}
+	json.Unmarshal(msg, &received)
+
+	progress := received.Payload.Progress
+	if progress == nil {
+		t.Fatalf("Expected progress in broadcast frame, got %s", msg)
+	}
+
+	if progress.Method != "route" {
+		t.Errorf("Expected method 'route', got %q", progress.Method)
+	}
+
+	if progress.RemainingDistanceKm < 1.05 || progress.RemainingDistanceKm > 1.17 {
+		t.Errorf("Expected about 1.11km remaining, got %v", progress.RemainingDistanceKm)
+	}
+
+	if progress.DistanceFromRouteMeters < 5 || progress.DistanceFromRouteMeters > 9 {
+		t.Errorf("Expected about 7m from the route, got %v", progress.DistanceFromRouteMeters)
+	}
+
+	if progress.SnappedLongitude != -0.12 {
+		t.Errorf("Expected snapped longitude -0.12, got %v", progress.SnappedLongitude)
+	}
+
+	if progress.EtaSeconds == nil || *progress.EtaSeconds < 63 || *progress.EtaSeconds > 70 {
+		t.Errorf("Expected an ETA of about 67s at 60km/h, got %v", progress.EtaSeconds)
+	}
+
+	if progress.EstimatedArrival == nil {
+		t.Error("Expected an estimated arrival time")
+	}
+}
+
+func TestOffRouteDetection(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	// Report deviations on the first frame so the test doesn't have to wait
+	testHub.OffRouteDuration = 0
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Start a test HTTP server
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer viewerWS.Close()
+
+	readMessageOfType(t, viewerWS, "snapshot")
+
+	sendLocation := func(latitude, longitude float64) {
+		message := models.WebSocketMessage{
+			Type: "stream_data",
+			Payload: models.StreamData{
+				CurrentLocation: models.CurrentLocation{Latitude: latitude, Longitude: longitude},
+				CurrentSpeedKmh: 50,
+				NavigationData: &models.NavigationData{
+					Polyline: [][]float64{{51.5, -0.12}, {51.52, -0.12}},
+				},
+			},
+		}
+		if err := mobileWS.WriteJSON(message); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+
+	// About 350m west of the route
+	sendLocation(51.51, -0.125)
+	msg := readMessageOfType(t, viewerWS, "off_route")
+
+	var offRoute struct {
+		Payload models.StreamEvent `json:"payload"`
+	}
+	json.Unmarshal(msg, &offRoute)
+	if offRoute.Payload.DistanceFromRouteMeters < 300 {
+		t.Errorf("Expected the distance from the route to be reported, got %v", offRoute.Payload.DistanceFromRouteMeters)
+	}
+
+	// Back on the route
+	sendLocation(51.511, -0.12)
+	readMessageOfType(t, viewerWS, "back_on_route")
+}
+
+func TestPasscodeProtectedStream(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq := createJSONRequest(t, "POST", "/api/streams", models.CreateStreamRequest{
+		Visibility: models.VisibilityPasscode,
+		Passcode:   "4821",
+	})
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	if createW.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, createW.Code, createW.Body.String())
+	}
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	if createResponse.Visibility != models.VisibilityPasscode {
+		t.Errorf("Expected visibility %q, got %q", models.VisibilityPasscode, createResponse.Visibility)
+	}
+
+	streamURL := "/api/streams/" + createResponse.StreamID
+
+	tests := []struct {
+		name     string
+		code     string
+		token    string
+		expected int
+	}{
+		{"no passcode", "", "", http.StatusUnauthorized},
+		{"wrong passcode", "0000", "", http.StatusForbidden},
+		{"correct passcode", "4821", "", http.StatusOK},
+		{"broadcaster token", "", createResponse.BroadcasterToken, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", streamURL, nil)
+			if tt.code != "" {
+				req.Header.Set("X-Access-Code", tt.code)
+			}
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			testRouter.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+
+			if strings.Contains(w.Body.String(), "passcodeHash") {
+				t.Error("Passcode hash must not be exposed")
+			}
+		})
+	}
+
+	// Start a test HTTP server
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+
+	_, resp, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err == nil {
+		t.Fatal("Expected viewer connection without passcode to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d for viewer without passcode", http.StatusUnauthorized)
+	}
+
+	_, resp, err = websocket.DefaultDialer.Dial(viewerURL+"?code=1234", nil)
+	if err == nil {
+		t.Fatal("Expected viewer connection with wrong passcode to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status %d for viewer with wrong passcode", http.StatusForbidden)
+	}
+
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL+"?code=4821", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer with passcode: %v", err)
+	}
+	viewerWS.Close()
+}
+
+func TestMultibytePasscode(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	passcode := "🔑🚗🔑🚗"
+	createReq := createJSONRequest(t, "POST", "/api/streams", models.CreateStreamRequest{
+		Visibility: models.VisibilityPasscode,
+		Passcode:   passcode,
+	})
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	if createW.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, createW.Code, createW.Body.String())
+	}
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	req, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID, nil)
+	req.Header.Set("X-Access-Code", passcode)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the multibyte passcode to be accepted, got %d", w.Code)
+	}
+}
+
+func TestAccessCodeFailuresLimited(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq := createJSONRequest(t, "POST", "/api/streams", models.CreateStreamRequest{
+		Visibility: models.VisibilityPasscode,
+		Passcode:   "4821",
+	})
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	get := func(ip, code string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID, nil)
+		req.RemoteAddr = ip + ":40000"
+		req.Header.Set("X-Access-Code", code)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		return w
+	}
+
+	// Wrong passcodes are refused until the client's quota of failures is used up
+	var w *httptest.ResponseRecorder
+	for attempt := 0; attempt < 20; attempt++ {
+		if w = get("203.0.113.9", fmt.Sprintf("%04d", attempt)); w.Code != http.StatusForbidden {
+			break
+		}
+	}
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected wrong passcodes to be limited with %d and Retry-After, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	// Even the right passcode is refused while limited, as are the other viewer endpoints
+	if w := get("203.0.113.9", "4821"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the right passcode to be limited too, got %d", w.Code)
+	}
+
+	trackReq, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/track?code=4821", nil)
+	trackReq.RemoteAddr = "203.0.113.9:40000"
+	trackW := httptest.NewRecorder()
+	testRouter.ServeHTTP(trackW, trackReq)
+	if trackW.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the track history to be limited, got %d", trackW.Code)
+	}
+
+	// Other clients and the broadcaster are not affected
+	if w := get("203.0.113.10", "4821"); w.Code != http.StatusOK {
+		t.Errorf("Expected another IP to be unaffected, got %d", w.Code)
+	}
+
+	broadcasterReq, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID, nil)
+	broadcasterReq.RemoteAddr = "203.0.113.9:40000"
+	broadcasterReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	broadcasterW := httptest.NewRecorder()
+	testRouter.ServeHTTP(broadcasterW, broadcasterReq)
+	if broadcasterW.Code != http.StatusOK {
+		t.Errorf("Expected the broadcaster token to be unaffected, got %d", broadcasterW.Code)
+	}
+}
+
+func TestInviteListStream(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq := createJSONRequest(t, "POST", "/api/streams", models.CreateStreamRequest{
+		Visibility: models.VisibilityInviteList,
+		Invitees:   []string{"Mum", "Dad"},
+	})
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	if createW.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, createW.Code, createW.Body.String())
+	}
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	if len(createResponse.Invites) != 2 || createResponse.Invites[0].Name != "Mum" || createResponse.Invites[0].Code == "" {
+		t.Fatalf("Expected invite codes for Mum and Dad, got %+v", createResponse.Invites)
+	}
+
+	for _, invite := range createResponse.Invites {
+		req, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/track?code="+invite.Code, nil)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected invite code of %s to be accepted, got %d", invite.Name, w.Code)
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/track?code=notaninvite", nil)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+	}
+}
+
+func TestCreateStreamInvalidVisibility(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	tests := []struct {
+		name string
+		body models.CreateStreamRequest
+	}{
+		{"unknown mode", models.CreateStreamRequest{Visibility: "friends"}},
+		{"short passcode", models.CreateStreamRequest{Visibility: models.VisibilityPasscode, Passcode: "12"}},
+		{"passcode over 72 bytes", models.CreateStreamRequest{Visibility: models.VisibilityPasscode, Passcode: strings.Repeat("é", 40)}},
+		{"no invitees", models.CreateStreamRequest{Visibility: models.VisibilityInviteList}},
+		{"public with passcode", models.CreateStreamRequest{Passcode: "4821"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createJSONRequest(t, "POST", "/api/streams", tt.body)
+			w := httptest.NewRecorder()
+			testRouter.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+}
+
+// mintShareToken creates a share token for a stream with the broadcaster token
+func mintShareToken(t *testing.T, streamID, broadcasterToken string, body models.CreateShareTokenRequest) models.ShareTokenResponse {
+	t.Helper()
+	req := createJSONRequest(t, "POST", "/api/streams/"+streamID+"/share-tokens", body)
+	req.Header.Set("Authorization", "Bearer "+broadcasterToken)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d minting share token, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var response models.ShareTokenResponse
+	json.Unmarshal(w.Body.Bytes(), &response)
+	return response
+}
+
+func TestShareTokens(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq := createJSONRequest(t, "POST", "/api/streams", models.CreateStreamRequest{
+		Visibility: models.VisibilityPasscode,
+		Passcode:   "4821",
+	})
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	live := mintShareToken(t, createResponse.StreamID, createResponse.BroadcasterToken, models.CreateShareTokenRequest{Name: "Mum"})
+	history := mintShareToken(t, createResponse.StreamID, createResponse.BroadcasterToken, models.CreateShareTokenRequest{
+		Scope:            models.ShareScopeLiveHistory,
+		ExpiresInSeconds: 3600,
+	})
+
+	if live.Scope != models.ShareScopeLive || live.Name != "Mum" || live.Token == "" {
+		t.Errorf("Expected a live token for Mum, got %+v", live)
+	}
+
+	if ttl := live.ExpiresAt.Sub(live.CreatedAt); ttl != models.DefaultShareTokenTTL {
+		t.Errorf("Expected default expiry of %v, got %v", models.DefaultShareTokenTTL, ttl)
+	}
+
+	if ttl := history.ExpiresAt.Sub(history.CreatedAt); ttl != time.Hour {
+		t.Errorf("Expected expiry of 1h, got %v", ttl)
+	}
+
+	// A token signed for one stream can't be used on another
+	otherReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	otherW := httptest.NewRecorder()
+	testRouter.ServeHTTP(otherW, otherReq)
+	var other models.StreamIDResponse
+	json.Unmarshal(otherW.Body.Bytes(), &other)
+
+	streamURL := "/api/streams/" + createResponse.StreamID
+	tests := []struct {
+		name     string
+		url      string
+		token    string
+		expected int
+	}{
+		{"live token on stream", streamURL, live.Token, http.StatusOK},
+		{"live token on track", streamURL + "/track", live.Token, http.StatusForbidden},
+		{"history token on track", streamURL + "/track", history.Token, http.StatusOK},
+		{"history token on event log", streamURL + "/event-log", history.Token, http.StatusOK},
+		{"tampered token", streamURL, live.Token + "x", http.StatusForbidden},
+		{"malformed token", streamURL, "not-a-token", http.StatusForbidden},
+		{"token of another stream", "/api/streams/" + other.StreamID, live.Token, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			req.Header.Set("X-Share-Token", tt.token)
+			w := httptest.NewRecorder()
+			testRouter.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d: %s", tt.expected, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// The broadcaster can list tokens, without the tokens themselves
+	listReq, _ := http.NewRequest("GET", streamURL+"/share-tokens", nil)
+	listReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	listW := httptest.NewRecorder()
+	testRouter.ServeHTTP(listW, listReq)
+
+	var list models.ShareTokensResponse
+	json.Unmarshal(listW.Body.Bytes(), &list)
+
+	if listW.Code != http.StatusOK || len(list.ShareTokens) != 2 {
+		t.Errorf("Expected 2 share tokens, got %d (status %d)", len(list.ShareTokens), listW.Code)
+	}
+
+	if strings.Contains(listW.Body.String(), live.Token) {
+		t.Error("Share tokens must not be listed")
+	}
+
+	// Viewers can't mint tokens
+	mintReq := createJSONRequest(t, "POST", streamURL+"/share-tokens", nil)
+	mintReq.Header.Set("X-Share-Token", history.Token)
+	mintW := httptest.NewRecorder()
+	testRouter.ServeHTTP(mintW, mintReq)
+
+	if mintW.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status %d minting without broadcaster token, got %d", http.StatusUnauthorized, mintW.Code)
+	}
+}
+
+func TestCreateShareTokenInvalid(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	tests := []struct {
+		name string
+		body models.CreateShareTokenRequest
+	}{
+		{"unknown scope", models.CreateShareTokenRequest{Scope: "everything"}},
+		{"too short", models.CreateShareTokenRequest{ExpiresInSeconds: 10}},
+		{"too long", models.CreateShareTokenRequest{ExpiresInSeconds: int64(models.MaxShareTokenTTL.Seconds()) + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := createJSONRequest(t, "POST", "/api/streams/"+createResponse.StreamID+"/share-tokens", tt.body)
+			req.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+			w := httptest.NewRecorder()
+			testRouter.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+		})
+	}
+
+	// Public streams can be watched without a token, so none is minted for them
+	req := createJSONRequest(t, "POST", "/api/streams/"+createResponse.StreamID+"/share-tokens", nil)
+	req.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+
+	if w.Code != http.StatusConflict {
+		t.Errorf("Expected status %d minting a token for a public stream, got %d", http.StatusConflict, w.Code)
+	}
+}
+
+func TestRevokeShareTokenDisconnectsViewers(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq := createJSONRequest(t, "POST", "/api/streams", models.CreateStreamRequest{
+		Visibility: models.VisibilityPasscode,
+		Passcode:   "4821",
+	})
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	revoked := mintShareToken(t, createResponse.StreamID, createResponse.BroadcasterToken, models.CreateShareTokenRequest{Name: "Mum"})
+	kept := mintShareToken(t, createResponse.StreamID, createResponse.BroadcasterToken, models.CreateShareTokenRequest{Name: "Dad"})
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID + "?share="
+
+	revokedWS, _, err := websocket.DefaultDialer.Dial(viewerURL+revoked.Token, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer with share token: %v", err)
+	}
+	defer revokedWS.Close()
+
+	keptWS, _, err := websocket.DefaultDialer.Dial(viewerURL+kept.Token, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer with share token: %v", err)
+	}
+	defer keptWS.Close()
+
+	readMessageOfType(t, revokedWS, "snapshot")
+	readMessageOfType(t, keptWS, "snapshot")
+
+	revokeReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID+"/share-tokens/"+revoked.ID, nil)
+	revokeReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	revokeW := httptest.NewRecorder()
+	testRouter.ServeHTTP(revokeW, revokeReq)
+
+	if revokeW.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, revokeW.Code, revokeW.Body.String())
+	}
+
+	msg := readMessageOfType(t, revokedWS, "access_revoked")
+	var received struct {
+		Payload models.ShareAccessEnded `json:"payload"`
+	}
+	json.Unmarshal(msg, &received)
+
+	if received.Payload.ShareTokenID != revoked.ID {
+		t.Errorf("Expected revoked token ID %s, got %s", revoked.ID, received.Payload.ShareTokenID)
+	}
+
+	// The connection is closed after the notice
+	revokedWS.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err := revokedWS.ReadMessage(); err == nil {
+		t.Error("Expected the revoked viewer to be disconnected")
+	}
+
+	// Only the revoked viewer is disconnected
+	deadline := time.Now().Add(3 * time.Second)
+	for testHub.GetViewerCount(createResponse.StreamID) != 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if count := testHub.GetViewerCount(createResponse.StreamID); count != 1 {
+		t.Errorf("Expected 1 viewer left, got %d", count)
+	}
+
+	// The revoked token can't be used again
+	_, resp, err := websocket.DefaultDialer.Dial(viewerURL+revoked.Token, nil)
+	if err == nil {
+		t.Fatal("Expected viewer connection with revoked share token to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status %d for revoked share token", http.StatusForbidden)
+	}
+
+	// Nor can the revoked viewer come back by dropping it
+	_, resp, err = websocket.DefaultDialer.Dial(strings.TrimSuffix(viewerURL, "?share="), nil)
+	if err == nil {
+		t.Fatal("Expected viewer connection without the revoked share token to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status %d without the revoked share token", http.StatusUnauthorized)
+	}
+
+	// Unknown tokens can't be revoked
+	missingReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID+"/share-tokens/missing", nil)
+	missingReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	missingW := httptest.NewRecorder()
+	testRouter.ServeHTTP(missingW, missingReq)
+
+	if missingW.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, missingW.Code)
+	}
+}
+
+func TestViewerEventsStream(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(server.URL + "/api/streams/" + createResponse.StreamID + "/events")
+	if err != nil {
+		t.Fatalf("Failed to open event stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", contentType)
+	}
+
+	events := bufio.NewReader(resp.Body)
+	readSSEMessageOfType(t, events, "snapshot")
+
+	// SSE viewers count like WebSocket viewers
+	msg := readMessageOfType(t, mobileWS, "viewer_count")
+	var countMessage struct {
+		Payload models.ViewerCountUpdate `json:"payload"`
+	}
+	json.Unmarshal(msg, &countMessage)
+
+	if countMessage.Payload.ViewerCount != 1 || !countMessage.Payload.NewUser {
+		t.Errorf("Expected 1 new viewer, got %+v", countMessage.Payload)
+	}
+
+	message := models.WebSocketMessage{
+		Type: "stream_data",
+		Payload: models.StreamData{
+			CurrentLocation: models.CurrentLocation{Latitude: 51.5, Longitude: -0.12},
+			CurrentSpeedKmh: 80,
+		},
+	}
+	if err := mobileWS.WriteJSON(message); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+
+	msg = readSSEMessageOfType(t, events, "stream_data")
+	var received struct {
+		Payload models.StreamData `json:"payload"`
+	}
+	json.Unmarshal(msg, &received)
+
+	if received.Payload.CurrentSpeedKmh != 80 {
+		t.Errorf("Expected speed 80, got %v", received.Payload.CurrentSpeedKmh)
+	}
+
+	// Closing the stream ends the event stream
+	deleteReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+	deleteReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	deleteW := httptest.NewRecorder()
+	testRouter.ServeHTTP(deleteW, deleteReq)
+
+	if _, err := io.ReadAll(events); err != nil {
+		t.Errorf("Expected the event stream to end, got %v", err)
+	}
+}
+
+func TestViewerEventsRejection(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq := createJSONRequest(t, "POST", "/api/streams", models.CreateStreamRequest{
+		Visibility: models.VisibilityPasscode,
+		Passcode:   "4821",
+	})
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	tests := []struct {
+		name     string
+		url      string
+		expected int
+	}{
+		{"missing stream", "/api/streams/missing/events", http.StatusNotFound},
+		{"no passcode", "/api/streams/" + createResponse.StreamID + "/events", http.StatusUnauthorized},
+		{"wrong passcode", "/api/streams/" + createResponse.StreamID + "/events?code=0000", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.url, nil)
+			w := httptest.NewRecorder()
+			testRouter.ServeHTTP(w, req)
+
+			if w.Code != tt.expected {
+				t.Errorf("Expected status %d, got %d", tt.expected, w.Code)
+			}
+		})
+	}
+}
+
+func TestMsgPackSubprotocol(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	msgpackDialer := websocket.Dialer{Subprotocols: []string{hub.SubprotocolMsgPack}}
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := msgpackDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	if mobileWS.Subprotocol() != hub.SubprotocolMsgPack {
+		t.Fatalf("Expected subprotocol %q, got %q", hub.SubprotocolMsgPack, mobileWS.Subprotocol())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	jsonViewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect JSON viewer: %v", err)
+	}
+	defer jsonViewerWS.Close()
+
+	msgpackViewerWS, _, err := msgpackDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect MessagePack viewer: %v", err)
+	}
+	defer msgpackViewerWS.Close()
+
+	// The broadcaster is notified in MessagePack
+	readMsgPackMessageOfType(t, mobileWS, "viewer_count")
+
+	frame, err := msgpack.Marshal(map[string]interface{}{
+		"type": "stream_data",
+		"payload": map[string]interface{}{
+			"currentLocation": map[string]interface{}{"latitude": 51.5, "longitude": -0.12},
+			"currentSpeedKmh": 80,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to encode frame: %v", err)
+	}
+	if err := mobileWS.WriteMessage(websocket.BinaryMessage, frame); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+
+	// JSON viewers keep receiving JSON
+	msg := readMessageOfType(t, jsonViewerWS, "stream_data")
+	var jsonMessage struct {
+		Payload models.StreamData `json:"payload"`
+	}
+	json.Unmarshal(msg, &jsonMessage)
+
+	if jsonMessage.Payload.CurrentSpeedKmh != 80 || jsonMessage.Payload.CurrentLocation.Latitude != 51.5 {
+		t.Errorf("Expected the transcoded frame, got %+v", jsonMessage.Payload)
+	}
+
+	packed := readMsgPackMessageOfType(t, msgpackViewerWS, "stream_data")
+	payload, _ := packed["payload"].(map[string]interface{})
+	location, _ := payload["currentLocation"].(map[string]interface{})
+
+	if speed, _ := payload["currentSpeedKmh"].(int8); speed != 80 {
+		t.Errorf("Expected speed 80 as a compact integer, got %#v", payload["currentSpeedKmh"])
+	}
+	if latitude, _ := location["latitude"].(float64); latitude != 51.5 {
+		t.Errorf("Expected latitude 51.5, got %#v", location["latitude"])
+	}
+
+	// Invalid MessagePack is rejected like invalid JSON
+	if err := mobileWS.WriteMessage(websocket.BinaryMessage, []byte{0xc1}); err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+	readMsgPackMessageOfType(t, mobileWS, "error")
+}
+
+func TestStreamDataDeltas(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	testHub.KeyframeInterval = 3
+	defer func() { testHub.KeyframeInterval = hub.DefaultKeyframeInterval }()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	fullWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer: %v", err)
+	}
+	defer fullWS.Close()
+
+	deltaWS, _, err := websocket.DefaultDialer.Dial(viewerURL+"?deltas=true", nil)
+	if err != nil {
+		t.Fatalf("Failed to connect delta viewer: %v", err)
+	}
+	defer deltaWS.Close()
+
+	readMessageOfType(t, fullWS, "snapshot")
+	readMessageOfType(t, deltaWS, "snapshot")
+
+	sendDelta := func(delta map[string]interface{}) {
+		t.Helper()
+		if err := mobileWS.WriteJSON(map[string]interface{}{"type": "stream_data_delta", "payload": delta}); err != nil {
+			t.Fatalf("Failed to send delta: %v", err)
+		}
+	}
+
+	// A delta needs a previous frame to apply to
+	sendDelta(map[string]interface{}{"currentSpeedKmh": 90})
+	readMessageOfType(t, mobileWS, "keyframe_required")
+
+	err = mobileWS.WriteJSON(models.WebSocketMessage{
+		Type: "stream_data",
+		Payload: models.StreamData{
+			NavigationData:  &models.NavigationData{Polyline: [][]float64{{51.5, -0.12}, {51.6, -0.1}}, Distance: 12},
+			CurrentLocation: models.CurrentLocation{Latitude: 51.5, Longitude: -0.12},
+			CurrentSpeedKmh: 80,
+			Car:             models.Car{Name: "Roadster"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to send frame: %v", err)
+	}
+
+	// The first frame is a keyframe for everyone
+	readMessageOfType(t, fullWS, "stream_data")
+	readMessageOfType(t, deltaWS, "stream_data")
+
+	sendDelta(map[string]interface{}{
+		"currentSpeedKmh": 90,
+		"currentLocation": map[string]interface{}{"latitude": 51.55},
+	})
+
+	// Full viewers receive the reconstructed frame
+	msg := readMessageOfType(t, fullWS, "stream_data")
+	var full struct {
+		Payload models.StreamData `json:"payload"`
+	}
+	json.Unmarshal(msg, &full)
+
+	if full.Payload.CurrentSpeedKmh != 90 || full.Payload.CurrentLocation.Latitude != 51.55 || full.Payload.CurrentLocation.Longitude != -0.12 {
+		t.Errorf("Expected the delta to be applied to the previous frame, got %+v", full.Payload)
+	}
+	if full.Payload.NavigationData == nil || len(full.Payload.NavigationData.Polyline) != 2 || full.Payload.Car.Name != "Roadster" {
+		t.Errorf("Expected unchanged fields to be kept, got %+v", full.Payload)
+	}
+
+	// Delta viewers only receive the changes
+	msg = readMessageOfType(t, deltaWS, "stream_data_delta")
+	var delta struct {
+		Payload map[string]json.RawMessage `json:"payload"`
+	}
+	json.Unmarshal(msg, &delta)
+
+	if string(delta.Payload["currentSpeedKmh"]) != "90" {
+		t.Errorf("Expected the speed in the delta, got %s", delta.Payload["currentSpeedKmh"])
+	}
+	if string(delta.Payload["currentLocation"]) != `{"latitude":51.55}` {
+		t.Errorf("Expected only the changed coordinate, got %s", delta.Payload["currentLocation"])
+	}
+	if _, exists := delta.Payload["navigationData"]; exists {
+		t.Error("Expected the unchanged polyline to be left out of the delta")
+	}
+
+	// Every KeyframeInterval frames, delta viewers get a full frame
+	sendDelta(map[string]interface{}{"currentSpeedKmh": 95})
+	readMessageOfType(t, deltaWS, "stream_data_delta")
+
+	sendDelta(map[string]interface{}{"currentSpeedKmh": 100})
+	msg = readMessageOfType(t, deltaWS, "stream_data")
+	json.Unmarshal(msg, &full)
+
+	if full.Payload.CurrentSpeedKmh != 100 || full.Payload.NavigationData == nil {
+		t.Errorf("Expected a full keyframe, got %+v", full.Payload)
+	}
+}
+
+func TestWebSocketRateLimit(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	// The rate is low enough that no message is allowed beyond the burst
+	testHub.MessageRate = 0.001
+	testHub.MessageBurst = 3
+	testHub.MaxRateLimitedMessages = 2
+	defer func() {
+		testHub.MessageRate = hub.DefaultMessageRate
+		testHub.MessageBurst = hub.DefaultMessageBurst
+		testHub.MaxRateLimitedMessages = hub.DefaultMaxRateLimitedMessages
+	}()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	sendFrames := func(count int) {
+		for i := 0; i < count; i++ {
+			message := models.WebSocketMessage{
+				Type: "stream_data",
+				Payload: models.StreamData{
+					CurrentLocation: models.CurrentLocation{Latitude: 51.5, Longitude: -0.12},
+					CurrentSpeedKmh: float64(i),
+				},
+			}
+			if err := mobileWS.WriteJSON(message); err != nil {
+				t.Fatalf("Failed to send message: %v", err)
+			}
+		}
+	}
+
+	// The burst is allowed, the next frame is dropped
+	sendFrames(4)
+
+	msg := readMessageOfType(t, mobileWS, "rate_limited")
+	var notice struct {
+		Payload models.RateLimitNotice `json:"payload"`
+	}
+	json.Unmarshal(msg, &notice)
+
+	if notice.Payload.MessagesPerSecond != 0.001 || notice.Payload.Burst != 3 {
+		t.Errorf("Expected the configured limits in the notice, got %+v", notice.Payload)
+	}
+
+	// Exceeding MaxRateLimitedMessages
+	sendFrames(2)
+
+	// The broadcaster is disconnected for abuse
+	mobileWS.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		_, _, err := mobileWS.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+			t.Errorf("Expected a policy violation close, got %v", err)
+		}
+		break
+	}
+}
+
+// countingStore counts the latest data and track point writes of a store
+type countingStore struct {
+	store.Store
+	writes      atomic.Int32
+	pointWrites atomic.Int32
+	points      atomic.Int32
+}
+
+func (s *countingStore) Streams() store.StreamRepository {
+	return countingStreams{StreamRepository: s.Store.Streams(), writes: &s.writes}
+}
+
+func (s *countingStore) TrackPoints() store.TrackPointRepository {
+	return countingTrackPoints{TrackPointRepository: s.Store.TrackPoints(), store: s}
+}
+
+type countingTrackPoints struct {
+	store.TrackPointRepository
+	store *countingStore
+}
+
+func (r countingTrackPoints) Insert(ctx context.Context, point models.TrackPoint) error {
+	r.store.pointWrites.Add(1)
+	r.store.points.Add(1)
+	return r.TrackPointRepository.Insert(ctx, point)
+}
+
+func (r countingTrackPoints) InsertMany(ctx context.Context, points []models.TrackPoint) error {
+	r.store.pointWrites.Add(1)
+	r.store.points.Add(int32(len(points)))
+	return r.TrackPointRepository.InsertMany(ctx, points)
+}
+
+type countingStreams struct {
+	store.StreamRepository
+	writes *atomic.Int32
+}
+
+func (r countingStreams) UpdateLatestData(ctx context.Context, streamID string, data models.StreamData) error {
+	r.writes.Add(1)
+	return r.StreamRepository.UpdateLatestData(ctx, streamID, data)
+}
+
+func TestStreamDataWritesCoalesced(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	counting := &countingStore{Store: testStore}
+	h := hub.NewHub(counting)
+	h.StreamDataWritesPerSecond = 2
+	go h.Run()
+	router := setupRouter(counting, h)
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	router.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer: %v", err)
+	}
+	defer viewerWS.Close()
+
+	for i := 1; i <= 10; i++ {
+		message := models.WebSocketMessage{
+			Type: "stream_data",
+			Payload: models.StreamData{
+				CurrentLocation: models.CurrentLocation{Latitude: 51.5, Longitude: -0.12},
+				CurrentSpeedKmh: float64(i),
+			},
+		}
+		if err := mobileWS.WriteJSON(message); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+
+	// Viewers still get every frame
+	for i := 1; i <= 10; i++ {
+		readMessageOfType(t, viewerWS, "stream_data")
+	}
+
+	// The first frame is written immediately and the rest once the interval has passed
+	time.Sleep(time.Second)
+
+	if writes := counting.writes.Load(); writes < 1 || writes > 2 {
+		t.Errorf("Expected at most 2 coalesced writes, got %d", writes)
+	}
+
+	// Track points are batched in the same writes, and none is lost
+	if writes := counting.pointWrites.Load(); writes < 1 || writes > 2 {
+		t.Errorf("Expected at most 2 batched track point writes, got %d", writes)
+	}
+	if points := counting.points.Load(); points != 10 {
+		t.Errorf("Expected all 10 track points to be written, got %d", points)
+	}
+
+	stream, err := testStore.Streams().Get(context.Background(), createResponse.StreamID)
+	if err != nil {
+		t.Fatalf("Failed to get stream: %v", err)
+	}
+
+	if stream.LatestData == nil || stream.LatestData.CurrentSpeedKmh != 10 {
+		t.Errorf("Expected the last frame to be stored, got %+v", stream.LatestData)
+	}
+}
+
+func TestStreamCreationLimits(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	limits := handlers.CreationLimits{
+		PerIP:            handlers.Quota{Requests: 3, Window: time.Hour},
+		PerToken:         handlers.Quota{Requests: 2, Window: time.Hour},
+		MaxActiveStreams: 1,
+	}
+
+	router := gin.New()
+	router.POST("/api/streams", handlers.StreamCreationLimits(testStore, limits), handlers.CreateStreamHandler(testStore))
+	router.DELETE("/api/streams/:streamId", handlers.DeleteStreamHandler(testStore, testHub))
+
+	create := func(ip, clientToken string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/api/streams", nil)
+		req.RemoteAddr = ip + ":40000"
+		if clientToken != "" {
+			req.Header.Set("X-Client-Token", clientToken)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The first stream of an IP is created, the second exceeds its active stream cap
+	first := create("203.0.113.1", "")
+	if first.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, first.Code)
+	}
+
+	if w := create("203.0.113.1", ""); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected the active stream cap to return %d with Retry-After, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	// Ending the stream frees the slot
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(first.Body.Bytes(), &createResponse)
+
+	deleteReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+	deleteReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	router.ServeHTTP(httptest.NewRecorder(), deleteReq)
+
+	if w := create("203.0.113.1", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status %d after ending the stream, got %d", http.StatusOK, w.Code)
+	}
+
+	// The IP quota is used up, whatever the client token
+	w := create("203.0.113.1", "app-install-1")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected the IP quota to return %d, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	if err != nil || retryAfter <= 0 || retryAfter > 20*60 {
+		t.Errorf("Expected Retry-After of up to 20 minutes, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// Another IP has its own quota
+	if w := create("203.0.113.2", "app-install-1"); w.Code != http.StatusOK {
+		t.Errorf("Expected status %d from another IP, got %d", http.StatusOK, w.Code)
+	}
+
+	// The IP cap applies whatever the client token
+	if w := create("203.0.113.2", "app-install-2"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected the IP cap to return %d with Retry-After for a new client token, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	// The token cap applies on top, from any IP
+	if w := create("203.0.113.3", "app-install-1"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected the token cap to return %d with Retry-After, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	if w := create("203.0.113.3", "app-install-1"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Errorf("Expected the token quota to return %d with Retry-After, got %d", http.StatusTooManyRequests, w.Code)
+	}
+
+	if w := create("203.0.113.3", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected status %d from another IP without a client token, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	// Store writes and HTTP requests are recorded in the default registry
+	s := metrics.InstrumentStore(testStore)
+	h := hub.NewHub(s)
+	go h.Run()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(h)
+
+	router := gin.New()
+	router.Use(metrics.Middleware())
+	router.GET("/metrics", metrics.Handler())
+	router.POST("/api/streams", handlers.CreateStreamHandler(s))
+	router.GET("/ws/mobile/:streamId", handlers.MobileWebSocketHandler(s, h))
+	router.GET("/ws/viewer/:streamId", handlers.ViewerWebSocketHandler(s, h))
+
+	scrape := func(handler http.Handler) string {
+		req, _ := http.NewRequest("GET", "/metrics", nil)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	router.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer: %v", err)
+	}
+	defer viewerWS.Close()
+
+	message := models.WebSocketMessage{
+		Type: "stream_data",
+		Payload: models.StreamData{
+			CurrentLocation: models.CurrentLocation{Latitude: 51.5, Longitude: -0.12},
+			CurrentSpeedKmh: 80,
+		},
+	}
+	if err := mobileWS.WriteJSON(message); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	readMessageOfType(t, viewerWS, "stream_data")
+
+	hubMetrics := scrape(promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	for _, expected := range []string{
+		"velocity_stream_hubs 1",
+		"velocity_broadcasters 1",
+		"velocity_viewers 1",
+		"velocity_messages_broadcast_total 1",
+		"velocity_auto_cancelled_streams_total 0",
+	} {
+		if !strings.Contains(hubMetrics, expected) {
+			t.Errorf("Expected hub metrics to contain %q, got:\n%s", expected, hubMetrics)
+		}
+	}
+
+	appMetrics := scrape(router)
+	for _, expected := range []string{
+		`velocity_http_request_duration_seconds_count{method="POST",route="/api/streams",status="200"}`,
+		`velocity_db_write_duration_seconds_count{operation="stream_create"}`,
+	} {
+		if !strings.Contains(appMetrics, expected) {
+			t.Errorf("Expected metrics to contain %q", expected)
+		}
+	}
+
+	// WebSocket connections are not request durations
+	if strings.Contains(appMetrics, `route="/ws/viewer/:streamId"`) {
+		t.Error("Expected WebSocket connections to be excluded from request durations")
+	}
+}
+
+// gatedWriter is a streaming response writer whose writes block until its gate is closed,
+// simulating a viewer that stopped reading
+type gatedWriter struct {
+	header http.Header
+	gate   chan struct{}
+	out    io.Writer
+}
+
+func newGatedWriter(out io.Writer) *gatedWriter {
+	return &gatedWriter{header: make(http.Header), gate: make(chan struct{}), out: out}
+}
+
+func (w *gatedWriter) Header() http.Header { return w.header }
+func (w *gatedWriter) WriteHeader(int)     {}
+func (w *gatedWriter) Flush()              {}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	<-w.gate
+	return w.out.Write(p)
+}
+
+// leaveRecorder reports the reason of every viewer leave recorded in the store
+type leaveRecorder struct {
+	store.Store
+	reasons chan string
+}
+
+func (s *leaveRecorder) JoinLogs() store.JoinLogRepository {
+	return leaveRecordingJoinLogs{JoinLogRepository: s.Store.JoinLogs(), reasons: s.reasons}
+}
+
+type leaveRecordingJoinLogs struct {
+	store.JoinLogRepository
+	reasons chan string
+}
+
+func (r leaveRecordingJoinLogs) MarkLeft(ctx context.Context, id primitive.ObjectID, leftAt time.Time, reason string) error {
+	r.reasons <- reason
+	return r.JoinLogRepository.MarkLeft(ctx, id, leftAt, reason)
+}
+
+func TestSlowViewerEviction(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	recorder := &leaveRecorder{Store: testStore, reasons: make(chan string, 10)}
+	h := hub.NewHub(recorder)
+	h.MessageRate = 0
+	h.MaxConsecutiveDrops = 20
+	go h.Run()
+	router := setupRouter(recorder, h)
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	router.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	// Two SSE viewers that stop reading: one stays stalled, the other recovers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stalled := newGatedWriter(io.Discard)
+	defer close(stalled.gate)
+
+	recoveringEvents, recoveringOut := io.Pipe()
+	defer recoveringEvents.Close()
+	recovering := newGatedWriter(recoveringOut)
+
+	for _, w := range []*gatedWriter{stalled, recovering} {
+		req, _ := http.NewRequestWithContext(ctx, "GET", "/api/streams/"+createResponse.StreamID+"/events", nil)
+		go router.ServeHTTP(w, req)
+	}
+
+	for viewers := 0; viewers < 2; {
+		msg := readMessageOfType(t, mobileWS, "viewer_count")
+		var countMessage struct {
+			Payload models.ViewerCountUpdate `json:"payload"`
+		}
+		json.Unmarshal(msg, &countMessage)
+		viewers = countMessage.Payload.ViewerCount
+	}
+
+	sendFrame := func() {
+		message := models.WebSocketMessage{
+			Type: "stream_data",
+			Payload: models.StreamData{
+				CurrentLocation: models.CurrentLocation{Latitude: 51.5, Longitude: -0.12},
+				CurrentSpeedKmh: 80,
+			},
+		}
+		if err := mobileWS.WriteJSON(message); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+
+	// Overflow the 256 message send buffers by a few frames
+	for i := 0; i < 266; i++ {
+		sendFrame()
+	}
+
+	// Frames are handled in order, so once an invalid frame is rejected all were broadcast
+	mobileWS.WriteJSON(map[string]interface{}{"type": "stream_data", "payload": "invalid"})
+	readMessageOfType(t, mobileWS, "error")
+
+	// The recovering viewer catches up and is told how many messages it missed
+	close(recovering.gate)
+
+	messages := make(chan models.WebSocketMessage, 512)
+	go func() {
+		lines := bufio.NewScanner(recoveringEvents)
+		for lines.Scan() {
+			var message models.WebSocketMessage
+			if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok && json.Unmarshal([]byte(data), &message) == nil {
+				messages <- message
+			}
+		}
+	}()
+
+	var notice models.LaggingNotice
+	deadline := time.After(5 * time.Second)
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+waitForLagging:
+	for {
+		select {
+		case message := <-messages:
+			if message.Type == "lagging" {
+				payload, _ := json.Marshal(message.Payload)
+				json.Unmarshal(payload, &notice)
+				break waitForLagging
+			}
+		case <-ticker.C:
+			sendFrame() // Delivered once the backlog is drained
+		case <-deadline:
+			t.Fatal("Expected a lagging message")
+		}
+	}
+
+	if notice.StreamID != createResponse.StreamID || notice.DroppedMessages < 1 || notice.DroppedMessages > 20 {
+		t.Errorf("Expected a lagging notice with the dropped messages, got %+v", notice)
+	}
+
+	// The stalled viewer keeps missing frames until it is evicted
+	for i := 0; i < 20; i++ {
+		sendFrame()
+	}
+
+	select {
+	case reason := <-recorder.reasons:
+		if reason != models.LeaveReasonSlowConsumer {
+			t.Errorf("Expected leave reason %q, got %q", models.LeaveReasonSlowConsumer, reason)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the stalled viewer to be evicted")
+	}
+
+	if count := h.GetViewerCount(createResponse.StreamID); count != 1 {
+		t.Errorf("Expected the recovering viewer to remain, got %d viewers", count)
+	}
+}
+
+// logBuffer collects JSON log records written concurrently by handlers and the hub
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// find returns the first record with the given message, or nil
+func (b *logBuffer) find(msg string) map[string]any {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, line := range strings.Split(b.buf.String(), "\n") {
+		var record map[string]any
+		if json.Unmarshal([]byte(line), &record) == nil && record["msg"] == msg {
+			return record
+		}
+	}
+	return nil
+}
+
+func TestRequestIDLogging(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	logs := &logBuffer{}
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(logs, nil)))
+	defer slog.SetDefault(previous)
+
+	h := hub.NewHub(testStore)
+	go h.Run()
+
+	router := gin.New()
+	router.Use(logging.Middleware())
+	router.POST("/api/streams", handlers.CreateStreamHandler(testStore))
+	router.GET("/ws/mobile/:streamId", handlers.MobileWebSocketHandler(testStore, h))
+
+	// A request ID sent by the client is echoed and logged
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createReq.Header.Set(logging.RequestIDHeader, "create-request")
+	createW := httptest.NewRecorder()
+	router.ServeHTTP(createW, createReq)
+
+	if got := createW.Header().Get(logging.RequestIDHeader); got != "create-request" {
+		t.Errorf("Expected request ID create-request to be echoed, got %q", got)
+	}
+
+	record := logs.find("HTTP request")
+	if record == nil {
+		t.Fatal("Expected the request to be logged")
+	}
+	if record["requestId"] != "create-request" || record["path"] != "/api/streams" || record["status"] != float64(http.StatusOK) {
+		t.Errorf("Unexpected request log record: %v", record)
+	}
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	// Requests without an ID get a generated one
+	otherReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	otherW := httptest.NewRecorder()
+	router.ServeHTTP(otherW, otherReq)
+
+	generated := otherW.Header().Get(logging.RequestIDHeader)
+	if generated == "" || generated == "create-request" {
+		t.Errorf("Expected a generated request ID, got %q", generated)
+	}
+
+	// Hub records about a connection carry its stream, role and the request that opened it
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	header := http.Header{}
+	header.Set(logging.RequestIDHeader, "mobile-request")
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, header)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for record = logs.find("Mobile broadcaster registered"); record == nil && time.Now().Before(deadline); record = logs.find("Mobile broadcaster registered") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if record == nil {
+		t.Fatal("Expected the broadcaster registration to be logged")
+	}
+	if record["streamId"] != createResponse.StreamID || record["role"] != "broadcaster" || record["requestId"] != "mobile-request" || record["clientId"] == "" {
+		t.Errorf("Unexpected registration log record: %v", record)
+	}
+}
+
+func TestTripSummary(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	summaryURL := "/api/streams/" + createResponse.StreamID + "/summary"
+
+	// Live streams have no summary yet
+	liveReq, _ := http.NewRequest("GET", summaryURL, nil)
+	liveW := httptest.NewRecorder()
+	testRouter.ServeHTTP(liveW, liveReq)
+
+	if liveW.Code != http.StatusConflict {
+		t.Errorf("Expected status %d before the stream ended, got %d", http.StatusConflict, liveW.Code)
+	}
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	viewerWS, _, err := websocket.DefaultDialer.Dial(viewerURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer viewerWS.Close()
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Drive, then pause at the destination
+	frames := []models.StreamData{
+		{CurrentLocation: models.CurrentLocation{Latitude: 51.5074, Longitude: -0.1278}, CurrentSpeedKmh: 30},
+		{CurrentLocation: models.CurrentLocation{Latitude: 51.5080, Longitude: -0.1290}, CurrentSpeedKmh: 60},
+		{CurrentLocation: models.CurrentLocation{Latitude: 51.5090, Longitude: -0.1300}, CurrentSpeedKmh: 45, IsPaused: true},
+		{CurrentLocation: models.CurrentLocation{Latitude: 51.5090, Longitude: -0.1300}, IsPaused: true},
+	}
+	for i := range frames {
+		frames[i].StartAddressLine = "1 Start Street"
+		frames[i].StartCity = "London"
+		frames[i].EndAddressLine = "2 End Road"
+
+		msgBytes, _ := json.Marshal(models.WebSocketMessage{Type: "stream_data", Payload: frames[i]})
+		if err := mobileWS.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	deleteReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+	deleteReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	deleteW := httptest.NewRecorder()
+	testRouter.ServeHTTP(deleteW, deleteReq)
+
+	if deleteW.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, deleteW.Code, deleteW.Body.String())
+	}
+
+	var deleteResponse struct {
+		Summary *models.TripSummary `json:"summary"`
+	}
+	json.Unmarshal(deleteW.Body.Bytes(), &deleteResponse)
+	if deleteResponse.Summary == nil {
+		t.Fatal("Expected the delete response to include the trip summary")
+	}
+
+	req, _ := http.NewRequest("GET", summaryURL, nil)
+	w := httptest.NewRecorder()
+	testRouter.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var summary models.TripSummary
+	if err := json.Unmarshal(w.Body.Bytes(), &summary); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	expectedKm := (geo.DistanceMeters(51.5074, -0.1278, 51.5080, -0.1290) + geo.DistanceMeters(51.5080, -0.1290, 51.5090, -0.1300)) / 1000
+	if math.Abs(summary.DistanceKm-expectedKm) > 0.001 {
+		t.Errorf("Expected distance %.3f km, got %.3f", expectedKm, summary.DistanceKm)
+	}
+	if summary.MaxSpeedKmh != 60 {
+		t.Errorf("Expected max speed 60, got %v", summary.MaxSpeedKmh)
+	}
+	if summary.MovingSeconds <= 0 || summary.PausedSeconds <= 0 || summary.AverageSpeedKmh <= 0 {
+		t.Errorf("Expected moving time, paused time and an average speed, got %+v", summary)
+	}
+	if summary.StartedAt == nil || summary.StartedAt.After(summary.EndedAt) {
+		t.Errorf("Expected the trip to start before it ended, got %v and %v", summary.StartedAt, summary.EndedAt)
+	}
+	if summary.StartAddress == nil || summary.StartAddress.AddressLine != "1 Start Street" || summary.StartAddress.City != "London" {
+		t.Errorf("Unexpected start address: %+v", summary.StartAddress)
+	}
+	if summary.EndAddress == nil || summary.EndAddress.AddressLine != "2 End Road" {
+		t.Errorf("Unexpected end address: %+v", summary.EndAddress)
+	}
+	if summary.PeakViewerCount != 1 || summary.TotalViewers != 1 {
+		t.Errorf("Expected 1 peak and 1 total viewer, got %d and %d", summary.PeakViewerCount, summary.TotalViewers)
+	}
+	if summary.AutoCancelled {
+		t.Error("Expected a deleted stream not to be marked auto-cancelled")
+	}
+}
+
+func TestStreamReplay(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+	streamID := createResponse.StreamID
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	replayURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/replay/" + streamID
+
+	// Live streams can't be replayed
+	_, resp, err := websocket.DefaultDialer.Dial(replayURL, nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusConflict {
+		t.Fatalf("Expected replaying a live stream to be rejected with %d, got %v", http.StatusConflict, resp)
+	}
+
+	// Record a 3 second drive with an arrival, then end it
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour).Truncate(time.Millisecond)
+	for i := 0; i < 4; i++ {
+		point := models.TrackPoint{
+			StreamID:  streamID,
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			Latitude:  51.5 + float64(i)*0.001,
+			Longitude: -0.12,
+			SpeedKmh:  float64(30 + i*10),
+		}
+		if err := testStore.TrackPoints().Insert(ctx, point); err != nil {
+			t.Fatalf("Failed to insert track point: %v", err)
+		}
+	}
+	testStore.StreamEvents().Insert(ctx, models.StreamEvent{StreamID: streamID, Type: "arrived", Timestamp: start.Add(3 * time.Second)})
+	testStore.Streams().UpdateLatestData(ctx, streamID, models.StreamData{DestinationName: "Home", Car: models.Car{Name: "Roadster"}})
+	testStore.Streams().MarkDeleted(ctx, streamID, time.Now(), false)
+
+	replayWS, _, err := websocket.DefaultDialer.Dial(replayURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect replay WebSocket: %v", err)
+	}
+	defer replayWS.Close()
+
+	next := func() (string, json.RawMessage) {
+		t.Helper()
+		replayWS.SetReadDeadline(time.Now().Add(3 * time.Second))
+		var message struct {
+			Type    string          `json:"type"`
+			Payload json.RawMessage `json:"payload"`
+		}
+		if err := replayWS.ReadJSON(&message); err != nil {
+			t.Fatalf("Failed to read replay message: %v", err)
+		}
+		return message.Type, message.Payload
+	}
+	expect := func(messageType string) json.RawMessage {
+		t.Helper()
+		received, payload := next()
+		if received != messageType {
+			t.Fatalf("Expected '%s' message, got '%s': %s", messageType, received, payload)
+		}
+		return payload
+	}
+	control := func(control models.ReplayControl) {
+		t.Helper()
+		if err := replayWS.WriteJSON(models.WebSocketMessage{Type: "replay_control", Payload: control}); err != nil {
+			t.Fatalf("Failed to send replay control: %v", err)
+		}
+	}
+
+	var state models.ReplayState
+	json.Unmarshal(expect("replay_state"), &state)
+	if state.DurationSeconds != 3 || state.Speed != 1 || state.Paused || state.Finished {
+		t.Errorf("Unexpected initial state: %+v", state)
+	}
+	expect("snapshot")
+
+	// At 10x the rest of the drive plays in about 0.3 seconds
+	control(models.ReplayControl{Action: models.ReplayActionSpeed, Speed: 10})
+	playedAt := time.Now()
+
+	var frames []models.StreamData
+	var types []string
+	for {
+		received, payload := next()
+		types = append(types, received)
+		if received == "stream_data" {
+			var data models.StreamData
+			json.Unmarshal(payload, &data)
+			frames = append(frames, data)
+		}
+		if received == "replay_state" {
+			json.Unmarshal(payload, &state)
+			if state.Finished {
+				break
+			}
+		}
+	}
+	if elapsed := time.Since(playedAt); elapsed > 2*time.Second {
+		t.Errorf("Expected the drive to play at 10x, took %v", elapsed)
+	}
+
+	if len(frames) != 4 || types[len(types)-2] != "arrived" {
+		t.Fatalf("Expected 4 stream_data frames then the arrival, got %v", types)
+	}
+	for i, frame := range frames {
+		if frame.CurrentSpeedKmh != float64(30+i*10) || frame.Duration != float64(i) || frame.Car.Name != "Roadster" || frame.DestinationName != "Home" {
+			t.Errorf("Frame %d not rebuilt from the recording: %+v", i, frame)
+		}
+	}
+	if frames[3].DistanceKm <= frames[1].DistanceKm || frames[3].MaxSpeedKmh != 60 {
+		t.Errorf("Expected distance and max speed to accumulate, got %+v", frames[3])
+	}
+
+	// Seeking sends the drive up to the new position
+	control(models.ReplayControl{Action: models.ReplayActionPause})
+	json.Unmarshal(expect("replay_state"), &state)
+	if !state.Paused {
+		t.Errorf("Expected the replay to be paused, got %+v", state)
+	}
+
+	control(models.ReplayControl{Action: models.ReplayActionSeek, PositionSeconds: 1.5})
+
+	var snapshot struct {
+		LatestData  models.StreamData   `json:"latestData"`
+		RecentTrack []models.TrackPoint `json:"recentTrack"`
+	}
+	json.Unmarshal(expect("snapshot"), &snapshot)
+	if len(snapshot.RecentTrack) != 2 || snapshot.LatestData.CurrentSpeedKmh != 40 {
+		t.Errorf("Expected a snapshot of the first 2 points, got %d points and %+v", len(snapshot.RecentTrack), snapshot.LatestData)
+	}
+
+	json.Unmarshal(expect("replay_state"), &state)
+	if state.PositionSeconds != 1.5 || !state.Paused || state.Finished {
+		t.Errorf("Unexpected state after seeking: %+v", state)
+	}
+
+	// Paused replays send nothing until played
+	replayWS.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, msg, err := replayWS.ReadMessage(); err == nil {
+		t.Fatalf("Expected no message while paused, got %s", msg)
+	}
+	replayWS.Close()
+
+	replayWS, _, err = websocket.DefaultDialer.Dial(replayURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to reconnect replay WebSocket: %v", err)
+	}
+	defer replayWS.Close()
+
+	control(models.ReplayControl{Action: models.ReplayActionSpeed, Speed: 3})
+	var errorMessage struct {
+		Payload models.ErrorMessage `json:"payload"`
+	}
+	json.Unmarshal(readMessageOfType(t, replayWS, "error"), &errorMessage)
+	if !strings.Contains(errorMessage.Payload.Message, "speed") {
+		t.Errorf("Expected an invalid speed error, got %q", errorMessage.Payload.Message)
+	}
+}
+
+func TestViewerChat(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	aliceIP := http.Header{"X-Forwarded-For": {"203.0.113.7"}}
+	aliceWS, _, err := websocket.DefaultDialer.Dial(viewerURL+"?name=Alice", aliceIP)
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer aliceWS.Close()
+
+	bobWS, _, err := websocket.DefaultDialer.Dial(viewerURL, http.Header{"X-Forwarded-For": {"203.0.113.8"}})
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer bobWS.Close()
+
+	readMessageOfType(t, aliceWS, "snapshot")
+	readMessageOfType(t, bobWS, "snapshot")
+
+	send := func(ws *websocket.Conn, messageType string, payload interface{}) {
+		t.Helper()
+		msgBytes, _ := json.Marshal(models.WebSocketMessage{Type: messageType, Payload: payload})
+		if err := ws.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+	readChat := func(ws *websocket.Conn) models.ChatMessage {
+		t.Helper()
+		var received struct {
+			Payload models.ChatMessage `json:"payload"`
+		}
+		json.Unmarshal(readMessageOfType(t, ws, "chat"), &received)
+		return received.Payload
+	}
+	readError := func(ws *websocket.Conn) string {
+		t.Helper()
+		var received struct {
+			Payload models.ErrorMessage `json:"payload"`
+		}
+		json.Unmarshal(readMessageOfType(t, ws, "error"), &received)
+		return received.Payload.Message
+	}
+
+	// Chat reaches the other viewers, the broadcaster and the sender
+	send(aliceWS, "chat", models.ChatRequest{Text: "  Hello 👋  "})
+	for _, ws := range []*websocket.Conn{bobWS, mobileWS, aliceWS} {
+		message := readChat(ws)
+		if message.Text != "Hello 👋" || message.DisplayName != "Alice" {
+			t.Errorf("Expected sanitized chat from Alice, got %+v", message)
+		}
+	}
+
+	// Reactions carry a generated display name for viewers that chose none
+	send(bobWS, "reaction", models.ReactionRequest{Emoji: "1️⃣"})
+	var reaction struct {
+		Payload models.Reaction `json:"payload"`
+	}
+	json.Unmarshal(readMessageOfType(t, mobileWS, "reaction"), &reaction)
+	if reaction.Payload.Emoji != "1️⃣" || !strings.HasPrefix(reaction.Payload.DisplayName, "Viewer ") {
+		t.Errorf("Unexpected reaction %+v", reaction.Payload)
+	}
+	readMessageOfType(t, aliceWS, "reaction")
+	bobID := reaction.Payload.ClientID
+
+	// Length limits
+	send(aliceWS, "chat", models.ChatRequest{Text: strings.Repeat("a", models.MaxChatMessageLength+1)})
+	if message := readError(aliceWS); !strings.HasPrefix(message, "Invalid chat payload") {
+		t.Errorf("Expected invalid chat error, got %q", message)
+	}
+	send(aliceWS, "reaction", models.ReactionRequest{Emoji: "lol"})
+	if message := readError(aliceWS); !strings.HasPrefix(message, "Invalid reaction payload") {
+		t.Errorf("Expected invalid reaction error, got %q", message)
+	}
+
+	// The broadcaster can stop receiving chat
+	send(mobileWS, "chat_settings", models.ChatSettings{Muted: true})
+	readMessageOfType(t, mobileWS, "chat_settings")
+	send(aliceWS, "chat", models.ChatRequest{Text: "While muted"})
+	if message := readChat(bobWS); message.Text != "While muted" {
+		t.Errorf("Expected viewers to get chat while the broadcaster is muted, got %q", message.Text)
+	}
+	send(mobileWS, "chat_settings", models.ChatSettings{Muted: false})
+	readMessageOfType(t, mobileWS, "chat_settings")
+	send(aliceWS, "chat", models.ChatRequest{Text: "After unmuting"})
+	if message := readChat(mobileWS); message.Text != "After unmuting" {
+		t.Errorf("Expected the broadcaster to skip chat sent while muted, got %q", message.Text)
+	}
+	aliceID := readChat(bobWS).ClientID
+
+	// The broadcaster can mute a viewer
+	send(mobileWS, "moderate", models.ModerationRequest{Action: models.ModerationMute, ClientID: aliceID})
+	readMessageOfType(t, aliceWS, "chat_muted")
+	send(aliceWS, "chat", models.ChatRequest{Text: "Muted"})
+	if message := readError(aliceWS); message != "You were muted by the broadcaster" {
+		t.Errorf("Expected muted error, got %q", message)
+	}
+
+	// The mute covers the viewer's IP address, so reconnecting doesn't lift it
+	aliceWS.Close()
+	aliceWS, _, err = websocket.DefaultDialer.Dial(viewerURL+"?name=Alice", aliceIP)
+	if err != nil {
+		t.Fatalf("Failed to reconnect viewer WebSocket: %v", err)
+	}
+	defer aliceWS.Close()
+	readMessageOfType(t, aliceWS, "snapshot")
+
+	send(aliceWS, "reaction", models.ReactionRequest{Emoji: "🔥"})
+	if message := readError(aliceWS); message != "You were muted by the broadcaster" {
+		t.Errorf("Expected muted error after reconnecting, got %q", message)
+	}
+
+	send(mobileWS, "moderate", models.ModerationRequest{Action: models.ModerationUnmute, IPAddress: "203.0.113.7"})
+	readMessageOfType(t, aliceWS, "chat_unmuted")
+
+	send(mobileWS, "moderate", models.ModerationRequest{Action: "shadowban", ClientID: aliceID})
+	if message := readError(mobileWS); !strings.HasPrefix(message, "Invalid moderate payload") {
+		t.Errorf("Expected invalid moderate error, got %q", message)
+	}
+
+	// ...and kick one
+	send(mobileWS, "moderate", models.ModerationRequest{Action: models.ModerationKick, ClientID: bobID})
+	readMessageOfType(t, bobWS, "kicked")
+	bobWS.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err := bobWS.ReadMessage(); err == nil {
+		t.Error("Expected the kicked viewer to be disconnected")
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for testHub.GetViewerCount(createResponse.StreamID) != 1 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if count := testHub.GetViewerCount(createResponse.StreamID); count != 1 {
+		t.Errorf("Expected 1 viewer left, got %d", count)
+	}
+
+	// Chat history, oldest first
+	chatURL := "/api/streams/" + createResponse.StreamID + "/chat"
+	var history models.ChatHistoryResponse
+	deadline = time.Now().Add(3 * time.Second)
+	for {
+		req, _ := http.NewRequest("GET", chatURL, nil)
+		w := httptest.NewRecorder()
+		testRouter.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		json.Unmarshal(w.Body.Bytes(), &history)
+		if len(history.Messages) >= 3 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	var texts []string
+	for _, message := range history.Messages {
+		texts = append(texts, message.Text)
+	}
+	if strings.Join(texts, "|") != "Hello 👋|While muted|After unmuting" {
+		t.Errorf("Unexpected chat history %q", texts)
+	}
+
+	limitReq, _ := http.NewRequest("GET", chatURL+"?limit=1", nil)
+	limitW := httptest.NewRecorder()
+	testRouter.ServeHTTP(limitW, limitReq)
+	json.Unmarshal(limitW.Body.Bytes(), &history)
+	if len(history.Messages) != 1 || history.Messages[0].Text != "After unmuting" {
+		t.Errorf("Expected only the latest message, got %+v", history.Messages)
+	}
+
+	invalidReq, _ := http.NewRequest("GET", chatURL+"?limit=0", nil)
+	invalidW := httptest.NewRecorder()
+	testRouter.ServeHTTP(invalidW, invalidReq)
+	if invalidW.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for an invalid limit, got %d", http.StatusBadRequest, invalidW.Code)
+	}
+}
+
+func TestReactionValidation(t *testing.T) {
+	valid := []string{"🔥", "👍🏽", "🇬🇧", "❤️", "1️⃣", "#️⃣", "*️⃣", "©️", "ℹ️"}
+	for _, emoji := range valid {
+		request := models.ReactionRequest{Emoji: emoji}
+		if err := request.Validate(); err != nil {
+			t.Errorf("Expected %q to be a valid reaction, got %v", emoji, err)
+		}
+	}
+
+	invalid := []string{"", "lol", "1", "#", "a\uFE0F", "🔥 🔥", "é"}
+	for _, emoji := range invalid {
+		request := models.ReactionRequest{Emoji: emoji}
+		if err := request.Validate(); err == nil {
+			t.Errorf("Expected %q to be rejected", emoji)
+		}
+	}
+}
+
+func TestBanViewer(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+	createW := httptest.NewRecorder()
+	testRouter.ServeHTTP(createW, createReq)
+
+	var createResponse models.StreamIDResponse
+	json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+	server := httptest.NewServer(testRouter)
+	defer server.Close()
+
+	mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+	mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+	if err != nil {
+		t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+	}
+	defer mobileWS.Close()
+
+	viewerURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/viewer/" + createResponse.StreamID
+	fromIP := func(ip string) http.Header {
+		return http.Header{"X-Forwarded-For": {ip}}
+	}
+
+	aliceWS, _, err := websocket.DefaultDialer.Dial(viewerURL+"?name=Alice", fromIP("203.0.113.7"))
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer aliceWS.Close()
+	readMessageOfType(t, aliceWS, "snapshot")
+
+	bobWS, _, err := websocket.DefaultDialer.Dial(viewerURL+"?name=Bob", fromIP("203.0.113.8"))
+	if err != nil {
+		t.Fatalf("Failed to connect viewer WebSocket: %v", err)
+	}
+	defer bobWS.Close()
+	readMessageOfType(t, bobWS, "snapshot")
+
+	send := func(messageType string, payload interface{}) {
+		t.Helper()
+		msgBytes, _ := json.Marshal(models.WebSocketMessage{Type: messageType, Payload: payload})
+		if err := mobileWS.WriteMessage(websocket.TextMessage, msgBytes); err != nil {
+			t.Fatalf("Failed to send message: %v", err)
+		}
+	}
+
+	listViewers := func() []models.ViewerInfo {
+		t.Helper()
+		send("list_viewers", nil)
+		var received struct {
+			Payload models.ViewerList `json:"payload"`
+		}
+		json.Unmarshal(readMessageOfType(t, mobileWS, "viewer_list"), &received)
+		return received.Payload.Viewers
+	}
+
+	viewers := listViewers()
+	if len(viewers) != 2 {
+		t.Fatalf("Expected 2 viewers, got %+v", viewers)
+	}
+	if viewers[0].DisplayName != "Alice" || viewers[0].IPAddress != "203.0.113.7" || viewers[0].ClientID == "" {
+		t.Errorf("Expected Alice first, got %+v", viewers[0])
+	}
+	if viewers[1].DisplayName != "Bob" || viewers[1].IPAddress != "203.0.113.8" {
+		t.Errorf("Expected Bob second, got %+v", viewers[1])
+	}
+
+	// Banning by client ID bans the viewer's IP address
+	send("moderate", models.ModerationRequest{Action: models.ModerationBan, ClientID: viewers[0].ClientID})
+
+	var banned struct {
+		Payload models.ViewerBan `json:"payload"`
+	}
+	json.Unmarshal(readMessageOfType(t, mobileWS, "viewer_banned"), &banned)
+	if banned.Payload.IPAddress != "203.0.113.7" || banned.Payload.ClientID != viewers[0].ClientID {
+		t.Errorf("Unexpected ban %+v", banned.Payload)
+	}
+
+	readMessageOfType(t, aliceWS, "banned")
+	aliceWS.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, _, err := aliceWS.ReadMessage(); err == nil {
+		t.Error("Expected the banned viewer to be disconnected")
+	}
+
+	// Banned viewers can't come back, over WebSocket or SSE, nor use the other viewer endpoints
+	_, resp, err := websocket.DefaultDialer.Dial(viewerURL, fromIP("203.0.113.7"))
+	if err == nil {
+		t.Fatal("Expected the banned viewer's reconnection to fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status %d for a banned viewer", http.StatusForbidden)
+	}
+
+	sseReq, _ := http.NewRequest("GET", server.URL+"/api/streams/"+createResponse.StreamID+"/events", nil)
+	sseReq.Header = fromIP("203.0.113.7")
+	sseResp, err := http.DefaultClient.Do(sseReq)
+	if err != nil {
+		t.Fatalf("Failed to request events: %v", err)
+	}
+	sseResp.Body.Close()
+	if sseResp.StatusCode != http.StatusForbidden {
+		t.Errorf("Expected status %d for a banned SSE viewer, got %d", http.StatusForbidden, sseResp.StatusCode)
+	}
+
+	for _, path := range []string{"", "/chat", "/track", "/export?format=gpx", "/event-log", "/summary"} {
+		req, _ := http.NewRequest("GET", server.URL+"/api/streams/"+createResponse.StreamID+path, nil)
+		req.Header = fromIP("203.0.113.7")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Failed to request %q: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("Expected status %d for a banned viewer on %q, got %d", http.StatusForbidden, path, resp.StatusCode)
+		}
+	}
+
+	// The broadcaster token is never banned
+	broadcasterHeader := fromIP("203.0.113.7")
+	broadcasterHeader.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+	broadcasterWS, _, err := websocket.DefaultDialer.Dial(viewerURL, broadcasterHeader)
+	if err != nil {
+		t.Fatalf("Expected the broadcaster token to bypass bans: %v", err)
+	}
+	broadcasterWS.Close()
+
+	// Kicking by IP address disconnects without banning
+	send("moderate", models.ModerationRequest{Action: models.ModerationKick, IPAddress: "203.0.113.8"})
+	readMessageOfType(t, bobWS, "kicked")
+
+	bobWS, _, err = websocket.DefaultDialer.Dial(viewerURL+"?name=Bob", fromIP("203.0.113.8"))
+	if err != nil {
+		t.Fatalf("Expected a kicked viewer to be able to reconnect: %v", err)
+	}
+	defer bobWS.Close()
+	readMessageOfType(t, bobWS, "snapshot")
+
+	if viewers := listViewers(); len(viewers) != 1 || viewers[0].DisplayName != "Bob" {
+		t.Errorf("Expected only Bob's new connection to be listed, got %+v", viewers)
+	}
+
+	send("moderate", models.ModerationRequest{Action: models.ModerationBan, ClientID: "missing"})
+	var received struct {
+		Payload models.ErrorMessage `json:"payload"`
+	}
+	json.Unmarshal(readMessageOfType(t, mobileWS, "error"), &received)
+	if received.Payload.Message != "Unknown viewer" {
+		t.Errorf("Expected unknown viewer error, got %q", received.Payload.Message)
+	}
+
+	send("moderate", models.ModerationRequest{Action: models.ModerationBan, IPAddress: "not an ip"})
+	json.Unmarshal(readMessageOfType(t, mobileWS, "error"), &received)
+	if !strings.HasPrefix(received.Payload.Message, "Invalid moderate payload") {
+		t.Errorf("Expected invalid moderate error, got %q", received.Payload.Message)
+	}
+}
+
+func TestStreamIDsAreUnique(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	streamIDs := make(map[string]bool)
+	numStreams := 10
+
+	for i := 0; i < numStreams; i++ {
+		createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+		createW := httptest.NewRecorder()
+		testRouter.ServeHTTP(createW, createReq)
+
+		var createResponse models.StreamIDResponse
+		json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+		if streamIDs[createResponse.StreamID] {
+			t.Errorf("Duplicate stream ID generated: %s", createResponse.StreamID)
+		}
+		streamIDs[createResponse.StreamID] = true
+	}
+
+	if len(streamIDs) != numStreams {
+		t.Errorf("Expected %d unique stream IDs, got %d", numStreams, len(streamIDs))
+	}
+}
+
+// ==================== Concurrent Access Tests ====================
+
+func TestConcurrentStreamCreation(t *testing.T) {
+	cleanup := setupTestEnvironment(t)
+	defer cleanup()
+
+	numGoroutines := 20
+	results := make(chan string, numGoroutines)
+	errors := make(chan error, numGoroutines)
+
+	for i := 0; i < numGoroutines; i++ {
+		go func() {
+			createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+			createW := httptest.NewRecorder()
+			testRouter.ServeHTTP(createW, createReq)
+
+			if createW.Code != http.StatusOK {
+				errors <- fmt.Errorf("expected status %d, got %d", http.StatusOK, createW.Code)
+				return
+			}
+
+			var createResponse models.StreamIDResponse
+			if err := json.Unmarshal(createW.Body.Bytes(), &createResponse); err != nil {
+				errors <- err
+				return
+			}
+
+			results <- createResponse.StreamID
+		}()
+	}
+
+	streamIDs := make(map[string]bool)
+	for i := 0; i < numGoroutines; i++ {
+		select {
+		case streamID := <-results:
+			if streamIDs[streamID] {
+				t.Errorf("Duplicate stream ID in concurrent creation: %s", streamID)
+			}
+			streamIDs[streamID] = true
+		case err := <-errors:
+			t.Errorf("Error in concurrent stream creation: %v", err)
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timeout waiting for concurrent stream creation")
+		}
+	}
+}
+
+// ==================== Helper Functions ====================
 
 // createJSONRequest creates an HTTP request with JSON body
 func createJSONRequest(t *testing.T, method, url string, body interface{}) *http.Request {
@@ -838,22 +4063,91 @@ func createJSONRequest(t *testing.T, method, url string, body interface{}) *http
 	return req
 }
 
-// updateStreamData updates the stream data in the database for testing
+// readMessageOfType reads WebSocket messages until one of the given type arrives
+func readMessageOfType(t *testing.T, ws *websocket.Conn, messageType string) []byte {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		ws.SetReadDeadline(deadline)
+		_, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to receive '%s' message: %v", messageType, err)
+		}
+
+		var received models.WebSocketMessage
+		if err := json.Unmarshal(msg, &received); err != nil {
+			t.Fatalf("Failed to parse message: %v", err)
+		}
+
+		if received.Type == messageType {
+			return msg
+		}
+	}
+}
+
+// readMsgPackMessageOfType reads MessagePack frames until a message of the given type arrives
+func readMsgPackMessageOfType(t *testing.T, ws *websocket.Conn, messageType string) map[string]interface{} {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		ws.SetReadDeadline(deadline)
+		frameType, msg, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("Failed to receive '%s' message: %v", messageType, err)
+		}
+
+		if frameType != websocket.BinaryMessage {
+			t.Fatalf("Expected a binary frame, got type %d", frameType)
+		}
+
+		var received map[string]interface{}
+		if err := msgpack.Unmarshal(msg, &received); err != nil {
+			t.Fatalf("Failed to parse message: %v", err)
+		}
+
+		if received["type"] == messageType {
+			return received
+		}
+	}
+}
+
+// readSSEMessageOfType reads Server-Sent Events until a message of the given type arrives
+func readSSEMessageOfType(t *testing.T, events *bufio.Reader, messageType string) []byte {
+	t.Helper()
+	var data []byte
+	for {
+		line, err := events.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to receive '%s' event: %v", messageType, err)
+		}
+
+		line = strings.TrimRight(line, "\n")
+		if strings.HasPrefix(line, "data: ") {
+			data = append(data, strings.TrimPrefix(line, "data: ")...)
+			continue
+		}
+		if line != "" || data == nil {
+			continue // Comment, or blank line between events
+		}
+
+		var received models.WebSocketMessage
+		if err := json.Unmarshal(data, &received); err != nil {
+			t.Fatalf("Failed to parse event: %v", err)
+		}
+		if received.Type == messageType {
+			return data
+		}
+		data = nil
+	}
+}
+
+// updateStreamData updates the stream data in the test store
 func updateStreamData(t *testing.T, streamID string, data models.StreamData) {
 	t.Helper()
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := db.StreamsCollection().UpdateOne(
-		ctx,
-		bson.M{"streamId": streamID},
-		bson.M{
-			"$set": bson.M{
-				"latestData": data,
-				"updatedAt":  time.Now(),
-			},
-		},
-	)
+	err := testStore.Streams().UpdateLatestData(ctx, streamID, data)
 	if err != nil {
 		t.Fatalf("Failed to update stream data: %v", err)
 	}
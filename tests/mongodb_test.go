@@ -0,0 +1,287 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"velocity-be/config"
+	"velocity-be/db"
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// setupMongoStore starts a MongoDB container and connects the db package to it.
+// The test is skipped when no Docker host is available.
+func setupMongoStore(t *testing.T) (store.Store, func()) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	ctx := context.Background()
+
+	// Start MongoDB container
+	mongoContainer, err := mongodb.Run(ctx, "mongo:7.0")
+	if err != nil {
+		t.Fatalf("Failed to start MongoDB container: %v", err)
+	}
+
+	// Get connection string
+	connectionString, err := mongoContainer.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get MongoDB connection string: %v", err)
+	}
+
+	config.AppConfig = &config.Config{
+		GinMode:         "test",
+		MongoDBURI:      connectionString,
+		MongoDBDatabase: "velocity_test",
+		Env:             "test",
+	}
+
+	if err := db.Connect(); err != nil {
+		t.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+
+	return store.NewMongoDB(), func() {
+		db.Disconnect()
+		if err := mongoContainer.Terminate(ctx); err != nil {
+			t.Logf("Failed to terminate MongoDB container: %v", err)
+		}
+	}
+}
+
+// cleanupStreams removes all streams from the test database
+func cleanupStreams(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := db.StreamsCollection().DeleteMany(ctx, bson.M{})
+	if err != nil {
+		t.Logf("Failed to cleanup streams: %v", err)
+	}
+
+	_, err = db.StreamJoinLogsCollection().DeleteMany(ctx, bson.M{})
+	if err != nil {
+		t.Logf("Failed to cleanup stream join logs: %v", err)
+	}
+
+	_, err = db.FeatureFlagsCollection().DeleteMany(ctx, bson.M{})
+	if err != nil {
+		t.Logf("Failed to cleanup feature flags: %v", err)
+	}
+
+	_, err = db.TrackPointsCollection().DeleteMany(ctx, bson.M{})
+	if err != nil {
+		t.Logf("Failed to cleanup track points: %v", err)
+	}
+
+	_, err = db.StreamEventsCollection().DeleteMany(ctx, bson.M{})
+	if err != nil {
+		t.Logf("Failed to cleanup stream events: %v", err)
+	}
+
+	_, err = db.ChatMessagesCollection().DeleteMany(ctx, bson.M{})
+	if err != nil {
+		t.Logf("Failed to cleanup chat messages: %v", err)
+	}
+}
+
+// ==================== MongoDB Repository Tests ====================
+
+func TestMongoStore(t *testing.T) {
+	s, cleanup := setupMongoStore(t)
+	defer cleanup()
+
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	t.Run("streams", func(t *testing.T) {
+		defer cleanupStreams(t)
+
+		old := now.Add(-7 * time.Hour)
+		streams := []models.Stream{
+			{StreamID: "a", CreatedAt: now, IsActive: true, CreatorKey: "ip:203.0.113.7", CreatorTokenKey: "token:app"},
+			{StreamID: "inactive", CreatedAt: old, IsActive: true, CreatorKey: "ip:203.0.113.7"},
+		}
+		for i := range streams {
+			if err := s.Streams().Create(ctx, &streams[i]); err != nil {
+				t.Fatalf("Failed to create stream: %v", err)
+			}
+		}
+
+		if _, err := s.Streams().Get(ctx, "missing"); err != store.ErrNotFound {
+			t.Errorf("Expected ErrNotFound for a missing stream, got %v", err)
+		}
+
+		count, err := s.Streams().CountActiveByCreator(ctx, "ip:203.0.113.7")
+		if err != nil || count != 2 {
+			t.Errorf("Expected 2 active streams for the creator, got %d (%v)", count, err)
+		}
+		count, err = s.Streams().CountActiveByCreator(ctx, "token:app")
+		if err != nil || count != 1 {
+			t.Errorf("Expected 1 active stream for the client token, got %d (%v)", count, err)
+		}
+
+		inactive, err := s.Streams().ListInactive(ctx, now.Add(-6*time.Hour))
+		if err != nil || len(inactive) != 1 || inactive[0].StreamID != "inactive" {
+			t.Errorf("Expected only the inactive stream, got %+v (%v)", inactive, err)
+		}
+
+		if err := s.Streams().UpdateLatestData(ctx, "a", models.StreamData{CurrentSpeedKmh: 42}); err != nil {
+			t.Fatalf("Failed to update latest data: %v", err)
+		}
+		token := models.ShareToken{ID: "mum", Scope: models.ShareScopeLive, ExpiresAt: now.Add(time.Hour)}
+		if err := s.Streams().AddShareToken(ctx, "a", token); err != nil {
+			t.Fatalf("Failed to add share token: %v", err)
+		}
+		if err := s.Streams().RevokeShareToken(ctx, "a", "mum", now); err != nil {
+			t.Fatalf("Failed to revoke share token: %v", err)
+		}
+		if err := s.Streams().AddBan(ctx, "a", models.ViewerBan{IPAddress: "203.0.113.8", BannedAt: now}); err != nil {
+			t.Fatalf("Failed to add ban: %v", err)
+		}
+		for _, ipAddress := range []string{"203.0.113.8", "203.0.113.9"} {
+			if err := s.Streams().AddMute(ctx, "a", models.ViewerMute{IPAddress: ipAddress, MutedAt: now}); err != nil {
+				t.Fatalf("Failed to add mute: %v", err)
+			}
+		}
+		if err := s.Streams().RemoveMute(ctx, "a", "203.0.113.8"); err != nil {
+			t.Fatalf("Failed to remove mute: %v", err)
+		}
+		if err := s.Streams().SaveSummary(ctx, "a", models.TripSummary{StreamID: "a", DistanceKm: 12.5}); err != nil {
+			t.Fatalf("Failed to save summary: %v", err)
+		}
+		if err := s.Streams().MarkDeleted(ctx, "a", now, false); err != nil {
+			t.Fatalf("Failed to mark stream deleted: %v", err)
+		}
+
+		stream, err := s.Streams().Get(ctx, "a")
+		if err != nil {
+			t.Fatalf("Failed to get stream: %v", err)
+		}
+		if stream.LatestData == nil || stream.LatestData.CurrentSpeedKmh != 42 {
+			t.Errorf("Latest data not saved: %+v", stream.LatestData)
+		}
+		if len(stream.ShareTokens) != 1 || stream.ShareTokens[0].RevokedAt == nil {
+			t.Errorf("Expected the share token to be revoked, got %+v", stream.ShareTokens)
+		}
+		if len(stream.Bans) != 1 || stream.Bans[0].IPAddress != "203.0.113.8" {
+			t.Errorf("Ban not saved: %+v", stream.Bans)
+		}
+		if len(stream.Mutes) != 1 || stream.Mutes[0].IPAddress != "203.0.113.9" {
+			t.Errorf("Expected only the second mute to remain, got %+v", stream.Mutes)
+		}
+		if stream.Summary == nil || stream.Summary.DistanceKm != 12.5 {
+			t.Errorf("Summary not saved: %+v", stream.Summary)
+		}
+		if stream.IsActive || stream.DeletedAt == nil || stream.AutoCancelled {
+			t.Errorf("Expected stream to be deleted, got %+v", stream)
+		}
+	})
+
+	t.Run("join logs", func(t *testing.T) {
+		defer cleanupStreams(t)
+
+		joinLog := models.StreamJoinLog{StreamID: "a", JoinedAt: now, ClientID: "client-1", DisplayName: "Alice"}
+		if err := s.JoinLogs().Create(ctx, &joinLog); err != nil {
+			t.Fatalf("Failed to create join log: %v", err)
+		}
+		if joinLog.ID.IsZero() {
+			t.Error("Expected the join log ID to be set")
+		}
+		if err := s.JoinLogs().MarkLeft(ctx, joinLog.ID, now, models.LeaveReasonKicked); err != nil {
+			t.Fatalf("Failed to mark join log left: %v", err)
+		}
+
+		joinLogs, err := s.JoinLogs().List(ctx, "a")
+		if err != nil || len(joinLogs) != 1 {
+			t.Fatalf("Expected 1 join log, got %d (%v)", len(joinLogs), err)
+		}
+		if joinLogs[0].LeftAt == nil || joinLogs[0].LeaveReason != models.LeaveReasonKicked || joinLogs[0].ClientID != "client-1" {
+			t.Errorf("Unexpected join log %+v", joinLogs[0])
+		}
+	})
+
+	t.Run("feature flags", func(t *testing.T) {
+		defer cleanupStreams(t)
+
+		if _, err := s.FeatureFlags().Get(ctx); err != store.ErrNotFound {
+			t.Errorf("Expected ErrNotFound before flags are saved, got %v", err)
+		}
+		if err := s.FeatureFlags().Save(ctx, &models.FeatureFlags{EnableLiveStreams: true}); err != nil {
+			t.Fatalf("Failed to save feature flags: %v", err)
+		}
+
+		flags, err := s.FeatureFlags().Get(ctx)
+		if err != nil || !flags.EnableLiveStreams || flags.EnableCarPlay {
+			t.Errorf("Expected only live streams to be enabled, got %+v (%v)", flags, err)
+		}
+	})
+
+	t.Run("track points", func(t *testing.T) {
+		defer cleanupStreams(t)
+
+		for _, i := range []int{3, 0} {
+			point := models.TrackPoint{StreamID: "a", Timestamp: now.Add(time.Duration(i) * time.Minute), SpeedKmh: float64(i)}
+			if err := s.TrackPoints().Insert(ctx, point); err != nil {
+				t.Fatalf("Failed to insert track point: %v", err)
+			}
+		}
+		var batch []models.TrackPoint
+		for _, i := range []int{4, 1, 2} {
+			batch = append(batch, models.TrackPoint{StreamID: "a", Timestamp: now.Add(time.Duration(i) * time.Minute), SpeedKmh: float64(i)})
+		}
+		if err := s.TrackPoints().InsertMany(ctx, batch); err != nil {
+			t.Fatalf("Failed to insert track points: %v", err)
+		}
+
+		query := store.TrackQuery{StreamID: "a", From: now.Add(time.Minute), To: now.Add(3 * time.Minute)}
+		total, err := s.TrackPoints().Count(ctx, query)
+		if err != nil || total != 3 {
+			t.Errorf("Expected 3 points in range, got %d (%v)", total, err)
+		}
+
+		query.Offset = 1
+		query.Limit = 5
+		points, err := s.TrackPoints().List(ctx, query)
+		if err != nil || len(points) != 2 || points[0].SpeedKmh != 2 || points[1].SpeedKmh != 3 {
+			t.Errorf("Expected points 2 and 3 in order, got %+v (%v)", points, err)
+		}
+	})
+
+	t.Run("stream events", func(t *testing.T) {
+		defer cleanupStreams(t)
+
+		for _, i := range []int{1, 0} {
+			event := models.StreamEvent{StreamID: "a", Type: fmt.Sprintf("event %d", i), Timestamp: now.Add(time.Duration(i) * time.Second)}
+			if err := s.StreamEvents().Insert(ctx, event); err != nil {
+				t.Fatalf("Failed to insert stream event: %v", err)
+			}
+		}
+
+		events, err := s.StreamEvents().List(ctx, "a", 10)
+		if err != nil || len(events) != 2 || events[0].Type != "event 0" {
+			t.Errorf("Expected 2 events oldest first, got %+v (%v)", events, err)
+		}
+	})
+
+	t.Run("chat messages", func(t *testing.T) {
+		defer cleanupStreams(t)
+
+		for _, i := range []int{2, 0, 3, 1} {
+			message := models.ChatMessage{StreamID: "a", Text: fmt.Sprintf("message %d", i), SentAt: now.Add(time.Duration(i) * time.Second)}
+			if err := s.ChatMessages().Insert(ctx, message); err != nil {
+				t.Fatalf("Failed to insert chat message: %v", err)
+			}
+		}
+
+		latest, err := s.ChatMessages().List(ctx, "a", 2)
+		if err != nil || len(latest) != 2 || latest[0].Text != "message 2" || latest[1].Text != "message 3" {
+			t.Errorf("Expected the 2 latest messages oldest first, got %+v (%v)", latest, err)
+		}
+	})
+}
@@ -0,0 +1,519 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"velocity-be/hub"
+	"velocity-be/models"
+	"velocity-be/store"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// embeddedStores returns the storage backends that run without external services
+func embeddedStores(t *testing.T) map[string]func() store.Store {
+	return map[string]func() store.Store{
+		"memory": func() store.Store {
+			return store.NewMemory()
+		},
+		"bolt": func() store.Store {
+			s, err := store.NewBolt(filepath.Join(t.TempDir(), "velocity.db"))
+			if err != nil {
+				t.Fatalf("Failed to open BoltDB store: %v", err)
+			}
+			return s
+		},
+	}
+}
+
+// ==================== Embedded Storage Tests ====================
+
+func TestEmbeddedStoreStreamLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for name, newStore := range embeddedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			defer s.Close()
+
+			h := hub.NewHub(s)
+			go h.Run()
+			router := setupRouter(s, h)
+
+			// Create
+			createReq, _ := http.NewRequest("POST", "/api/streams", nil)
+			createW := httptest.NewRecorder()
+			router.ServeHTTP(createW, createReq)
+
+			if createW.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, createW.Code)
+			}
+
+			var createResponse models.StreamIDResponse
+			json.Unmarshal(createW.Body.Bytes(), &createResponse)
+
+			// Broadcast a frame so it's recorded
+			server := httptest.NewServer(router)
+			defer server.Close()
+
+			mobileURL := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws/mobile/" + createResponse.StreamID + "?token=" + createResponse.BroadcasterToken
+			mobileWS, _, err := websocket.DefaultDialer.Dial(mobileURL, nil)
+			if err != nil {
+				t.Fatalf("Failed to connect mobile WebSocket: %v", err)
+			}
+			defer mobileWS.Close()
+
+			message := models.WebSocketMessage{
+				Type: "stream_data",
+				Payload: models.StreamData{
+					CurrentLocation: models.CurrentLocation{Latitude: 51.5, Longitude: -0.12},
+					CurrentSpeedKmh: 80,
+				},
+			}
+			if err := mobileWS.WriteJSON(message); err != nil {
+				t.Fatalf("Failed to send message: %v", err)
+			}
+
+			// Stream data and track points are written asynchronously
+			var stream models.Stream
+			var track models.TrackResponse
+			deadline := time.Now().Add(5 * time.Second)
+			for time.Now().Before(deadline) {
+				getReq, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID, nil)
+				getW := httptest.NewRecorder()
+				router.ServeHTTP(getW, getReq)
+				json.Unmarshal(getW.Body.Bytes(), &stream)
+
+				trackReq, _ := http.NewRequest("GET", "/api/streams/"+createResponse.StreamID+"/track", nil)
+				trackW := httptest.NewRecorder()
+				router.ServeHTTP(trackW, trackReq)
+				json.Unmarshal(trackW.Body.Bytes(), &track)
+
+				if stream.LatestData != nil && track.Total == 1 {
+					break
+				}
+				time.Sleep(50 * time.Millisecond)
+			}
+
+			if stream.LatestData == nil || stream.LatestData.CurrentSpeedKmh != 80 {
+				t.Errorf("Expected latest data to be stored, got %+v", stream.LatestData)
+			}
+
+			if track.Total != 1 || len(track.Points) != 1 {
+				t.Errorf("Expected 1 track point, got total %d", track.Total)
+			}
+
+			// Delete
+			deleteReq, _ := http.NewRequest("DELETE", "/api/streams/"+createResponse.StreamID, nil)
+			deleteReq.Header.Set("Authorization", "Bearer "+createResponse.BroadcasterToken)
+			deleteW := httptest.NewRecorder()
+			router.ServeHTTP(deleteW, deleteReq)
+
+			if deleteW.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, deleteW.Code)
+			}
+
+			deleted, err := s.Streams().Get(context.Background(), createResponse.StreamID)
+			if err != nil {
+				t.Fatalf("Failed to get stream: %v", err)
+			}
+
+			if deleted.DeletedAt == nil || deleted.IsActive {
+				t.Error("Expected stream to be soft deleted")
+			}
+
+			if deleted.BroadcasterTokenHash == "" {
+				t.Error("Expected the broadcaster token hash to be persisted")
+			}
+
+			// Not found
+			getReq, _ := http.NewRequest("GET", "/api/streams/missing", nil)
+			getW := httptest.NewRecorder()
+			router.ServeHTTP(getW, getReq)
+
+			if getW.Code != http.StatusNotFound {
+				t.Errorf("Expected status %d, got %d", http.StatusNotFound, getW.Code)
+			}
+		})
+	}
+}
+
+func TestEmbeddedStoreFeatureFlags(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for name, newStore := range embeddedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			defer s.Close()
+
+			router := setupRouter(s, hub.NewHub(s))
+
+			if _, err := s.FeatureFlags().Get(context.Background()); err != store.ErrNotFound {
+				t.Errorf("Expected ErrNotFound before flags are saved, got %v", err)
+			}
+
+			err := s.FeatureFlags().Save(context.Background(), &models.FeatureFlags{EnableLiveStreams: true})
+			if err != nil {
+				t.Fatalf("Failed to save feature flags: %v", err)
+			}
+
+			req, _ := http.NewRequest("GET", "/api/feature-flags", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			var response models.FeatureFlagsResponse
+			json.Unmarshal(w.Body.Bytes(), &response)
+
+			if !response.EnableLiveStreams || response.EnableCarPlay {
+				t.Errorf("Expected only live streams to be enabled, got %+v", response)
+			}
+		})
+	}
+}
+
+func TestEmbeddedStoreTrackQuery(t *testing.T) {
+	ctx := context.Background()
+	start := time.Date(2025, 12, 30, 10, 0, 0, 0, time.UTC)
+
+	for name, newStore := range embeddedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			defer s.Close()
+
+			// Insert out of order, one by one and in a batch, and a point of another stream
+			for _, i := range []int{3, 0} {
+				point := models.TrackPoint{StreamID: "a", Timestamp: start.Add(time.Duration(i) * time.Minute), SpeedKmh: float64(i)}
+				if err := s.TrackPoints().Insert(ctx, point); err != nil {
+					t.Fatalf("Failed to insert track point: %v", err)
+				}
+			}
+			var batch []models.TrackPoint
+			for _, i := range []int{4, 1, 2} {
+				batch = append(batch, models.TrackPoint{StreamID: "a", Timestamp: start.Add(time.Duration(i) * time.Minute), SpeedKmh: float64(i)})
+			}
+			if err := s.TrackPoints().InsertMany(ctx, batch); err != nil {
+				t.Fatalf("Failed to insert track points: %v", err)
+			}
+			s.TrackPoints().Insert(ctx, models.TrackPoint{StreamID: "b", Timestamp: start})
+
+			query := store.TrackQuery{
+				StreamID: "a",
+				From:     start.Add(time.Minute),
+				To:       start.Add(3 * time.Minute),
+			}
+
+			total, err := s.TrackPoints().Count(ctx, query)
+			if err != nil || total != 3 {
+				t.Errorf("Expected 3 points in range, got %d (%v)", total, err)
+			}
+
+			query.Offset = 1
+			query.Limit = 5
+			points, err := s.TrackPoints().List(ctx, query)
+			if err != nil {
+				t.Fatalf("Failed to list track points: %v", err)
+			}
+
+			if len(points) != 2 || points[0].SpeedKmh != 2 || points[1].SpeedKmh != 3 {
+				t.Errorf("Expected points 2 and 3 in order, got %+v", points)
+			}
+		})
+	}
+}
+
+func TestEmbeddedStoreListInactive(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	old := now.Add(-7 * time.Hour)
+	recent := now.Add(-time.Hour)
+
+	for name, newStore := range embeddedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			defer s.Close()
+
+			streams := []models.Stream{
+				{StreamID: "never-connected", CreatedAt: old, IsActive: true},
+				{StreamID: "left-long-ago", CreatedAt: old, IsActive: true, LastConnectionAt: &old},
+				{StreamID: "recently-connected", CreatedAt: old, IsActive: true, LastConnectionAt: &recent},
+				{StreamID: "new", CreatedAt: recent, IsActive: true},
+				{StreamID: "deleted", CreatedAt: old, IsActive: false, DeletedAt: &old},
+			}
+			for i := range streams {
+				if err := s.Streams().Create(ctx, &streams[i]); err != nil {
+					t.Fatalf("Failed to create stream: %v", err)
+				}
+			}
+
+			inactive, err := s.Streams().ListInactive(ctx, now.Add(-6*time.Hour))
+			if err != nil {
+				t.Fatalf("Failed to list inactive streams: %v", err)
+			}
+
+			found := make(map[string]bool)
+			for _, stream := range inactive {
+				found[stream.StreamID] = true
+			}
+
+			if len(found) != 2 || !found["never-connected"] || !found["left-long-ago"] {
+				t.Errorf("Expected never-connected and left-long-ago, got %v", found)
+			}
+
+			if err := s.Streams().MarkDeleted(ctx, "left-long-ago", now, true); err != nil {
+				t.Fatalf("Failed to mark stream deleted: %v", err)
+			}
+
+			stream, err := s.Streams().Get(ctx, "left-long-ago")
+			if err != nil {
+				t.Fatalf("Failed to get stream: %v", err)
+			}
+
+			if !stream.AutoCancelled || stream.IsActive || stream.DeletedAt == nil {
+				t.Errorf("Expected stream to be auto-cancelled, got %+v", stream)
+			}
+		})
+	}
+}
+
+func TestEmbeddedStoreShareTokens(t *testing.T) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+
+	for name, newStore := range embeddedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			defer s.Close()
+
+			if err := s.Streams().Create(ctx, &models.Stream{StreamID: "a", IsActive: true}); err != nil {
+				t.Fatalf("Failed to create stream: %v", err)
+			}
+
+			for _, id := range []string{"mum", "dad"} {
+				token := models.ShareToken{ID: id, Scope: models.ShareScopeLive, ExpiresAt: expiresAt}
+				if err := s.Streams().AddShareToken(ctx, "a", token); err != nil {
+					t.Fatalf("Failed to add share token: %v", err)
+				}
+			}
+
+			before, _ := s.Streams().Get(ctx, "a")
+
+			if err := s.Streams().RevokeShareToken(ctx, "a", "mum", time.Now()); err != nil {
+				t.Fatalf("Failed to revoke share token: %v", err)
+			}
+
+			stream, err := s.Streams().Get(ctx, "a")
+			if err != nil {
+				t.Fatalf("Failed to get stream: %v", err)
+			}
+
+			if len(stream.ShareTokens) != 2 || stream.ShareTokens[0].RevokedAt == nil || stream.ShareTokens[1].RevokedAt != nil {
+				t.Errorf("Expected only the first share token to be revoked, got %+v", stream.ShareTokens)
+			}
+
+			if !stream.ShareTokens[1].ExpiresAt.Equal(expiresAt) {
+				t.Errorf("Expected expiry %v, got %v", expiresAt, stream.ShareTokens[1].ExpiresAt)
+			}
+
+			if before.ShareTokens[0].RevokedAt != nil {
+				t.Error("Expected previously loaded streams to be unaffected by the revocation")
+			}
+		})
+	}
+}
+
+func TestEmbeddedStoreCountActiveByCreator(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+
+	for name, newStore := range embeddedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			defer s.Close()
+
+			streams := []models.Stream{
+				{StreamID: "active", CreatorKey: "alice", IsActive: true},
+				{StreamID: "also-active", CreatorKey: "alice", IsActive: true},
+				{StreamID: "deleted", CreatorKey: "alice", IsActive: false, DeletedAt: &now},
+				{StreamID: "someone-else", CreatorKey: "bob", IsActive: true},
+				{StreamID: "alice-token", CreatorKey: "carol", CreatorTokenKey: "alice", IsActive: true},
+				{StreamID: "anonymous", IsActive: true},
+			}
+			for i := range streams {
+				if err := s.Streams().Create(ctx, &streams[i]); err != nil {
+					t.Fatalf("Failed to create stream: %v", err)
+				}
+			}
+
+			count, err := s.Streams().CountActiveByCreator(ctx, "alice")
+			if err != nil || count != 3 {
+				t.Errorf("Expected 3 active streams by creator or token key, got %d (%v)", count, err)
+			}
+
+			if err := s.Streams().MarkDeleted(ctx, "active", now, false); err != nil {
+				t.Fatalf("Failed to mark stream deleted: %v", err)
+			}
+
+			count, err = s.Streams().CountActiveByCreator(ctx, "alice")
+			if err != nil || count != 2 {
+				t.Errorf("Expected 2 active streams after deleting one, got %d (%v)", count, err)
+			}
+		})
+	}
+}
+
+func TestEmbeddedStoreTripSummary(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	for name, newStore := range embeddedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			defer s.Close()
+
+			stream := models.Stream{StreamID: "summarised", IsActive: true}
+			if err := s.Streams().Create(ctx, &stream); err != nil {
+				t.Fatalf("Failed to create stream: %v", err)
+			}
+
+			for _, streamID := range []string{"summarised", "summarised", "other"} {
+				joinLog := models.StreamJoinLog{StreamID: streamID, JoinedAt: now}
+				if err := s.JoinLogs().Create(ctx, &joinLog); err != nil {
+					t.Fatalf("Failed to create join log: %v", err)
+				}
+			}
+
+			joinLogs, err := s.JoinLogs().List(ctx, "summarised")
+			if err != nil || len(joinLogs) != 2 {
+				t.Errorf("Expected 2 join logs, got %d (%v)", len(joinLogs), err)
+			}
+
+			summary := models.TripSummary{
+				StreamID:        "summarised",
+				EndedAt:         now,
+				DistanceKm:      12.5,
+				StartAddress:    &models.TripAddress{City: "London"},
+				PeakViewerCount: 2,
+			}
+			if err := s.Streams().SaveSummary(ctx, "summarised", summary); err != nil {
+				t.Fatalf("Failed to save summary: %v", err)
+			}
+
+			saved, err := s.Streams().Get(ctx, "summarised")
+			if err != nil {
+				t.Fatalf("Failed to get stream: %v", err)
+			}
+			if saved.Summary == nil || saved.Summary.DistanceKm != 12.5 || !saved.Summary.EndedAt.Equal(now) ||
+				saved.Summary.StartAddress == nil || saved.Summary.StartAddress.City != "London" || saved.Summary.PeakViewerCount != 2 {
+				t.Errorf("Summary not saved: %+v", saved.Summary)
+			}
+		})
+	}
+}
+
+func TestEmbeddedStoreChatHistory(t *testing.T) {
+	ctx := context.Background()
+	start := time.Now().UTC().Truncate(time.Millisecond)
+
+	for name, newStore := range embeddedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			defer s.Close()
+
+			// Inserted out of order, as concurrent writes may land
+			for _, i := range []int{2, 0, 3, 1} {
+				message := models.ChatMessage{
+					StreamID: "chatty",
+					Text:     fmt.Sprintf("message %d", i),
+					SentAt:   start.Add(time.Duration(i) * time.Second),
+				}
+				if err := s.ChatMessages().Insert(ctx, message); err != nil {
+					t.Fatalf("Failed to insert chat message: %v", err)
+				}
+			}
+			if err := s.ChatMessages().Insert(ctx, models.ChatMessage{StreamID: "other", Text: "elsewhere", SentAt: start}); err != nil {
+				t.Fatalf("Failed to insert chat message: %v", err)
+			}
+
+			all, err := s.ChatMessages().List(ctx, "chatty", 10)
+			if err != nil || len(all) != 4 {
+				t.Fatalf("Expected 4 messages, got %d (%v)", len(all), err)
+			}
+			for i, message := range all {
+				if message.Text != fmt.Sprintf("message %d", i) || message.ID.IsZero() {
+					t.Errorf("Expected message %d in order with an ID, got %+v", i, message)
+				}
+			}
+
+			latest, err := s.ChatMessages().List(ctx, "chatty", 2)
+			if err != nil || len(latest) != 2 || latest[0].Text != "message 2" || latest[1].Text != "message 3" {
+				t.Errorf("Expected the 2 latest messages oldest first, got %+v (%v)", latest, err)
+			}
+		})
+	}
+}
+
+func TestEmbeddedStoreViewerBans(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	for name, newStore := range embeddedStores(t) {
+		t.Run(name, func(t *testing.T) {
+			s := newStore()
+			defer s.Close()
+
+			stream := models.Stream{StreamID: "moderated", IsActive: true}
+			if err := s.Streams().Create(ctx, &stream); err != nil {
+				t.Fatalf("Failed to create stream: %v", err)
+			}
+
+			joinLog := models.StreamJoinLog{StreamID: "moderated", JoinedAt: now, IPAddress: "203.0.113.7", ClientID: "client-1", DisplayName: "Alice"}
+			if err := s.JoinLogs().Create(ctx, &joinLog); err != nil {
+				t.Fatalf("Failed to create join log: %v", err)
+			}
+
+			joinLogs, err := s.JoinLogs().List(ctx, "moderated")
+			if err != nil || len(joinLogs) != 1 || joinLogs[0].ClientID != "client-1" || joinLogs[0].DisplayName != "Alice" {
+				t.Errorf("Expected the join log with its client ID and display name, got %+v (%v)", joinLogs, err)
+			}
+
+			ban := models.ViewerBan{IPAddress: "203.0.113.7", ClientID: "client-1", BannedAt: now}
+			if err := s.Streams().AddBan(ctx, "moderated", ban); err != nil {
+				t.Fatalf("Failed to add ban: %v", err)
+			}
+
+			saved, err := s.Streams().Get(ctx, "moderated")
+			if err != nil {
+				t.Fatalf("Failed to get stream: %v", err)
+			}
+			if len(saved.Bans) != 1 || saved.Bans[0].IPAddress != "203.0.113.7" || !saved.Bans[0].BannedAt.Equal(now) {
+				t.Errorf("Ban not saved: %+v", saved.Bans)
+			}
+
+			for _, ipAddress := range []string{"203.0.113.8", "203.0.113.9"} {
+				if err := s.Streams().AddMute(ctx, "moderated", models.ViewerMute{IPAddress: ipAddress, MutedAt: now}); err != nil {
+					t.Fatalf("Failed to add mute: %v", err)
+				}
+			}
+			if err := s.Streams().RemoveMute(ctx, "moderated", "203.0.113.8"); err != nil {
+				t.Fatalf("Failed to remove mute: %v", err)
+			}
+
+			saved, err = s.Streams().Get(ctx, "moderated")
+			if err != nil {
+				t.Fatalf("Failed to get stream: %v", err)
+			}
+			if len(saved.Mutes) != 1 || saved.Mutes[0].IPAddress != "203.0.113.9" {
+				t.Errorf("Expected only the second mute to remain, got %+v", saved.Mutes)
+			}
+		})
+	}
+}
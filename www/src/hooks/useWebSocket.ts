@@ -1,5 +1,5 @@
 import { useState, useEffect, useRef, useCallback } from 'react';
-import type { StreamData, WebSocketMessage, ConnectionStatus } from '../types/stream';
+import type { StreamData, StreamSnapshot, WebSocketMessage, ConnectionStatus } from '../types/stream';
 
 // Use relative URLs to go through Vite proxy (or same origin in production)
 const getWebSocketUrl = () => {
@@ -19,6 +19,22 @@ const getApiUrl = () => {
   return '';
 };
 
+// Passcode or invite code of a private stream, shared as ?code= in the viewer link
+const getAccessCode = () => new URLSearchParams(window.location.search).get('code');
+
+// Share token minted by the broadcaster, shared as ?share= in the viewer link
+const getShareToken = () => new URLSearchParams(window.location.search).get('share');
+
+// Headers carrying the viewer's access code and share token
+const getAccessHeaders = (): Record<string, string> => {
+  const headers: Record<string, string> = {};
+  const accessCode = getAccessCode();
+  const shareToken = getShareToken();
+  if (accessCode) headers['X-Access-Code'] = accessCode;
+  if (shareToken) headers['X-Share-Token'] = shareToken;
+  return headers;
+};
+
 interface UseWebSocketResult {
   streamData: StreamData | null;
   status: ConnectionStatus;
@@ -47,13 +63,24 @@ export function useWebSocket(streamId: string | null): UseWebSocketResult {
     
     try {
       const apiUrl = getApiUrl();
-      const response = await fetch(`${apiUrl}/api/streams/${streamId}`);
+      const response = await fetch(`${apiUrl}/api/streams/${streamId}`, {
+        headers: getAccessHeaders(),
+      });
       
       if (response.status === 404) {
         setError('Stream not found');
         setStatus('error');
         return false;
       }
+
+      if (response.status === 401 || response.status === 403) {
+        const body = await response.json().catch(() => null);
+        setError(body?.error?.startsWith('Share token')
+          ? 'This share link has expired or was revoked'
+          : 'This stream is private, a valid passcode or invite code is required');
+        setStatus('error');
+        return false;
+      }
       
       if (response.status === 410) {
         setIsStreamClosed(true);
@@ -120,7 +147,13 @@ export function useWebSocket(streamId: string | null): UseWebSocketResult {
 
     try {
       const wsUrl = getWebSocketUrl();
-      const ws = new WebSocket(`${wsUrl}/ws/viewer/${streamId}`);
+      const params = new URLSearchParams();
+      const accessCode = getAccessCode();
+      const shareToken = getShareToken();
+      if (accessCode) params.set('code', accessCode);
+      if (shareToken) params.set('share', shareToken);
+      const query = params.toString() ? `?${params}` : '';
+      const ws = new WebSocket(`${wsUrl}/ws/viewer/${streamId}${query}`);
       wsRef.current = ws;
 
       ws.onopen = () => {
@@ -144,6 +177,12 @@ export function useWebSocket(streamId: string | null): UseWebSocketResult {
           
           if (message.type === 'stream_data') {
             setStreamData(message.payload as StreamData);
+          } else if (message.type === 'snapshot') {
+            // Late-join snapshot of the stream's current state
+            const snapshot = message.payload as StreamSnapshot;
+            if (snapshot.latestData) {
+              setStreamData(snapshot.latestData);
+            }
           } else if (message.type === 'error') {
             const payload = message.payload as { message: string };
             setError(payload.message);
@@ -43,11 +43,40 @@ export interface StreamData {
   expectedDistanceKm?: number;
   car?: Car;
   isPaused: boolean;
+  progress?: RouteProgress; // Computed by the server
+}
+
+// RouteProgress is the server-computed remaining distance and ETA
+export interface RouteProgress {
+  method: 'route' | 'straight_line';
+  remainingDistanceKm: number;
+  averageSpeedKmh: number;
+  etaSeconds?: number;
+  estimatedArrival?: string;
+  snappedLatitude?: number;
+  snappedLongitude?: number;
+  distanceFromRouteMeters?: number;
+}
+
+export interface TrackPoint {
+  timestamp: string;
+  latitude: number;
+  longitude: number;
+  speedKmh: number;
+  isPaused: boolean;
+}
+
+// StreamSnapshot is sent to a viewer as soon as it joins
+export interface StreamSnapshot {
+  streamId: string;
+  latestData?: StreamData;
+  recentTrack: TrackPoint[];
+  broadcasterConnected: boolean;
 }
 
 export interface WebSocketMessage {
-  type: 'stream_data' | 'viewer_count' | 'error' | 'stream_closed';
-  payload: StreamData | { viewerCount: number } | { message: string };
+  type: 'stream_data' | 'snapshot' | 'viewer_count' | 'error' | 'stream_closed';
+  payload: StreamData | StreamSnapshot | { viewerCount: number } | { message: string };
 }
 
 export type ConnectionStatus = 'connecting' | 'connected' | 'disconnected' | 'error' | 'closed';